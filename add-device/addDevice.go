@@ -2,8 +2,8 @@ package main
 
 import (
 	"gin-crud/initializers"
-	"gin-crud/models"
-	"github.com/google/uuid"
+	"gin-crud/service"
+	"gin-crud/utils"
 	"log"
 )
 
@@ -11,14 +11,12 @@ func main() {
 	initializers.LoadEnvVariables()
 	initializers.DatabaseInit()
 
-	device := models.Device{
-		ID: uuid.New(),
-	}
-
-	if err := models.CreateDevice(&device); err != nil {
+	device, err := service.CreateClaimableDevice(initializers.DB)
+	if err != nil {
 		log.Println("failed creating new device")
 		return
 	}
 
-	log.Println("successfully adding a device")
+	log.Printf("successfully adding a device id: %s secret: %s\n", device.ID, device.SecretKey)
+	log.Printf("claim code: %s expires: %s qr: %s\n", utils.FormatClaimCode(*device.ClaimCode), device.ClaimCodeExpiresAt.Format("2006-01-02"), service.ClaimQRPayload(*device.ClaimCode))
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"log"
+)
+
+// Devices created before gateway requests were signed have no secret and are rejected by the device
+// auth filter. This gives each of them one; flash the printed secret onto the device.
+func main() {
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+
+	devices, err := models.BackfillDeviceSecrets(initializers.DB)
+	for _, device := range devices {
+		log.Printf("device id: %s secret: %s\n", device.ID, device.SecretKey)
+	}
+	if err != nil {
+		log.Fatalf("Failed backfilling device secrets after %d devices: %v", len(devices), err)
+	}
+
+	log.Printf("Generated secrets for %d devices\n", len(devices))
+}
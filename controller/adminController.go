@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"gin-crud/config"
+	"gin-crud/service"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,4 +17,11 @@ func AdminController(r *gin.Engine) {
 	//r.POST("/admin/create-user", config.AdminAuthFilter, service.CreateParticipant)
 	//
 	//r.POST("/admin/add-device", config.AdminAuthFilter, service.AddDevice)
+	r.POST("/admin/device/:id/rotate-secret", config.AdminAuthFilter, service.AdminRotateDeviceSecret)
+	r.POST("/admin/device/:id/claim-code", config.AdminAuthFilter, service.AdminIssueClaimCode)
+	r.POST("/admin/reading/deduplicate", config.AdminAuthFilter, service.AdminDeduplicateReadings)
+
+	r.POST("/admin/metric", config.AdminAuthFilter, service.CreateMetricDefinition)
+	r.PUT("/admin/metric/:name", config.AdminAuthFilter, service.UpdateMetricDefinition)
+	r.DELETE("/admin/metric/:name", config.AdminAuthFilter, service.DeleteMetricDefinition)
 }
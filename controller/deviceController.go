@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"gin-crud/config"
 	"gin-crud/service"
 	"github.com/gin-gonic/gin"
 )
 
 func DeviceController(r *gin.Engine) {
 	r.GET("/device-gateway/:id/:oxygen_level/:water_temp/:ec_level/:ph_level/:time_stamp",
-		service.ReceiveAndSaveData)
+		config.DeviceAuthFilter, service.ReceiveAndSaveData)
+	r.POST("/device-gateway", config.DeviceAuthFilter, service.ReceiveBatchData)
 }
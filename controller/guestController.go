@@ -14,4 +14,7 @@ func GuestController(r *gin.Engine) {
 
 	r.POST("/forgot-password", service.RecoveryPassword)
 	r.POST("/reset-password/:token", config.RecoveryAuthFilter, service.ResetPassword)
+
+	r.POST("/device-transfer/:token/accept", service.AcceptDeviceTransferByToken)
+	r.POST("/device-transfer/:token/decline", service.DeclineDeviceTransferByToken)
 }
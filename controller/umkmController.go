@@ -9,22 +9,93 @@ import (
 func UserController(r *gin.Engine) {
 	r.GET("/user", config.AuthFilter, service.GetUserData)
 	r.PUT("/user", config.AuthFilter, service.UpdateData)
+	r.GET("/user/notification-preference", config.AuthFilter, service.GetNotificationPreference)
+	r.PUT("/user/notification-preference", config.AuthFilter, service.UpdateNotificationPreference)
 
 	r.GET("/devices", config.AuthFilter, service.GetAllUserDevices)
 
 	r.GET("/device/:id", config.AuthFilter, service.GetDeviceById)
 	r.PUT("/device/:id", config.AuthFilter, service.UpdateDeviceName)
+	r.POST("/device/:id/rotate-secret", config.AuthFilter, service.RotateDeviceSecret)
+	r.GET("/device/:id/stream", config.AuthFilter, service.StreamDeviceReadings)
+	r.GET("/device/:id/export", config.AuthFilter, service.ExportDeviceReadings)
+	r.POST("/device/:id/import", config.AuthFilter, service.ImportDeviceReadings)
+	r.GET("/device/:id/rejected", config.AuthFilter, service.GetRejectedReadings)
+	r.GET("/device/:id/plausibility", config.AuthFilter, service.GetDevicePlausibilityRanges)
+	r.PUT("/device/:id/plausibility", config.AuthFilter, service.SaveDevicePlausibilityRange)
+	r.DELETE("/device/:id/plausibility/:metric", config.AuthFilter, service.DeleteDevicePlausibilityRange)
+	r.GET("/device/:id/calibration", config.AuthFilter, service.GetDeviceCalibrations)
+	r.POST("/device/:id/calibration", config.AuthFilter, service.CreateCalibration)
+	r.PUT("/device/:id/calibration/:calibration_id", config.AuthFilter, service.UpdateCalibration)
+	r.DELETE("/device/:id/calibration/:calibration_id", config.AuthFilter, service.DeleteCalibration)
+	r.POST("/device/:id/transfer", config.AuthFilter, service.StartDeviceTransfer)
+	r.GET("/device/:id/share", config.AuthFilter, service.GetDeviceShares)
+	r.PUT("/device/:id/share", config.AuthFilter, service.ShareDevice)
+	r.DELETE("/device/:id/share/:share_id", config.AuthFilter, service.DeleteDeviceShare)
 
-	r.POST("/device/register/:id", config.AuthFilter, service.RegisterDeviceById)
+	r.GET("/transfer", config.AuthFilter, service.GetDeviceTransfers)
+	r.GET("/transfer/:id/audit", config.AuthFilter, service.GetDeviceTransferAudit)
+	r.POST("/transfer/:id/accept", config.AuthFilter, service.AcceptDeviceTransfer)
+	r.POST("/transfer/:id/decline", config.AuthFilter, service.DeclineDeviceTransfer)
+	r.POST("/transfer/:id/cancel", config.AuthFilter, service.CancelDeviceTransfer)
+
+	r.GET("/device/archive", config.AuthFilter, service.GetDeviceArchives)
+	r.GET("/device/archive/:id/readings", config.AuthFilter, service.GetDeviceArchiveReadings)
+	r.DELETE("/device/archive/:id", config.AuthFilter, service.DeleteDeviceArchive)
+
+	r.POST("/device/register/:code", config.AuthFilter, service.RegisterDeviceByClaimCode)
 	r.DELETE("/device/delete/:id", config.AuthFilter, service.DeleteDeviceById)
 
 	r.POST("/device/monitor-date-time", config.AuthFilter, service.GetMonitoringData)
+	r.POST("/device/aggregate", config.AuthFilter, service.GetDeviceAggregation)
 
 	r.POST("/group/create", config.AuthFilter, service.CreateDeviceGroup)
 	r.GET("/group", config.AuthFilter, service.GetAllGroup)
 	r.GET("/group/:id", config.AuthFilter, service.GetGroupById)
 	r.DELETE("/group/:id", config.AuthFilter, service.DeleteGroupById)
 	r.PUT("/group/:id", config.AuthFilter, service.RenameGroup)
+	r.POST("/group/:id/aggregate", config.AuthFilter, service.GetGroupAggregation)
+	r.GET("/group/:id/stream", config.AuthFilter, service.StreamGroupReadings)
+	r.GET("/group/:id/export", config.AuthFilter, service.ExportGroupReadings)
+	r.GET("/group/:id/share", config.AuthFilter, service.GetGroupShares)
+	r.PUT("/group/:id/share", config.AuthFilter, service.ShareGroup)
+	r.DELETE("/group/:id/share/:share_id", config.AuthFilter, service.DeleteGroupShare)
+
+	r.GET("/shared", config.AuthFilter, service.GetSharedWithMe)
+
+	r.POST("/organization", config.AuthFilter, service.CreateOrganization)
+	r.GET("/organization", config.AuthFilter, service.GetOrganizations)
+	r.PUT("/organization/active", config.AuthFilter, service.SetActiveOrganization)
+	r.POST("/organization/invitation/:token/accept", config.AuthFilter, service.AcceptOrganizationInvitation)
+	r.GET("/organization/:id", config.AuthFilter, service.GetOrganizationById)
+	r.PUT("/organization/:id", config.AuthFilter, service.UpdateOrganization)
+	r.GET("/organization/:id/member", config.AuthFilter, service.GetOrganizationMembers)
+	r.PUT("/organization/:id/member/:user_id", config.AuthFilter, service.UpdateOrganizationMember)
+	r.DELETE("/organization/:id/member/:user_id", config.AuthFilter, service.RemoveOrganizationMember)
+	r.POST("/organization/:id/invitation", config.AuthFilter, service.InviteOrganizationMember)
+	r.GET("/organization/:id/invitation", config.AuthFilter, service.GetOrganizationInvitations)
+	r.DELETE("/organization/:id/invitation/:invitation_id", config.AuthFilter, service.DeleteOrganizationInvitation)
+
+	r.POST("/alert/rule/create", config.AuthFilter, service.CreateAlertRule)
+	r.GET("/alert/rule", config.AuthFilter, service.GetAllAlertRules)
+	r.GET("/alert/rule/:id", config.AuthFilter, service.GetAlertRuleById)
+	r.PUT("/alert/rule/:id", config.AuthFilter, service.UpdateAlertRule)
+	r.DELETE("/alert/rule/:id", config.AuthFilter, service.DeleteAlertRule)
+	r.GET("/alert/history", config.AuthFilter, service.GetAlertHistory)
+
+	r.POST("/webhook/create", config.AuthFilter, service.CreateWebhook)
+	r.GET("/webhook", config.AuthFilter, service.GetAllWebhooks)
+	r.GET("/webhook/:id", config.AuthFilter, service.GetWebhookById)
+	r.PUT("/webhook/:id", config.AuthFilter, service.UpdateWebhook)
+	r.DELETE("/webhook/:id", config.AuthFilter, service.DeleteWebhook)
+	r.GET("/webhook/:id/deliveries", config.AuthFilter, service.GetWebhookDeliveries)
+
+	r.GET("/metric", config.AuthFilter, service.GetMetricDefinitions)
+
+	r.GET("/retention/policy", config.AuthFilter, service.GetRetentionPolicies)
+	r.PUT("/retention/policy", config.AuthFilter, service.SaveRetentionPolicy)
+	r.DELETE("/retention/policy/:id", config.AuthFilter, service.DeleteRetentionPolicy)
+	r.GET("/retention/audit", config.AuthFilter, service.GetRetentionAuditLogs)
 
 	//r.PUT("/device/to-group/:id", config.AuthFilter, service.AddDeviceToGroup)
 	r.DELETE("/device/to-group/:id", config.AuthFilter, service.RemoveDeviceFromGroup)
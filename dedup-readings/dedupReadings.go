@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/service"
+	"log"
+)
+
+// dedup-readings removes readings that repeat a time stamp or message ID of their device and then creates
+// the unique reading indexes, which cannot exist while such readings do. Upgrading a database from before
+// those indexes: run dedup-readings (with -dry-run first to see the devices affected), then migrate-readings.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report duplicates, do not delete them")
+	flag.Parse()
+
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+
+	if err := models.AddMissingReadingColumns(initializers.DB); err != nil {
+		log.Fatalf("Failed adding reading columns: %v", err)
+	}
+
+	result, err := service.DeduplicateReadings(*dryRun)
+	for _, device := range result.Devices {
+		log.Printf("Device %s has %d readings with a repeated time stamp and %d with a repeated message ID, deleted %d\n",
+			device.DeviceID, device.Duplicates, device.MessageDuplicates, device.Deleted)
+	}
+	if err != nil {
+		log.Fatalf("Failed deduplicating readings: %v", err)
+	}
+	if *dryRun {
+		log.Printf("Found %d readings with a repeated time stamp and %d with a repeated message ID\n",
+			result.Duplicates, result.MessageDuplicates)
+		return
+	}
+
+	// The unique indexes can only be created once the duplicates are gone, which is why this command,
+	// not migrate-readings, is the one to run first on a database from before them.
+	if _, err := models.EnsureReadingUniqueIndexes(initializers.DB); err != nil {
+		log.Fatalf("Failed creating unique reading indexes: %v", err)
+	}
+	removed, err := models.EnsureRejectedReadingUniqueIndex(initializers.DB)
+	if err != nil {
+		log.Fatalf("Failed creating unique rejected reading index: %v", err)
+	}
+	if removed > 0 {
+		log.Printf("Removed %d repeated rejected readings\n", removed)
+	}
+
+	log.Printf("Deduplication completed successfully, removed %d readings\n", result.Deleted)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"gin-crud/controller"
 	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/service"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"log"
@@ -44,6 +46,9 @@ func main() {
 
 	initializers.LoadEnvVariables()
 	initializers.DatabaseInit()
+	if err := models.SeedBuiltinMetricDefinitions(initializers.DB); err != nil {
+		log.Println("Failed to seed metric definitions:", err)
+	}
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
@@ -62,7 +67,11 @@ func main() {
 	controller.DeviceController(r)
 
 	//go service.TokenExpirationCheckAndUpdateScheduler()
-	//go service.ClearDeviceDataScheduler()
+	go service.RetentionScheduler()
+	go service.DeviceNonceCleanupScheduler()
+	go service.StartMQTTListener()
+	go service.DeviceOfflineCheckScheduler()
+	go service.WebhookDeliveryScheduler()
 
 	go func() {
 		if err := r.Run(); err != nil {
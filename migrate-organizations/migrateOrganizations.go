@@ -0,0 +1,25 @@
+package main
+
+import (
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/service"
+	"log"
+)
+
+func main() {
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+
+	err := initializers.DB.AutoMigrate(&models.Organization{}, &models.OrganizationMember{},
+		&models.OrganizationInvitation{}, &models.UmkmData{}, &models.Device{}, &models.DeviceGrouping{})
+	if err != nil {
+		log.Fatalf("Error migrating organization tables: %v", err)
+	}
+
+	if err := service.MigrateUsersToOrganizations(); err != nil {
+		log.Fatalf("Failed migrating users to organizations: %v", err)
+	}
+
+	log.Println("Organization migration completed successfully")
+}
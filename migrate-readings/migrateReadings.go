@@ -0,0 +1,30 @@
+package main
+
+import (
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/service"
+	"log"
+)
+
+func main() {
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+
+	// On a database from before the unique reading indexes this stops when duplicates exist; run
+	// dedup-readings, which creates the indexes once they are removed, and then this command again.
+	counts, err := models.EnsureReadingUniqueIndexes(initializers.DB)
+	for _, count := range counts {
+		log.Printf("Device %s has %d readings with a repeated time stamp and %d with a repeated message ID\n",
+			count.DeviceID, count.Duplicates, count.MessageDuplicates)
+	}
+	if err != nil {
+		log.Fatalf("Error migrating device readings table: %v", err)
+	}
+
+	if err := service.MigrateDeviceDataToReadings(); err != nil {
+		log.Fatalf("Failed migrating device data: %v", err)
+	}
+
+	log.Println("Device data migration completed successfully")
+}
@@ -14,13 +14,36 @@ func main() {
 		model interface{}
 		table string
 	}{
+		{&model.Organization{}, "organizations"},
+		{&model.OrganizationMember{}, "organization_members"},
+		{&model.OrganizationInvitation{}, "organization_invitations"},
 		{&model.UmkmData{}, "umkm_data"},
 		{&model.SystemData{}, "system_data"},
 		{&model.BinusianData{}, "binusian_data"},
 		{&model.Token{}, "tokens"},
 		{&model.PasswordRecoveryToken{}, "password_recovery_tokens"},
+		{&model.ProvisioningBatch{}, "provisioning_batches"},
 		{&model.Device{}, "devices"},
 		{&model.DeviceGrouping{}, "device_grouping"},
+		{&model.DeviceReading{}, "device_readings"},
+		{&model.ReadingRollup{}, "reading_rollups"},
+		{&model.DeviceRequestNonce{}, "device_request_nonces"},
+		{&model.AlertRule{}, "alert_rules"},
+		{&model.AlertState{}, "alert_states"},
+		{&model.AlertEvent{}, "alert_events"},
+		{&model.NotificationPreference{}, "notification_preferences"},
+		{&model.Webhook{}, "webhooks"},
+		{&model.WebhookDelivery{}, "webhook_deliveries"},
+		{&model.RetentionPolicy{}, "retention_policies"},
+		{&model.RetentionAuditLog{}, "retention_audit_logs"},
+		{&model.MetricDefinition{}, "metric_definitions"},
+		{&model.DevicePlausibilityRange{}, "device_plausibility_ranges"},
+		{&model.RejectedReading{}, "rejected_readings"},
+		{&model.Calibration{}, "calibrations"},
+		{&model.DeviceTransfer{}, "device_transfers"},
+		{&model.DeviceTransferAuditLog{}, "device_transfer_audit_logs"},
+		{&model.DeviceArchive{}, "device_archives"},
+		{&model.DeviceShare{}, "device_shares"},
 	}
 
 	for _, m := range models {
@@ -36,5 +59,9 @@ func main() {
 			log.Printf("Error migrating model %T: %v\n", m.model, err)
 		}
 	}
+
+	if err := model.SeedBuiltinMetricDefinitions(initializers.DB); err != nil {
+		log.Printf("Error seeding metric definitions: %v\n", err)
+	}
 	log.Println("Database migration completed successfully")
 }
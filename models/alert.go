@@ -0,0 +1,191 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type AlertStatus string
+
+const (
+	AlertStatusOK       AlertStatus = "ok"
+	AlertStatusPending  AlertStatus = "pending"
+	AlertStatusFiring   AlertStatus = "firing"
+	AlertStatusResolved AlertStatus = "resolved"
+)
+
+// AlertMetricOffline marks events raised by the heartbeat check rather than by a rule.
+const AlertMetricOffline = "offline"
+
+type AlertRule struct {
+	gorm.Model
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UmkmDataId      uuid.UUID  `gorm:"column:umkm_data_id;index"`
+	DeviceID        *uuid.UUID `gorm:"type:uuid;column:device_id;index"`
+	GroupID         *uuid.UUID `gorm:"type:uuid;column:group_id;index"`
+	Name            string
+	Metric          string
+	Operator        string
+	Threshold       float64
+	DurationSeconds int
+	Hysteresis      float64
+	IsEnabled       bool
+}
+
+// AlertState tracks where a rule stands for one device, so group rules keep a state per member device.
+type AlertState struct {
+	gorm.Model
+	ID              uuid.UUID `gorm:"type:uuid;primary_key"`
+	RuleID          uuid.UUID `gorm:"type:uuid;column:rule_id;uniqueIndex:idx_alert_states_rule_device,priority:1"`
+	DeviceID        uuid.UUID `gorm:"type:uuid;column:device_id;uniqueIndex:idx_alert_states_rule_device,priority:2"`
+	Status          AlertStatus
+	PendingSince    *time.Time
+	FiredAt         *time.Time
+	LastValue       float64
+	LastEvaluatedAt time.Time
+	LastNotifiedAt  *time.Time
+	NotifiedFiring  bool
+}
+
+type AlertEvent struct {
+	gorm.Model
+	ID         uuid.UUID   `gorm:"type:uuid;primary_key"`
+	RuleID     *uuid.UUID  `gorm:"type:uuid;column:rule_id;index"`
+	DeviceID   uuid.UUID   `gorm:"type:uuid;column:device_id;index"`
+	UmkmDataId uuid.UUID   `gorm:"column:umkm_data_id;index"`
+	Status     AlertStatus `json:"status"`
+	Metric     string      `json:"metric"`
+	Value      float64     `json:"value"`
+	Threshold  float64     `json:"threshold"`
+	Message    string      `json:"message"`
+	OccurredAt time.Time   `gorm:"index"`
+}
+
+func CreateAlertRule(db *gorm.DB, rule *AlertRule) error {
+	if rule.ID == uuid.Nil {
+		rule.ID = uuid.New()
+	}
+	return db.Create(rule).Error
+}
+
+func GetUserAlertRule(db *gorm.DB, userID uuid.UUID, ruleID uuid.UUID) (*AlertRule, error) {
+	var rule AlertRule
+	if err := db.Where("id = ? AND umkm_data_id = ?", ruleID, userID).First(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func GetUserAlertRules(db *gorm.DB, userID uuid.UUID) ([]AlertRule, error) {
+	var rules []AlertRule
+	if err := db.Where("umkm_data_id = ?", userID).Order("created_at ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetDeviceAlertRules returns the enabled rules that target the device directly or through its group,
+// including those of users the device or group is shared with.
+func GetDeviceAlertRules(db *gorm.DB, device *Device) ([]AlertRule, error) {
+	var rules []AlertRule
+	if device.UmkmDataId == nil {
+		return rules, nil
+	}
+
+	query := db.Where("is_enabled = ?", true)
+	if device.GroupID != nil {
+		query = query.Where("device_id = ? OR group_id = ?", device.ID, *device.GroupID)
+	} else {
+		query = query.Where("device_id = ?", device.ID)
+	}
+	if err := query.Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DeleteAlertRule removes a rule together with its per-device states. Its history is kept.
+func DeleteAlertRule(db *gorm.DB, rule *AlertRule) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("rule_id = ?", rule.ID).Delete(&AlertState{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(rule).Error
+	})
+}
+
+func ResetAlertStates(db *gorm.DB, ruleID uuid.UUID) error {
+	return db.Unscoped().Where("rule_id = ?", ruleID).Delete(&AlertState{}).Error
+}
+
+// LockAlertState loads the state of a rule for a device, creating it when missing,
+// and locks the row until the surrounding transaction ends.
+func LockAlertState(tx *gorm.DB, ruleID uuid.UUID, deviceID uuid.UUID) (*AlertState, error) {
+	state := AlertState{
+		ID:       uuid.New(),
+		RuleID:   ruleID,
+		DeviceID: deviceID,
+		Status:   AlertStatusOK,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return nil, err
+	}
+
+	var locked AlertState
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("rule_id = ? AND device_id = ?", ruleID, deviceID).
+		First(&locked).Error
+	if err != nil {
+		return nil, err
+	}
+	return &locked, nil
+}
+
+func CreateAlertEvent(db *gorm.DB, event *AlertEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	return db.Create(event).Error
+}
+
+func GetUserAlertEvents(db *gorm.DB, userID uuid.UUID, deviceID *uuid.UUID, ruleID *uuid.UUID, start time.Time, end time.Time) ([]AlertEvent, error) {
+	var events []AlertEvent
+	query := db.Where("umkm_data_id = ? AND occurred_at >= ? AND occurred_at < ?", userID, start, end)
+	if deviceID != nil {
+		query = query.Where("device_id = ?", *deviceID)
+	}
+	if ruleID != nil {
+		query = query.Where("rule_id = ?", *ruleID)
+	}
+	if err := query.Order("occurred_at DESC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ClaimFiringNotification reserves the right to email a firing alert. It fails when an email
+// for the same rule and device went out less than minInterval ago.
+func ClaimFiringNotification(db *gorm.DB, ruleID uuid.UUID, deviceID uuid.UUID, minInterval time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.Model(&AlertState{}).
+		Where("rule_id = ? AND device_id = ?", ruleID, deviceID).
+		Where("last_notified_at IS NULL OR last_notified_at < ?", now.Add(-minInterval)).
+		Updates(map[string]interface{}{"last_notified_at": now, "notified_firing": true})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ClaimResolvedNotification only succeeds when the owner was emailed about the firing alert being resolved.
+func ClaimResolvedNotification(db *gorm.DB, ruleID uuid.UUID, deviceID uuid.UUID) (bool, error) {
+	result := db.Model(&AlertState{}).
+		Where("rule_id = ? AND device_id = ? AND notified_firing = ?", ruleID, deviceID, true).
+		Update("notified_firing", false)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Calibration corrects a metric of a device from EffectiveFrom on, until the next calibration of that
+// metric takes effect. The stored value is raw * Slope + Offset.
+type Calibration struct {
+	gorm.Model
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	DeviceID       uuid.UUID `gorm:"type:uuid;column:device_id;index:idx_calibrations_device_metric,priority:1"`
+	Metric         string    `gorm:"index:idx_calibrations_device_metric,priority:2"`
+	Offset         float64
+	Slope          float64
+	EffectiveFrom  time.Time `gorm:"index:idx_calibrations_device_metric,priority:3"`
+	CalibratedByID uuid.UUID `gorm:"type:uuid;column:calibrated_by_id"`
+	CalibratedBy   string
+	Note           string
+}
+
+func (calibration *Calibration) Apply(raw float64) float64 {
+	return raw*calibration.Slope + calibration.Offset
+}
+
+// GetDeviceCalibrations returns every calibration of a device, oldest effective first.
+func GetDeviceCalibrations(db *gorm.DB, deviceID uuid.UUID) ([]Calibration, error) {
+	var calibrations []Calibration
+	err := db.Where("device_id = ?", deviceID).Order("metric ASC, effective_from ASC").Find(&calibrations).Error
+	if err != nil {
+		return nil, err
+	}
+	return calibrations, nil
+}
+
+func GetDeviceCalibrationById(db *gorm.DB, deviceID uuid.UUID, calibrationID uuid.UUID) (*Calibration, error) {
+	var calibration Calibration
+	if err := db.Where("id = ? AND device_id = ?", calibrationID, deviceID).First(&calibration).Error; err != nil {
+		return nil, err
+	}
+	return &calibration, nil
+}
+
+func SaveCalibration(db *gorm.DB, calibration *Calibration) error {
+	if calibration.ID == uuid.Nil {
+		calibration.ID = uuid.New()
+	}
+	return db.Save(calibration).Error
+}
+
+func DeleteCalibration(db *gorm.DB, calibration *Calibration) error {
+	return db.Unscoped().Delete(calibration).Error
+}
+
+func DeleteDeviceCalibrations(db *gorm.DB, deviceID uuid.UUID) error {
+	return db.Unscoped().Where("device_id = ?", deviceID).Delete(&Calibration{}).Error
+}
+
+// UpdateReadingValues writes recomputed metric values, raw values and quality flags of a stored reading.
+func UpdateReadingValues(db *gorm.DB, reading *DeviceReading) error {
+	return db.Model(&DeviceReading{}).Where("id = ?", reading.ID).Updates(map[string]interface{}{
+		"oxygen_level":    reading.OxygenLevel,
+		"water_temp":      reading.WaterTemp,
+		"ec_level":        reading.EcLevel,
+		"ph_level":        reading.PhLevel,
+		"metrics":         reading.Metrics,
+		"raw_values":      reading.RawValues,
+		"quality":         reading.Quality,
+		"flagged_metrics": reading.FlaggedMetrics,
+	}).Error
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"sync"
+	"testing"
+)
+
+var (
+	testDB        *gorm.DB
+	testDBErr     error
+	testDBMigrate sync.Once
+)
+
+// openTestDB connects to the disposable database in TEST_DATABASE_URL and migrates it once per run. Tests
+// that need it are skipped when the variable is not set. Every test creates its own rows with fresh IDs, so
+// the database does not have to be empty.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	testDBMigrate.Do(func() {
+		testDB, testDBErr = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if testDBErr != nil {
+			return
+		}
+		testDBErr = testDB.AutoMigrate(
+			&Organization{}, &OrganizationMember{}, &UmkmData{}, &Device{}, &DeviceGrouping{},
+			&DeviceReading{}, &ReadingRollup{}, &RejectedReading{}, &AlertRule{}, &AlertState{},
+			&DevicePlausibilityRange{}, &Calibration{}, &DeviceShare{}, &DeviceTransfer{},
+			&DeviceTransferAuditLog{}, &DeviceArchive{},
+		)
+	})
+	if testDBErr != nil {
+		t.Fatal("Failed to prepare the test database:", testDBErr)
+	}
+	return testDB
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, name string) *UmkmData {
+	t.Helper()
+	id := uuid.New()
+	user := UmkmData{
+		ID:    id,
+		Name:  name,
+		Email: fmt.Sprintf("%s-%s@example.com", name, id),
+		Phone: id.String(),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal("Failed to create user:", err)
+	}
+	return &user
+}
+
+func createTestDevice(t *testing.T, db *gorm.DB, owner *UmkmData) *Device {
+	t.Helper()
+	device := Device{ID: uuid.New(), Name: "Pond", UmkmDataId: &owner.ID, Data: []byte("TimeStamp\n")}
+	if err := db.Create(&device).Error; err != nil {
+		t.Fatal("Failed to create device:", err)
+	}
+	return &device
+}
+
+func countRows(t *testing.T, db *gorm.DB, model interface{}, deviceID uuid.UUID) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Model(model).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		t.Fatal("Failed to count rows:", err)
+	}
+	return count
+}
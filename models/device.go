@@ -4,10 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"gin-crud/initializers"
+	"gin-crud/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"log"
 	"net/http"
+	"time"
 )
 
 type Device struct {
@@ -19,6 +22,18 @@ type Device struct {
 	GroupName   *string    `json:"group_name"`
 	GroupID     *uuid.UUID `gorm:"column:group_id"`
 	UmkmDataId  *uuid.UUID `gorm:"column:umkm_data_id"`
+	// OrganizationID is the organization the device belongs to; nil for a personal device.
+	OrganizationID *uuid.UUID `gorm:"type:uuid;index" json:"organization_id"`
+	SecretKey      string     `json:"-"`
+	LastSeenAt     *time.Time `json:"last_seen_at"`
+	IsOnline       bool       `json:"is_online"`
+	// ClaimCode registers the device to a user once; it is cleared when the device is claimed.
+	ClaimCode          *string    `gorm:"uniqueIndex" json:"-"`
+	ClaimCodeExpiresAt *time.Time `json:"-"`
+	// SerialNumber is the manufacturer's label, set for devices provisioned from an input file.
+	SerialNumber        *string    `gorm:"uniqueIndex" json:"serial_number,omitempty"`
+	ProvisioningBatchID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	ProvisioningIndex   *int       `json:"-"`
 }
 
 func AssignDeviceToGroup(db *gorm.DB, deviceID uuid.UUID, userID uuid.UUID, groupId uuid.UUID) (error, string, int) {
@@ -96,21 +111,6 @@ func UnassignDeviceFromGroup(db *gorm.DB, deviceID uuid.UUID, userID uuid.UUID)
 	return nil, message, http.StatusOK
 }
 
-func SaveCSVToDevice(db *gorm.DB, csvData []byte, deviceID uuid.UUID) error {
-	var device Device
-
-	if err := db.First(&device, "id = ?", deviceID).Error; err != nil {
-		return err
-	}
-	device.Data = append(device.Data, csvData...)
-
-	if err := db.Save(&device).Error; err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func GetDeviceById(db *gorm.DB, deviceID uuid.UUID) (*Device, error) {
 	var device Device
 	if err := db.Where("id = ?", deviceID).First(&device).Error; err != nil {
@@ -125,12 +125,93 @@ func GetDeviceById(db *gorm.DB, deviceID uuid.UUID) (*Device, error) {
 func (device *Device) BeforeCreate(tx *gorm.DB) (err error) {
 	headers := []byte("oxygen_level,water_temp,ec_level,ph_level,time_stamp,id\n")
 	device.Data = headers
+	if device.SecretKey == "" {
+		device.SecretKey, err = utils.GenerateSecretKey()
+	}
 	return
 }
 
+// BackfillDeviceSecrets gives a secret to every device created before requests were signed and returns
+// those devices with their new secrets.
+func BackfillDeviceSecrets(db *gorm.DB) ([]Device, error) {
+	var devices []Device
+	if err := db.Select("id").Where("secret_key = '' OR secret_key IS NULL").Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		secretKey, err := utils.GenerateSecretKey()
+		if err != nil {
+			return devices[:i], err
+		}
+		if err := db.Model(&devices[i]).Update("secret_key", secretKey).Error; err != nil {
+			return devices[:i], err
+		}
+		devices[i].SecretKey = secretKey
+	}
+	return devices, nil
+}
+
+func RotateDeviceSecret(db *gorm.DB, device *Device) error {
+	secretKey, err := utils.GenerateSecretKey()
+	if err != nil {
+		return err
+	}
+	if err := db.Model(device).Update("secret_key", secretKey).Error; err != nil {
+		return err
+	}
+	device.SecretKey = secretKey
+	return nil
+}
+
+// IssueClaimCode gives an unclaimed device a new claim code, replacing any earlier one.
+func IssueClaimCode(db *gorm.DB, device *Device, expiresAt time.Time) error {
+	code, err := utils.GenerateClaimCode()
+	if err != nil {
+		return err
+	}
+	result := db.Model(&Device{}).
+		Where("id = ? AND umkm_data_id IS NULL", device.ID).
+		Updates(map[string]interface{}{"claim_code": code, "claim_code_expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return utils.ErrDeviceAlreadyRegistered
+	}
+	device.ClaimCode = &code
+	device.ClaimCodeExpiresAt = &expiresAt
+	return nil
+}
+
 func CreateDevice(device *Device) error {
 	if err := initializers.DB.Create(device).Error; err != nil {
 		return err
 	}
 	return nil
 }
+
+// TouchDevice records that the device just reported and reports whether it was offline before.
+func TouchDevice(db *gorm.DB, deviceID uuid.UUID, at time.Time) (bool, error) {
+	result := db.Model(&Device{}).
+		Where("id = ? AND is_online = ?", deviceID, false).
+		Updates(map[string]interface{}{"last_seen_at": at, "is_online": true})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+	return false, db.Model(&Device{}).Where("id = ?", deviceID).Update("last_seen_at", at).Error
+}
+
+// MarkOfflineDevices flags registered devices that have been silent since before and returns them.
+func MarkOfflineDevices(db *gorm.DB, before time.Time) ([]Device, error) {
+	var devices []Device
+	err := db.Model(&devices).
+		Clauses(clause.Returning{}).
+		Where("is_online = ? AND last_seen_at < ? AND umkm_data_id IS NOT NULL", true, before).
+		Update("is_online", false).Error
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
@@ -11,20 +11,30 @@ import (
 
 type DeviceGrouping struct {
 	gorm.Model
-	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
-	UmkmDataId     uuid.UUID `gorm:"column:umkm_data_id"`
-	GroupName      string    `json:"group_name"`
-	NumberOfDevice int       `json:"number_of_device"`
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UmkmDataId     uuid.UUID  `gorm:"column:umkm_data_id"`
+	OrganizationID *uuid.UUID `gorm:"type:uuid;index" json:"organization_id"`
+	GroupName      string     `json:"group_name"`
+	NumberOfDevice int        `json:"number_of_device"`
 }
 
-func CreateGrouping(db *gorm.DB, umkmDataId uuid.UUID, groupName string) (error, string, int) {
+// CreateGrouping creates a group in the organization, or a personal group when organizationID is nil.
+// Group names are unique within the organization.
+func CreateGrouping(db *gorm.DB, umkmDataId uuid.UUID, organizationID *uuid.UUID, groupName string) (error, string, int) {
 	var dg DeviceGrouping
 
-	if err := db.Where("LOWER(group_name) = LOWER(?) AND umkm_data_id = ?", groupName, umkmDataId).First(&DeviceGrouping{}).Error; err == nil {
+	query := db.Where("LOWER(group_name) = LOWER(?)", groupName)
+	if organizationID != nil {
+		query = query.Where("organization_id = ?", *organizationID)
+	} else {
+		query = query.Where("umkm_data_id = ? AND organization_id IS NULL", umkmDataId)
+	}
+	if err := query.First(&DeviceGrouping{}).Error; err == nil {
 		return nil, "Group already exist", http.StatusBadRequest
 	} else {
 		dg.ID = uuid.New()
 		dg.UmkmDataId = umkmDataId
+		dg.OrganizationID = organizationID
 		dg.GroupName = groupName
 		dg.NumberOfDevice = 0
 		if err := db.Create(&dg).Error; err != nil {
@@ -77,3 +87,54 @@ func UnassignDevicesFromGroup(db *gorm.DB, groupID uuid.UUID, userID uuid.UUID)
 
 	return nil, "Successfully unassigned all device from group", http.StatusOK
 }
+
+func GetUserGroupById(db *gorm.DB, userID uuid.UUID, groupID uuid.UUID) (*DeviceGrouping, error) {
+	var group DeviceGrouping
+	if err := db.Where("id = ? AND umkm_data_id = ?", groupID, userID).First(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func GetUserGroupDeviceIds(db *gorm.DB, userID uuid.UUID, groupID uuid.UUID) ([]uuid.UUID, error) {
+	if _, err := GetUserGroupById(db, userID, groupID); err != nil {
+		return nil, err
+	}
+
+	var deviceIDs []uuid.UUID
+	err := db.Model(&Device{}).
+		Where("group_id = ? AND umkm_data_id = ?", groupID, userID).
+		Pluck("id", &deviceIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return deviceIDs, nil
+}
+
+func GetUserGroupDevices(db *gorm.DB, userID uuid.UUID, groupID uuid.UUID) ([]Device, error) {
+	if _, err := GetUserGroupById(db, userID, groupID); err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	err := db.Select("id, name, group_id, umkm_data_id").
+		Where("group_id = ? AND umkm_data_id = ?", groupID, userID).
+		Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// GetUserGroups returns the user's personal groups and the groups of the organization (nil for none).
+func GetUserGroups(db *gorm.DB, userID uuid.UUID, organizationID *uuid.UUID) ([]DeviceGrouping, error) {
+	var groups []DeviceGrouping
+	query := db.Where("umkm_data_id = ? AND organization_id IS NULL", userID)
+	if organizationID != nil {
+		query = query.Or("organization_id = ?", *organizationID)
+	}
+	if err := query.Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
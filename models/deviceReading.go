@@ -0,0 +1,355 @@
+package models
+
+import (
+	"errors"
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"time"
+)
+
+type DeviceReading struct {
+	gorm.Model
+	ID          uuid.UUID    `gorm:"type:uuid;primary_key"`
+	DeviceID    uuid.UUID    `gorm:"type:uuid;column:device_id;uniqueIndex:idx_device_readings_device_time_key,priority:1;uniqueIndex:idx_device_readings_device_message,priority:1"`
+	TimeStamp   time.Time    `gorm:"column:time_stamp;uniqueIndex:idx_device_readings_device_time_key,priority:2"`
+	MessageID   *string      `gorm:"column:message_id;uniqueIndex:idx_device_readings_device_message,priority:2"`
+	OxygenLevel float32      `json:"oxygen_level"`
+	WaterTemp   float32      `json:"water_temp"`
+	EcLevel     float32      `json:"ec_level"`
+	PhLevel     float32      `json:"ph_level"`
+	Metrics     MetricValues `gorm:"column:metrics;type:jsonb" json:"metrics"`
+	// RawValues keeps the uncalibrated value of every metric a calibration was applied to.
+	RawValues MetricValues `gorm:"column:raw_values;type:jsonb" json:"raw_values"`
+	// Quality is good or suspect; FlaggedMetrics lists the metrics that made a reading suspect.
+	Quality        string `gorm:"column:quality"`
+	FlaggedMetrics string `gorm:"column:flagged_metrics"`
+	// RejectedMetrics lists the metrics whose values were out of range and were stored in quarantine
+	// instead; the reading behaves as if it never reported them.
+	RejectedMetrics string `gorm:"column:rejected_metrics"`
+}
+
+// ReadingMetrics lists the built-in metrics every reading carries, in CSV column order.
+var ReadingMetrics = []string{"oxygen_level", "water_temp", "ec_level", "ph_level"}
+
+// MetricNames returns the built-in metrics followed by the other metrics the reading reported, leaving
+// out rejected ones.
+func (reading *DeviceReading) MetricNames() []string {
+	names := make([]string, 0, len(ReadingMetrics)+len(reading.Metrics))
+	for _, name := range ReadingMetrics {
+		if !reading.IsMetricRejected(name) {
+			names = append(names, name)
+		}
+	}
+	extra := make([]string, 0, len(reading.Metrics))
+	for name := range reading.Metrics {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+func (reading *DeviceReading) MetricValue(metric string) (float64, bool) {
+	if reading.IsMetricRejected(metric) {
+		return 0, false
+	}
+	switch metric {
+	case "oxygen_level":
+		return float64(reading.OxygenLevel), true
+	case "water_temp":
+		return float64(reading.WaterTemp), true
+	case "ec_level":
+		return float64(reading.EcLevel), true
+	case "ph_level":
+		return float64(reading.PhLevel), true
+	}
+	value, ok := reading.Metrics[metric]
+	return value, ok
+}
+
+// RawMetricValue returns the value the device sent, before any calibration.
+func (reading *DeviceReading) RawMetricValue(metric string) (float64, bool) {
+	if value, ok := reading.RawValues[metric]; ok {
+		return value, true
+	}
+	return reading.MetricValue(metric)
+}
+
+func (reading *DeviceReading) SetMetricValue(metric string, value float64) {
+	switch metric {
+	case "oxygen_level":
+		reading.OxygenLevel = float32(value)
+	case "water_temp":
+		reading.WaterTemp = float32(value)
+	case "ec_level":
+		reading.EcLevel = float32(value)
+	case "ph_level":
+		reading.PhLevel = float32(value)
+	default:
+		if reading.Metrics == nil {
+			reading.Metrics = MetricValues{}
+		}
+		reading.Metrics[metric] = value
+	}
+}
+
+// AppendDeviceReading stores a reading only while the device is registered to a user, and folds it
+// into the hourly and daily rollups in the same transaction. A reading with the timestamp or message ID
+// of one already stored is not inserted and ErrDuplicateReading is returned. The device row is held
+// with a shared lock until commit, so a release in DeleteDeviceById either waits and removes the reading
+// with its rollups, or runs first and the append fails with ErrDeviceNotRegistered. Concurrent appends
+// share the device lock but queue on the rollup rows of the buckets they have in common.
+func AppendDeviceReading(db *gorm.DB, reading *DeviceReading) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+			Select("id").
+			Where("id = ? AND umkm_data_id IS NOT NULL", reading.DeviceID).
+			First(&device).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrDeviceNotRegistered
+		} else if err != nil {
+			return err
+		}
+		if reading.ID == uuid.Nil {
+			reading.ID = uuid.New()
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(reading)
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return utils.ErrDuplicateReading
+		}
+		return UpsertReadingRollups(tx, []DeviceReading{*reading})
+	})
+}
+
+// CreateDeviceReadings inserts readings in batches. Callers must have removed duplicates already,
+// since a reading that collides with a stored one fails the whole batch.
+func CreateDeviceReadings(db *gorm.DB, readings []DeviceReading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	for i := range readings {
+		if readings[i].ID == uuid.Nil {
+			readings[i].ID = uuid.New()
+		}
+	}
+	if err := db.CreateInBatches(readings, 500).Error; err != nil {
+		return err
+	}
+	return UpsertReadingRollups(db, readings)
+}
+
+// MergeDeviceReadings inserts historical readings of a registered device, skipping any whose timestamp
+// the device already has a reading for. It reports for every reading whether it was skipped as a duplicate.
+// The device row is locked for update, so two imports of the same file cannot both insert it.
+func MergeDeviceReadings(db *gorm.DB, deviceID uuid.UUID, readings []DeviceReading) ([]bool, error) {
+	duplicates := make([]bool, len(readings))
+	if len(readings) == 0 {
+		return duplicates, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ? AND umkm_data_id IS NOT NULL", deviceID).
+			First(&device).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrDeviceNotRegistered
+		} else if err != nil {
+			return err
+		}
+
+		start, end := readings[0].TimeStamp, readings[0].TimeStamp
+		for i := range readings {
+			if readings[i].TimeStamp.Before(start) {
+				start = readings[i].TimeStamp
+			}
+			if readings[i].TimeStamp.After(end) {
+				end = readings[i].TimeStamp
+			}
+		}
+
+		var existing []time.Time
+		err = tx.Model(&DeviceReading{}).
+			Where("device_id = ? AND time_stamp >= ? AND time_stamp <= ?", deviceID, start, end).
+			Pluck("time_stamp", &existing).Error
+		if err != nil {
+			return err
+		}
+		seen := make(map[int64]bool, len(existing))
+		for _, ts := range existing {
+			seen[ts.UnixNano()] = true
+		}
+
+		fresh := make([]DeviceReading, 0, len(readings))
+		for i := range readings {
+			if seen[readings[i].TimeStamp.UnixNano()] {
+				duplicates[i] = true
+				continue
+			}
+			fresh = append(fresh, readings[i])
+		}
+		return CreateDeviceReadings(tx, fresh)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// GetDeviceReadings returns the readings of a device with start < time_stamp < end, oldest first.
+func GetDeviceReadings(db *gorm.DB, deviceID uuid.UUID, start time.Time, end time.Time) ([]DeviceReading, error) {
+	var readings []DeviceReading
+	err := db.Where("device_id = ? AND time_stamp > ? AND time_stamp < ?", deviceID, start, end).
+		Order("time_stamp ASC").
+		Find(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// GetDeviceReadingsPage returns up to limit readings with start <= time_stamp < end, ordered by time_stamp and id.
+// When afterTime is set, only readings after the (afterTime, afterID) position are returned.
+func GetDeviceReadingsPage(db *gorm.DB, deviceID uuid.UUID, start time.Time, end time.Time, afterTime *time.Time, afterID uuid.UUID, limit int) ([]DeviceReading, error) {
+	var readings []DeviceReading
+	query := db.Where("device_id = ? AND time_stamp >= ? AND time_stamp < ?", deviceID, start, end)
+	if afterTime != nil {
+		query = query.Where("(time_stamp, id) > (?, ?)", *afterTime, afterID)
+	}
+	err := query.Order("time_stamp ASC, id ASC").Limit(limit).Find(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// GetReadingsForDevicesPage is GetDeviceReadingsPage over several devices.
+func GetReadingsForDevicesPage(db *gorm.DB, deviceIDs []uuid.UUID, start time.Time, end time.Time, afterTime *time.Time, afterID uuid.UUID, limit int) ([]DeviceReading, error) {
+	var readings []DeviceReading
+	if len(deviceIDs) == 0 {
+		return readings, nil
+	}
+	query := db.Where("device_id IN ? AND time_stamp >= ? AND time_stamp < ?", deviceIDs, start, end)
+	if afterTime != nil {
+		query = query.Where("(time_stamp, id) > (?, ?)", *afterTime, afterID)
+	}
+	err := query.Order("time_stamp ASC, id ASC").Limit(limit).Find(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+func GetReadingsForDevices(db *gorm.DB, deviceIDs []uuid.UUID, start time.Time, end time.Time) ([]DeviceReading, error) {
+	var readings []DeviceReading
+	if len(deviceIDs) == 0 {
+		return readings, nil
+	}
+	err := db.Where("device_id IN ? AND time_stamp >= ? AND time_stamp < ?", deviceIDs, start, end).
+		Order("time_stamp ASC").
+		Find(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+func DeleteDeviceReadings(db *gorm.DB, deviceID uuid.UUID) error {
+	return db.Unscoped().Where("device_id = ?", deviceID).Delete(&DeviceReading{}).Error
+}
+
+type DuplicateReadingCount struct {
+	DeviceID          uuid.UUID
+	Duplicates        int64
+	MessageDuplicates int64
+}
+
+// GetDuplicateReadingCounts lists the devices that have more than one reading at the same timestamp
+// or with the same message ID. These are the rows that keep the unique reading indexes from being created.
+func GetDuplicateReadingCounts(db *gorm.DB) ([]DuplicateReadingCount, error) {
+	var counts []DuplicateReadingCount
+	err := db.Model(&DeviceReading{}).
+		Select("device_id, COUNT(*) - COUNT(DISTINCT time_stamp) AS duplicates, " +
+			"COUNT(message_id) - COUNT(DISTINCT message_id) AS message_duplicates").
+		Group("device_id").
+		Having("COUNT(*) > COUNT(DISTINCT time_stamp) OR COUNT(message_id) > COUNT(DISTINCT message_id)").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// DeleteDuplicateDeviceReadings keeps the first stored reading of every timestamp and of every message ID
+// of a device and removes the others, batchSize rows per statement.
+func DeleteDuplicateDeviceReadings(db *gorm.DB, deviceID uuid.UUID, batchSize int) (int64, error) {
+	var total int64
+	for _, partition := range []string{"time_stamp", "message_id"} {
+		for {
+			result := db.Exec(`DELETE FROM device_readings WHERE id IN (
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY `+partition+` ORDER BY created_at, id) AS rn
+					FROM device_readings WHERE device_id = ? AND `+partition+` IS NOT NULL
+				) ranked WHERE rn > 1 LIMIT ?)`,
+				deviceID, batchSize)
+			if result.Error != nil {
+				return total, result.Error
+			}
+			total += result.RowsAffected
+			if result.RowsAffected < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// AddMissingReadingColumns adds the columns of DeviceReading that a table from an older version lacks. It
+// leaves the indexes alone, so it works while duplicate readings still exist.
+func AddMissingReadingColumns(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, field := range []string{"MessageID", "Metrics", "RawValues", "Quality", "FlaggedMetrics", "RejectedMetrics"} {
+		if migrator.HasColumn(&DeviceReading{}, field) {
+			continue
+		}
+		if err := migrator.AddColumn(&DeviceReading{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureReadingUniqueIndexes creates the unique (device_id, time_stamp) and (device_id, message_id)
+// indexes on databases from before them, replacing the plain (device_id, time_stamp) index. The indexes
+// cannot be created while duplicates exist, so when there are any nothing is changed: the devices holding
+// them are returned with ErrDuplicateReadingsExist. Run dedup-readings, which removes the duplicates and
+// then calls this; migrate-readings calls it too and stops with the same report.
+func EnsureReadingUniqueIndexes(db *gorm.DB) ([]DuplicateReadingCount, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&DeviceReading{}) {
+		return nil, db.AutoMigrate(&DeviceReading{})
+	}
+
+	if err := AddMissingReadingColumns(db); err != nil {
+		return nil, err
+	}
+	counts, err := GetDuplicateReadingCounts(db)
+	if err != nil {
+		return nil, err
+	} else if len(counts) > 0 {
+		return counts, utils.ErrDuplicateReadingsExist
+	}
+
+	if migrator.HasIndex(&DeviceReading{}, "idx_device_readings_device_time") {
+		if err := migrator.DropIndex(&DeviceReading{}, "idx_device_readings_device_time"); err != nil {
+			return nil, err
+		}
+	}
+	return nil, db.AutoMigrate(&DeviceReading{})
+}
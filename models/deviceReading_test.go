@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"gin-crud/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+const concurrentAppends = 50
+
+// appendConcurrently appends one reading per goroutine to the device, each a second apart inside the
+// same hour, and runs during, if set, while they are in flight. It returns the result of every append.
+func appendConcurrently(t *testing.T, device *Device, start time.Time, during func()) []error {
+	t.Helper()
+	db := openTestDB(t)
+	results := make([]error, concurrentAppends)
+	ready := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentAppends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-ready
+			reading := DeviceReading{DeviceID: device.ID, TimeStamp: start.Add(time.Duration(i) * time.Second), PhLevel: 7}
+			results[i] = AppendDeviceReading(db, &reading)
+		}(i)
+	}
+	close(ready)
+	if during != nil {
+		during()
+	}
+	wg.Wait()
+	return results
+}
+
+func hourlyRollupCount(t *testing.T, device *Device, metric string) int64 {
+	t.Helper()
+	var count int64
+	err := openTestDB(t).Model(&ReadingRollup{}).
+		Select("COALESCE(SUM(count), 0)").
+		Where("device_id = ? AND resolution = ? AND metric = ?", device.ID, RollupHourly, metric).
+		Scan(&count).Error
+	if err != nil {
+		t.Fatal("Failed to sum rollups:", err)
+	}
+	return count
+}
+
+func TestConcurrentAppendsKeepEveryReading(t *testing.T) {
+	db := openTestDB(t)
+	owner := createTestUser(t, db, "owner")
+	device := createTestDevice(t, db, owner)
+
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	for i, err := range appendConcurrently(t, device, start, nil) {
+		if err != nil {
+			t.Fatalf("append %d failed: %v", i, err)
+		}
+	}
+
+	if readings := countRows(t, db, &DeviceReading{}, device.ID); readings != concurrentAppends {
+		t.Fatalf("%d readings stored, want %d", readings, concurrentAppends)
+	}
+	if count := hourlyRollupCount(t, device, "ph_level"); count != concurrentAppends {
+		t.Fatalf("hourly rollup counts %d readings, want %d", count, concurrentAppends)
+	}
+}
+
+func TestConcurrentAppendsDuringReleaseLeaveNoRows(t *testing.T) {
+	db := openTestDB(t)
+	owner := createTestUser(t, db, "owner")
+	device := createTestDevice(t, db, owner)
+
+	start := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	var deleteErr error
+	results := appendConcurrently(t, device, start, func() {
+		deleteErr = DeleteDeviceById(db, owner.ID, device.ID)
+	})
+	if deleteErr != nil {
+		t.Fatal("Failed to release device:", deleteErr)
+	}
+
+	// Appends that committed before the release lost their rows to it; the rest must have been refused
+	// rather than stored against the released device.
+	for i, err := range results {
+		if err != nil && !errors.Is(err, utils.ErrDeviceNotRegistered) {
+			t.Fatalf("append %d failed: %v", i, err)
+		}
+	}
+	if readings := countRows(t, db, &DeviceReading{}, device.ID); readings != 0 {
+		t.Fatalf("%d readings orphaned on the released device", readings)
+	}
+	if rollups := countRows(t, db, &ReadingRollup{}, device.ID); rollups != 0 {
+		t.Fatalf("%d rollups orphaned on the released device", rollups)
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+type DeviceRequestNonce struct {
+	gorm.Model
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	DeviceID  uuid.UUID `gorm:"type:uuid;column:device_id"`
+	Signature string    `gorm:"uniqueIndex"`
+}
+
+// UseDeviceNonce records a signature as consumed. A signature that was already recorded is a replay.
+// Hex signatures are accepted in any case, so the nonce is stored lowercased; otherwise every case variant
+// of a captured signature could be replayed once.
+func UseDeviceNonce(db *gorm.DB, deviceID uuid.UUID, signature string) error {
+	nonce := DeviceRequestNonce{
+		ID:        uuid.New(),
+		DeviceID:  deviceID,
+		Signature: strings.ToLower(signature),
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&nonce)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrSignatureReplayed
+	}
+	return nil
+}
+
+func DeleteExpiredDeviceNonces(db *gorm.DB, before time.Time) error {
+	return db.Unscoped().Where("created_at < ?", before).Delete(&DeviceRequestNonce{}).Error
+}
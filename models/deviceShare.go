@@ -0,0 +1,298 @@
+package models
+
+import (
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Roles a user can hold on a device, group or organization. The owner is the account in umkm_data_id and
+// never has a share; an organization owner holds the owner role on everything the organization owns.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+	RoleOwner    = "owner"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// IsShareRole reports whether role can be granted through a share.
+func IsShareRole(role string) bool {
+	return role == RoleViewer || role == RoleOperator || role == RoleAdmin
+}
+
+// RoleAllows reports whether role includes everything minRole may do.
+func RoleAllows(role string, minRole string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[minRole]
+}
+
+func higherRole(a string, b string) string {
+	if roleRanks[b] > roleRanks[a] {
+		return b
+	}
+	return a
+}
+
+// DeviceShare gives another account a role on a single device (DeviceID) or on every device of a group (GroupID).
+type DeviceShare struct {
+	gorm.Model
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UmkmDataId uuid.UUID  `gorm:"column:umkm_data_id;uniqueIndex:idx_device_shares_user_device,priority:1;uniqueIndex:idx_device_shares_user_group,priority:1"`
+	DeviceID   *uuid.UUID `gorm:"type:uuid;column:device_id;uniqueIndex:idx_device_shares_user_device,priority:2"`
+	GroupID    *uuid.UUID `gorm:"type:uuid;column:group_id;uniqueIndex:idx_device_shares_user_group,priority:2"`
+	Role       string
+	UserName   string
+	UserEmail  string
+	SharedByID uuid.UUID `gorm:"type:uuid;column:shared_by_id"`
+	SharedBy   string
+}
+
+// GetDeviceRole returns the role of the user on the device, or an empty string when the user has no access.
+// Membership of the device's organization and a share on the device's group count for the device; the
+// highest role wins.
+func GetDeviceRole(db *gorm.DB, userID uuid.UUID, device *Device) (string, error) {
+	if device.UmkmDataId == nil {
+		return "", nil
+	}
+	if *device.UmkmDataId == userID {
+		return RoleOwner, nil
+	}
+
+	var role string
+	if device.OrganizationID != nil {
+		var err error
+		if role, err = GetOrganizationRole(db, userID, *device.OrganizationID); err != nil {
+			return "", err
+		}
+	}
+
+	var shares []DeviceShare
+	query := db.Where("umkm_data_id = ?", userID)
+	if device.GroupID != nil {
+		query = query.Where("device_id = ? OR group_id = ?", device.ID, *device.GroupID)
+	} else {
+		query = query.Where("device_id = ?", device.ID)
+	}
+	if err := query.Find(&shares).Error; err != nil {
+		return "", err
+	}
+
+	for _, share := range shares {
+		role = higherRole(role, share.Role)
+	}
+	return role, nil
+}
+
+// GetGroupRole returns the role of the user on the group, or an empty string when the user has no access.
+func GetGroupRole(db *gorm.DB, userID uuid.UUID, group *DeviceGrouping) (string, error) {
+	if group.UmkmDataId == userID {
+		return RoleOwner, nil
+	}
+
+	var role string
+	if group.OrganizationID != nil {
+		var err error
+		if role, err = GetOrganizationRole(db, userID, *group.OrganizationID); err != nil {
+			return "", err
+		}
+	}
+
+	var share DeviceShare
+	err := db.Where("umkm_data_id = ? AND group_id = ?", userID, group.ID).Limit(1).Find(&share).Error
+	if err != nil {
+		return "", err
+	}
+	return higherRole(role, share.Role), nil
+}
+
+// GetAccessibleDevice loads a registered device the user holds at least minRole on. It returns
+// gorm.ErrRecordNotFound when the user has no access at all, so other accounts' devices stay hidden,
+// and utils.ErrInsufficientRole when the user can see the device but not do this.
+func GetAccessibleDevice(db *gorm.DB, userID uuid.UUID, deviceID uuid.UUID, minRole string) (*Device, string, error) {
+	var device Device
+	if err := db.Where("id = ? AND umkm_data_id IS NOT NULL", deviceID).First(&device).Error; err != nil {
+		return nil, "", err
+	}
+	role, err := GetDeviceRole(db, userID, &device)
+	if err != nil {
+		return nil, "", err
+	} else if role == "" {
+		return nil, "", gorm.ErrRecordNotFound
+	} else if !RoleAllows(role, minRole) {
+		return &device, role, utils.ErrInsufficientRole
+	}
+	return &device, role, nil
+}
+
+// GetAccessibleGroup loads a group the user holds at least minRole on, with the same errors as GetAccessibleDevice.
+func GetAccessibleGroup(db *gorm.DB, userID uuid.UUID, groupID uuid.UUID, minRole string) (*DeviceGrouping, string, error) {
+	var group DeviceGrouping
+	if err := db.Where("id = ?", groupID).First(&group).Error; err != nil {
+		return nil, "", err
+	}
+	role, err := GetGroupRole(db, userID, &group)
+	if err != nil {
+		return nil, "", err
+	} else if role == "" {
+		return nil, "", gorm.ErrRecordNotFound
+	} else if !RoleAllows(role, minRole) {
+		return &group, role, utils.ErrInsufficientRole
+	}
+	return &group, role, nil
+}
+
+// GetAccessibleDevices returns the user's personal devices, the devices of the organization (nil for none)
+// and the devices shared with the user, with the user's role on each.
+func GetAccessibleDevices(db *gorm.DB, userID uuid.UUID, organizationID *uuid.UUID) ([]Device, map[uuid.UUID]string, error) {
+	var shares []DeviceShare
+	if err := db.Where("umkm_data_id = ?", userID).Find(&shares).Error; err != nil {
+		return nil, nil, err
+	}
+
+	deviceRoles := make(map[uuid.UUID]string)
+	groupRoles := make(map[uuid.UUID]string)
+	for _, share := range shares {
+		if share.DeviceID != nil {
+			deviceRoles[*share.DeviceID] = share.Role
+		} else if share.GroupID != nil {
+			groupRoles[*share.GroupID] = share.Role
+		}
+	}
+
+	var memberRole string
+	query := db.Where("umkm_data_id = ? AND organization_id IS NULL", userID)
+	if organizationID != nil {
+		var err error
+		if memberRole, err = GetOrganizationRole(db, userID, *organizationID); err != nil {
+			return nil, nil, err
+		}
+		if memberRole != "" {
+			query = query.Or("organization_id = ? AND umkm_data_id IS NOT NULL", *organizationID)
+		}
+	}
+	if len(deviceRoles) > 0 {
+		query = query.Or("id IN ? AND umkm_data_id IS NOT NULL", mapKeys(deviceRoles))
+	}
+	if len(groupRoles) > 0 {
+		query = query.Or("group_id IN ? AND umkm_data_id IS NOT NULL", mapKeys(groupRoles))
+	}
+	var devices []Device
+	if err := query.Order("name ASC").Find(&devices).Error; err != nil {
+		return nil, nil, err
+	}
+
+	roles := make(map[uuid.UUID]string, len(devices))
+	for _, device := range devices {
+		if *device.UmkmDataId == userID {
+			roles[device.ID] = RoleOwner
+			continue
+		}
+		role := deviceRoles[device.ID]
+		if device.GroupID != nil {
+			role = higherRole(role, groupRoles[*device.GroupID])
+		}
+		if device.OrganizationID != nil && organizationID != nil && *device.OrganizationID == *organizationID {
+			role = higherRole(role, memberRole)
+		}
+		roles[device.ID] = role
+	}
+	return devices, roles, nil
+}
+
+// GetSharedGroups returns the groups of other accounts shared with the user.
+func GetSharedGroups(db *gorm.DB, userID uuid.UUID) ([]DeviceGrouping, error) {
+	var groups []DeviceGrouping
+	err := db.Where("id IN (?)", db.Model(&DeviceShare{}).Select("group_id").Where("umkm_data_id = ? AND group_id IS NOT NULL", userID)).
+		Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func mapKeys(m map[uuid.UUID]string) []uuid.UUID {
+	keys := make([]uuid.UUID, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func GetDeviceShares(db *gorm.DB, deviceID uuid.UUID) ([]DeviceShare, error) {
+	var shares []DeviceShare
+	if err := db.Where("device_id = ?", deviceID).Order("created_at ASC").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func GetGroupShares(db *gorm.DB, groupID uuid.UUID) ([]DeviceShare, error) {
+	var shares []DeviceShare
+	if err := db.Where("group_id = ?", groupID).Order("created_at ASC").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// GetUserShares returns the shares other accounts granted to the user.
+func GetUserShares(db *gorm.DB, userID uuid.UUID) ([]DeviceShare, error) {
+	var shares []DeviceShare
+	if err := db.Where("umkm_data_id = ?", userID).Order("created_at ASC").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func GetShareById(db *gorm.DB, shareID uuid.UUID) (*DeviceShare, error) {
+	var share DeviceShare
+	if err := db.Where("id = ?", shareID).First(&share).Error; err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// FindShare returns the share of the user on the device or group, or nil when there is none.
+func FindShare(db *gorm.DB, userID uuid.UUID, deviceID *uuid.UUID, groupID *uuid.UUID) (*DeviceShare, error) {
+	var shares []DeviceShare
+	query := db.Where("umkm_data_id = ?", userID)
+	if deviceID != nil {
+		query = query.Where("device_id = ?", *deviceID)
+	} else {
+		query = query.Where("group_id = ?", *groupID)
+	}
+	if err := query.Limit(1).Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, nil
+	}
+	return &shares[0], nil
+}
+
+// SaveShare grants the role, or changes it when the user already has a share on the same device or group.
+func SaveShare(db *gorm.DB, share *DeviceShare) error {
+	existing, err := FindShare(db, share.UmkmDataId, share.DeviceID, share.GroupID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		share.ID = existing.ID
+		share.CreatedAt = existing.CreatedAt
+	} else {
+		share.ID = uuid.New()
+	}
+	return db.Save(share).Error
+}
+
+func DeleteShare(db *gorm.DB, share *DeviceShare) error {
+	return db.Unscoped().Delete(share).Error
+}
+
+func DeleteDeviceShares(db *gorm.DB, deviceID uuid.UUID) error {
+	return db.Unscoped().Where("device_id = ?", deviceID).Delete(&DeviceShare{}).Error
+}
+
+func DeleteGroupShares(db *gorm.DB, groupID uuid.UUID) error {
+	return db.Unscoped().Where("group_id = ?", groupID).Delete(&DeviceShare{}).Error
+}
@@ -0,0 +1,392 @@
+package models
+
+import (
+	"errors"
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+const (
+	TransferStatusPending   = "pending"
+	TransferStatusAccepted  = "accepted"
+	TransferStatusDeclined  = "declined"
+	TransferStatusCancelled = "cancelled"
+	TransferStatusExpired   = "expired"
+)
+
+// TransferHistoryKeep hands the readings over with the device; TransferHistoryArchive moves them
+// into a DeviceArchive that stays with the previous owner.
+const (
+	TransferHistoryKeep    = "keep"
+	TransferHistoryArchive = "archive"
+)
+
+const (
+	TransferActionRequested = "requested"
+	TransferActionAccepted  = "accepted"
+	TransferActionDeclined  = "declined"
+	TransferActionCancelled = "cancelled"
+	TransferActionExpired   = "expired"
+	TransferActionArchived  = "archived"
+)
+
+// DeviceTransfer hands a registered device from one UMKM account to another once the recipient accepts.
+// FromUserID is the account the device was registered to and InitiatedByID the user who started the
+// transfer, who differ for an organization's device. Names are copied so the transfer still reads
+// correctly after either side renames.
+type DeviceTransfer struct {
+	gorm.Model
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	DeviceID      uuid.UUID `gorm:"type:uuid;column:device_id;index"`
+	DeviceName    string
+	FromUserID    uuid.UUID `gorm:"type:uuid;column:from_user_id;index"`
+	InitiatedByID uuid.UUID `gorm:"type:uuid;column:initiated_by_id;index"`
+	FromName      string
+	ToUserID      uuid.UUID `gorm:"type:uuid;column:to_user_id;index"`
+	ToName        string
+	ToEmail       string
+	History       string
+	Status        string `gorm:"index"`
+	Note          string
+	Token         string `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt     time.Time
+	RespondedAt   *time.Time
+	ArchiveID     *uuid.UUID `gorm:"type:uuid;column:archive_id"`
+}
+
+type DeviceTransferAuditLog struct {
+	gorm.Model
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key"`
+	TransferID uuid.UUID  `gorm:"type:uuid;column:transfer_id;index"`
+	DeviceID   uuid.UUID  `gorm:"type:uuid;column:device_id;index"`
+	ActorID    *uuid.UUID `gorm:"type:uuid;column:actor_id"`
+	ActorName  string
+	Action     string
+	Detail     string
+	OccurredAt time.Time
+}
+
+// DeviceArchive holds the history of a device the user transferred away. Its ID takes the place of the
+// device ID on the archived readings, rollups and rejected readings, so the new owner never sees them.
+type DeviceArchive struct {
+	gorm.Model
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	UmkmDataId uuid.UUID `gorm:"column:umkm_data_id;index"`
+	DeviceID   uuid.UUID `gorm:"type:uuid;column:device_id"`
+	DeviceName string
+	TransferID uuid.UUID `gorm:"type:uuid;column:transfer_id"`
+	ArchivedAt time.Time
+}
+
+// IsSender reports whether the user is on the sending side of the transfer, as the account the device was
+// registered to or as the user who started it.
+func (transfer *DeviceTransfer) IsSender(userID uuid.UUID) bool {
+	return transfer.FromUserID == userID || transfer.InitiatedByID == userID
+}
+
+func writeTransferAudit(tx *gorm.DB, transfer *DeviceTransfer, actor *UmkmData, action string, detail string) error {
+	entry := DeviceTransferAuditLog{
+		ID:         uuid.New(),
+		TransferID: transfer.ID,
+		DeviceID:   transfer.DeviceID,
+		Action:     action,
+		Detail:     detail,
+		OccurredAt: time.Now().UTC(),
+	}
+	if actor != nil {
+		entry.ActorID = &actor.ID
+		entry.ActorName = actor.Name
+	}
+	return tx.Create(&entry).Error
+}
+
+// CreateDeviceTransfer starts a transfer of a registered device. The transfer is made from the account the
+// device is registered to, which for an organization's device need not be the sender. A device has at most
+// one pending transfer.
+func CreateDeviceTransfer(db *gorm.DB, transfer *DeviceTransfer, sender *UmkmData) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&device, "id = ? AND umkm_data_id IS NOT NULL", transfer.DeviceID).Error
+		if err != nil {
+			return err
+		}
+
+		var pending int64
+		err = tx.Model(&DeviceTransfer{}).
+			Where("device_id = ? AND status = ? AND expires_at > ?", device.ID, TransferStatusPending, time.Now()).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		} else if pending > 0 {
+			return utils.ErrTransferInProgress
+		}
+
+		if transfer.ID == uuid.Nil {
+			transfer.ID = uuid.New()
+		}
+		transfer.DeviceName = device.Name
+		transfer.FromUserID = *device.UmkmDataId
+		transfer.InitiatedByID = sender.ID
+		transfer.FromName = sender.Name
+		transfer.Status = TransferStatusPending
+		if err := tx.Create(transfer).Error; err != nil {
+			return err
+		}
+		return writeTransferAudit(tx, transfer, sender, TransferActionRequested, "History: "+transfer.History)
+	})
+}
+
+func GetDeviceTransferById(db *gorm.DB, transferID uuid.UUID) (*DeviceTransfer, error) {
+	var transfer DeviceTransfer
+	if err := db.Where("id = ?", transferID).First(&transfer).Error; err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func GetDeviceTransferByToken(db *gorm.DB, token string) (*DeviceTransfer, error) {
+	var transfer DeviceTransfer
+	if err := db.Where("token = ?", token).First(&transfer).Error; err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// GetUserDeviceTransfers returns the transfers the user sent, started or received, newest first.
+func GetUserDeviceTransfers(db *gorm.DB, userID uuid.UUID) ([]DeviceTransfer, error) {
+	var transfers []DeviceTransfer
+	err := db.Where("from_user_id = ? OR initiated_by_id = ? OR to_user_id = ?", userID, userID, userID).
+		Order("created_at DESC").
+		Find(&transfers).Error
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+func GetDeviceTransferAuditLogs(db *gorm.DB, transferID uuid.UUID) ([]DeviceTransferAuditLog, error) {
+	var entries []DeviceTransferAuditLog
+	err := db.Where("transfer_id = ?", transferID).Order("occurred_at ASC").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// lockPendingTransfer locks the transfer and checks that it can still be answered. An expired transfer is
+// closed on the way, which is why the caller has to commit even when ErrTransferExpired is returned.
+func lockPendingTransfer(tx *gorm.DB, transferID uuid.UUID) (*DeviceTransfer, error) {
+	var transfer DeviceTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, err
+	}
+	if transfer.Status != TransferStatusPending {
+		return &transfer, utils.ErrTransferNotPending
+	}
+	if time.Now().After(transfer.ExpiresAt) {
+		if err := closeTransfer(tx, &transfer, nil, TransferStatusExpired, TransferActionExpired, ""); err != nil {
+			return nil, err
+		}
+		return &transfer, utils.ErrTransferExpired
+	}
+	return &transfer, nil
+}
+
+func closeTransfer(tx *gorm.DB, transfer *DeviceTransfer, actor *UmkmData, status string, action string, detail string) error {
+	now := time.Now().UTC()
+	transfer.Status = status
+	transfer.RespondedAt = &now
+	if err := tx.Save(transfer).Error; err != nil {
+		return err
+	}
+	return writeTransferAudit(tx, transfer, actor, action, detail)
+}
+
+// CloseDeviceTransfer declines or cancels a pending transfer.
+func CloseDeviceTransfer(db *gorm.DB, transferID uuid.UUID, actor *UmkmData, status string, action string) (*DeviceTransfer, error) {
+	var transfer *DeviceTransfer
+	var closeErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = lockPendingTransfer(tx, transferID)
+		if errors.Is(err, utils.ErrTransferExpired) || errors.Is(err, utils.ErrTransferNotPending) {
+			closeErr = err
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return closeTransfer(tx, transfer, actor, status, action, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfer, closeErr
+}
+
+// AcceptDeviceTransfer moves the device to the recipient. The device leaves the sender's group, and the alert
+// states of the sender's rules and the sender's shares are dropped; with TransferHistoryArchive the history
+// moves into a DeviceArchive. The device joins the recipient's active organization only when the recipient
+// may add devices to it, see transferTargetOrganization.
+func AcceptDeviceTransfer(db *gorm.DB, transferID uuid.UUID, recipient *UmkmData) (*DeviceTransfer, error) {
+	var transfer *DeviceTransfer
+	var acceptErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = lockPendingTransfer(tx, transferID)
+		if errors.Is(err, utils.ErrTransferExpired) || errors.Is(err, utils.ErrTransferNotPending) {
+			acceptErr = err
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var device Device
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, "id = ?", transfer.DeviceID).Error; err != nil {
+			return err
+		}
+		// The sender released the device after starting the transfer, so there is nothing left to hand over.
+		if device.UmkmDataId == nil || *device.UmkmDataId != transfer.FromUserID {
+			acceptErr = utils.ErrTransferNotPending
+			return closeTransfer(tx, transfer, nil, TransferStatusCancelled, TransferActionCancelled, "Device is no longer owned by the sender")
+		}
+
+		if device.GroupID != nil {
+			if err, _, _ := UnassignDeviceFromGroup(tx, device.ID, transfer.FromUserID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("device_id = ?", device.ID).Delete(&AlertState{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("device_id = ? AND umkm_data_id = ?", device.ID, transfer.FromUserID).Delete(&AlertRule{}).Error; err != nil {
+			return err
+		}
+		if err := DeleteDeviceShares(tx, device.ID); err != nil {
+			return err
+		}
+
+		if transfer.History == TransferHistoryArchive {
+			archive := DeviceArchive{
+				ID:         uuid.New(),
+				UmkmDataId: transfer.FromUserID,
+				DeviceID:   device.ID,
+				DeviceName: device.Name,
+				TransferID: transfer.ID,
+				ArchivedAt: time.Now().UTC(),
+			}
+			if err := archiveDeviceHistory(tx, &archive); err != nil {
+				return err
+			}
+			transfer.ArchiveID = &archive.ID
+			if err := writeTransferAudit(tx, transfer, nil, TransferActionArchived, "Archive: "+archive.ID.String()); err != nil {
+				return err
+			}
+		}
+
+		organizationID, detail, err := transferTargetOrganization(tx, recipient)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Device{}).Where("id = ?", device.ID).Updates(map[string]interface{}{
+			"umkm_data_id":    recipient.ID,
+			"organization_id": organizationID,
+			"group_id":        nil,
+			"group_name":      nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		transfer.ToName = recipient.Name
+		return closeTransfer(tx, transfer, recipient, TransferStatusAccepted, TransferActionAccepted, detail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfer, acceptErr
+}
+
+// transferTargetOrganization picks the organization an accepted device joins, with the audit detail recording
+// the choice. Adding a device gives the organization's owners and admins access to it, so like claiming into a
+// group it needs at least RoleOperator in the recipient's active organization; otherwise the device arrives
+// as a personal device.
+func transferTargetOrganization(tx *gorm.DB, recipient *UmkmData) (*uuid.UUID, string, error) {
+	if recipient.ActiveOrganizationID == nil {
+		return nil, "Personal device", nil
+	}
+	organizationID := *recipient.ActiveOrganizationID
+	role, err := GetOrganizationRole(tx, recipient.ID, organizationID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !RoleAllows(role, RoleOperator) {
+		return nil, "Personal device: recipient is not an operator of organization " + organizationID.String(), nil
+	}
+	return &organizationID, "Organization: " + organizationID.String(), nil
+}
+
+func archiveDeviceHistory(tx *gorm.DB, archive *DeviceArchive) error {
+	if err := tx.Create(archive).Error; err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&DeviceReading{}, &ReadingRollup{}, &RejectedReading{}} {
+		if err := tx.Model(model).Where("device_id = ?", archive.DeviceID).Update("device_id", archive.ID).Error; err != nil {
+			return err
+		}
+	}
+	// Legacy CSV data is dropped the same way as when a device is released.
+	var device Device
+	if err := tx.First(&device, "id = ?", archive.DeviceID).Error; err != nil {
+		return err
+	}
+	if lines := strings.SplitN(string(device.Data), "\n", 2); len(lines) > 0 {
+		return tx.Model(&device).Update("data", []byte(lines[0]+"\n")).Error
+	}
+	return nil
+}
+
+func GetUserDeviceArchives(db *gorm.DB, userID uuid.UUID) ([]DeviceArchive, error) {
+	var archives []DeviceArchive
+	err := db.Where("umkm_data_id = ?", userID).Order("archived_at DESC").Find(&archives).Error
+	if err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+func GetUserDeviceArchive(db *gorm.DB, userID uuid.UUID, archiveID uuid.UUID) (*DeviceArchive, error) {
+	var archive DeviceArchive
+	if err := db.Where("id = ? AND umkm_data_id = ?", archiveID, userID).First(&archive).Error; err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// GetDeviceArchives returns every archive, for jobs such as retention that treat archives like devices.
+func GetDeviceArchives(db *gorm.DB) ([]DeviceArchive, error) {
+	var archives []DeviceArchive
+	if err := db.Find(&archives).Error; err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// DeleteDeviceArchive removes an archive together with the history it holds.
+func DeleteDeviceArchive(db *gorm.DB, archive *DeviceArchive) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteDeviceReadings(tx, archive.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceRollups(tx, archive.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceQualityData(tx, archive.ID); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(archive).Error
+	})
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestDeviceTransferIsSender(t *testing.T) {
+	owner, initiator, recipient := uuid.New(), uuid.New(), uuid.New()
+	transfer := DeviceTransfer{FromUserID: owner, InitiatedByID: initiator, ToUserID: recipient}
+
+	if !transfer.IsSender(owner) || !transfer.IsSender(initiator) {
+		t.Fatal("the registered account and the initiator must both count as the sender")
+	}
+	if transfer.IsSender(recipient) || transfer.IsSender(uuid.New()) {
+		t.Fatal("only the registered account and the initiator count as the sender")
+	}
+}
+
+func TestAcceptDeviceTransferArchivesHistory(t *testing.T) {
+	db := openTestDB(t)
+	owner := createTestUser(t, db, "owner")
+	initiator := createTestUser(t, db, "initiator")
+	recipient := createTestUser(t, db, "recipient")
+	device := createTestDevice(t, db, owner)
+
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		reading := DeviceReading{DeviceID: device.ID, TimeStamp: at.Add(time.Duration(i) * time.Minute), PhLevel: 7}
+		if err := AppendDeviceReading(db, &reading); err != nil {
+			t.Fatal("Failed to append reading:", err)
+		}
+	}
+	rejected := RejectedReading{ID: uuid.New(), DeviceID: device.ID, TimeStamp: at, Reasons: "ph_level out of range", ReceivedAt: at}
+	if err := db.Create(&rejected).Error; err != nil {
+		t.Fatal("Failed to create rejected reading:", err)
+	}
+	rollups := countRows(t, db, &ReadingRollup{}, device.ID)
+	if rollups == 0 {
+		t.Fatal("appending readings did not create rollups")
+	}
+
+	transfer := DeviceTransfer{
+		DeviceID:  device.ID,
+		ToUserID:  recipient.ID,
+		History:   TransferHistoryArchive,
+		Token:     uuid.NewString(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := CreateDeviceTransfer(db, &transfer, initiator); err != nil {
+		t.Fatal("Failed to create transfer:", err)
+	}
+	if transfer.FromUserID != owner.ID || transfer.InitiatedByID != initiator.ID {
+		t.Fatalf("from = %s, initiated by = %s", transfer.FromUserID, transfer.InitiatedByID)
+	}
+	sent, err := GetUserDeviceTransfers(db, initiator.ID)
+	if err != nil || len(sent) != 1 || sent[0].ID != transfer.ID {
+		t.Fatalf("the initiator does not see the transfer: %v, %v", sent, err)
+	}
+
+	accepted, err := AcceptDeviceTransfer(db, transfer.ID, recipient)
+	if err != nil {
+		t.Fatal("Failed to accept transfer:", err)
+	}
+	if accepted.Status != TransferStatusAccepted || accepted.ArchiveID == nil {
+		t.Fatalf("status = %s, archive = %v", accepted.Status, accepted.ArchiveID)
+	}
+
+	var moved Device
+	if err := db.First(&moved, "id = ?", device.ID).Error; err != nil {
+		t.Fatal("Failed to reload device:", err)
+	}
+	if moved.UmkmDataId == nil || *moved.UmkmDataId != recipient.ID {
+		t.Fatalf("device owner = %v, want %s", moved.UmkmDataId, recipient.ID)
+	}
+	if string(moved.Data) != "TimeStamp\n" {
+		t.Fatalf("device data = %q, want only the header", moved.Data)
+	}
+
+	archiveID := *accepted.ArchiveID
+	for _, check := range []struct {
+		name  string
+		model interface{}
+		want  int64
+	}{
+		{"readings", &DeviceReading{}, 3},
+		{"rollups", &ReadingRollup{}, rollups},
+		{"rejected readings", &RejectedReading{}, 1},
+	} {
+		if left := countRows(t, db, check.model, device.ID); left != 0 {
+			t.Errorf("%d %s left on the device", left, check.name)
+		}
+		if archived := countRows(t, db, check.model, archiveID); archived != check.want {
+			t.Errorf("%d %s archived, want %d", archived, check.name, check.want)
+		}
+	}
+
+	archive, err := GetUserDeviceArchive(db, owner.ID, archiveID)
+	if err != nil {
+		t.Fatal("The previous owner cannot find the archive:", err)
+	}
+	if archive.DeviceID != device.ID || archive.TransferID != transfer.ID {
+		t.Fatalf("archive = %+v", archive)
+	}
+	if _, err := GetUserDeviceArchive(db, recipient.ID, archiveID); err == nil {
+		t.Fatal("the recipient must not see the previous owner's archive")
+	}
+}
+
+func TestAcceptDeviceTransferNeedsOperatorInOrganization(t *testing.T) {
+	db := openTestDB(t)
+	owner := createTestUser(t, db, "owner")
+	organizationOwner := createTestUser(t, db, "organization-owner")
+	organization := Organization{Name: "Farm"}
+	if err := CreateOrganization(db, &organization, organizationOwner); err != nil {
+		t.Fatal("Failed to create organization:", err)
+	}
+
+	for _, role := range []string{RoleViewer, RoleOperator} {
+		recipient := createTestUser(t, db, role)
+		member := OrganizationMember{ID: uuid.New(), OrganizationID: organization.ID, UmkmDataId: recipient.ID, Role: role}
+		if err := db.Create(&member).Error; err != nil {
+			t.Fatal("Failed to add member:", err)
+		}
+		recipient.ActiveOrganizationID = &organization.ID
+
+		device := createTestDevice(t, db, owner)
+		transfer := DeviceTransfer{
+			DeviceID:  device.ID,
+			ToUserID:  recipient.ID,
+			History:   TransferHistoryKeep,
+			Token:     uuid.NewString(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := CreateDeviceTransfer(db, &transfer, owner); err != nil {
+			t.Fatal("Failed to create transfer:", err)
+		}
+		if _, err := AcceptDeviceTransfer(db, transfer.ID, recipient); err != nil {
+			t.Fatal("Failed to accept transfer:", err)
+		}
+
+		var moved Device
+		if err := db.First(&moved, "id = ?", device.ID).Error; err != nil {
+			t.Fatal("Failed to reload device:", err)
+		}
+		joined := moved.OrganizationID != nil && *moved.OrganizationID == organization.ID
+		if joined != (role == RoleOperator) {
+			t.Errorf("%s recipient: device organization = %v", role, moved.OrganizationID)
+		}
+		if _, role, err := GetAccessibleDevice(db, organizationOwner.ID, device.ID, RoleViewer); joined != (err == nil) {
+			t.Errorf("organization owner holds %q on the device, err = %v", role, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MetricDefinition describes a metric devices may report. The four built-in metrics keep their own
+// reading columns; every other defined metric is stored in DeviceReading.Metrics. Values outside
+// MinValue..MaxValue are rejected and values outside SuspectMin..SuspectMax are flagged as suspect.
+type MetricDefinition struct {
+	gorm.Model
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name       string    `gorm:"uniqueIndex"`
+	Unit       string
+	MinValue   *float64
+	MaxValue   *float64
+	SuspectMin *float64
+	SuspectMax *float64
+	Precision  *int
+	IsBuiltin  bool
+}
+
+var builtinMetricUnits = map[string]string{
+	"oxygen_level": "mg/L",
+	"water_temp":   "°C",
+	"ec_level":     "µS/cm",
+	"ph_level":     "pH",
+}
+
+// MetricValues holds the values of the non built-in metrics of a reading as a jsonb object.
+type MetricValues map[string]float64
+
+func (values MetricValues) Value() (driver.Value, error) {
+	if values == nil {
+		return nil, nil
+	}
+	return json.Marshal(values)
+}
+
+func (values *MetricValues) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*values = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for metric values")
+	}
+	return json.Unmarshal(data, values)
+}
+
+func IsBuiltinMetric(name string) bool {
+	_, ok := builtinMetricUnits[name]
+	return ok
+}
+
+// SeedBuiltinMetricDefinitions makes sure the built-in metrics are defined. Existing definitions are left as they are.
+func SeedBuiltinMetricDefinitions(db *gorm.DB) error {
+	definitions := make([]MetricDefinition, 0, len(ReadingMetrics))
+	for _, name := range ReadingMetrics {
+		definitions = append(definitions, MetricDefinition{
+			ID:        uuid.New(),
+			Name:      name,
+			Unit:      builtinMetricUnits[name],
+			IsBuiltin: true,
+		})
+	}
+	return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&definitions).Error
+}
+
+func GetMetricDefinitions(db *gorm.DB) ([]MetricDefinition, error) {
+	var definitions []MetricDefinition
+	if err := db.Order("is_builtin DESC, name ASC").Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
+
+func GetMetricDefinitionByName(db *gorm.DB, name string) (*MetricDefinition, error) {
+	var definition MetricDefinition
+	if err := db.Where("name = ?", name).First(&definition).Error; err != nil {
+		return nil, err
+	}
+	return &definition, nil
+}
+
+func SaveMetricDefinition(db *gorm.DB, definition *MetricDefinition) error {
+	if definition.ID == uuid.Nil {
+		definition.ID = uuid.New()
+	}
+	return db.Save(definition).Error
+}
+
+func DeleteMetricDefinition(db *gorm.DB, definition *MetricDefinition) error {
+	return db.Unscoped().Delete(definition).Error
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const DefaultNotificationIntervalMinutes = 30
+
+type NotificationPreference struct {
+	gorm.Model
+	ID                 uuid.UUID `gorm:"type:uuid;primary_key"`
+	UmkmDataId         uuid.UUID `gorm:"column:umkm_data_id;uniqueIndex"`
+	EmailEnabled       bool      `json:"email_enabled"`
+	NotifyOnResolved   bool      `json:"notify_on_resolved"`
+	MinIntervalMinutes int       `json:"min_interval_minutes"`
+}
+
+// GetNotificationPreference returns the stored preference of a user, or the defaults when none was saved yet.
+func GetNotificationPreference(db *gorm.DB, userID uuid.UUID) (*NotificationPreference, error) {
+	var preference NotificationPreference
+	err := db.Where("umkm_data_id = ?", userID).First(&preference).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &NotificationPreference{
+			UmkmDataId:         userID,
+			EmailEnabled:       true,
+			NotifyOnResolved:   true,
+			MinIntervalMinutes: DefaultNotificationIntervalMinutes,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+func SaveNotificationPreference(db *gorm.DB, preference *NotificationPreference) error {
+	if preference.ID == uuid.Nil {
+		preference.ID = uuid.New()
+	}
+	return db.Save(preference).Error
+}
@@ -0,0 +1,353 @@
+package models
+
+import (
+	"errors"
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// Organization is the business behind an account. It owns the devices and groups its members register, and
+// members reach them with their membership role (RoleOwner, RoleAdmin, RoleOperator or RoleViewer).
+type Organization struct {
+	gorm.Model
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedByID uuid.UUID `gorm:"type:uuid;column:created_by_id" json:"created_by_id"`
+}
+
+type OrganizationMember struct {
+	gorm.Model
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	OrganizationID uuid.UUID `gorm:"type:uuid;column:organization_id;uniqueIndex:idx_organization_members_user,priority:1"`
+	UmkmDataId     uuid.UUID `gorm:"column:umkm_data_id;uniqueIndex:idx_organization_members_user,priority:2;index"`
+	Role           string
+	UserName       string
+	UserEmail      string
+}
+
+// OrganizationInvitation lets an organization admin add someone by email. It is accepted once, by the
+// account that uses that email.
+type OrganizationInvitation struct {
+	gorm.Model
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	OrganizationID uuid.UUID `gorm:"type:uuid;column:organization_id;index"`
+	Email          string
+	Role           string
+	Token          string    `gorm:"uniqueIndex" json:"-"`
+	InvitedByID    uuid.UUID `gorm:"type:uuid;column:invited_by_id"`
+	InvitedBy      string
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+}
+
+// IsOrganizationRole reports whether role can be held by an organization member.
+func IsOrganizationRole(role string) bool {
+	return role == RoleOwner || IsShareRole(role)
+}
+
+// CreateOrganization creates the organization with user as its owner and makes it the user's active organization.
+func CreateOrganization(db *gorm.DB, organization *Organization, user *UmkmData) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if organization.ID == uuid.Nil {
+			organization.ID = uuid.New()
+		}
+		organization.CreatedByID = user.ID
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+
+		member := OrganizationMember{
+			ID:             uuid.New(),
+			OrganizationID: organization.ID,
+			UmkmDataId:     user.ID,
+			Role:           RoleOwner,
+			UserName:       user.Name,
+			UserEmail:      user.Email,
+		}
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&UmkmData{}).Where("id = ?", user.ID).Update("active_organization_id", organization.ID).Error; err != nil {
+			return err
+		}
+		user.ActiveOrganizationID = &organization.ID
+		return nil
+	})
+}
+
+// MigrateUserToOrganization moves an account that is not in any organization yet into a one-member
+// organization named after its business, together with the devices and groups it owns.
+func MigrateUserToOrganization(db *gorm.DB, user *UmkmData) (bool, error) {
+	migrated := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var memberships int64
+		if err := tx.Model(&OrganizationMember{}).Where("umkm_data_id = ?", user.ID).Count(&memberships).Error; err != nil {
+			return err
+		} else if memberships > 0 {
+			return nil
+		}
+
+		name := user.BusinessName
+		if name == "" {
+			name = user.Name
+		}
+		organization := Organization{Name: name, Description: user.BusinessDesc}
+		if err := CreateOrganization(tx, &organization, user); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Device{}, &DeviceGrouping{}} {
+			err := tx.Model(model).
+				Where("umkm_data_id = ? AND organization_id IS NULL", user.ID).
+				Update("organization_id", organization.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+		migrated = true
+		return nil
+	})
+	return migrated, err
+}
+
+func GetOrganizationById(db *gorm.DB, organizationID uuid.UUID) (*Organization, error) {
+	var organization Organization
+	if err := db.Where("id = ?", organizationID).First(&organization).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func SaveOrganization(db *gorm.DB, organization *Organization) error {
+	return db.Save(organization).Error
+}
+
+// GetOrganizationRole returns the membership role of the user, or an empty string when the user is not a member.
+func GetOrganizationRole(db *gorm.DB, userID uuid.UUID, organizationID uuid.UUID) (string, error) {
+	var members []OrganizationMember
+	err := db.Where("organization_id = ? AND umkm_data_id = ?", organizationID, userID).Limit(1).Find(&members).Error
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+	return members[0].Role, nil
+}
+
+// GetAccessibleOrganization loads an organization the user is a member of with at least minRole, with the
+// same errors as GetAccessibleDevice.
+func GetAccessibleOrganization(db *gorm.DB, userID uuid.UUID, organizationID uuid.UUID, minRole string) (*Organization, string, error) {
+	organization, err := GetOrganizationById(db, organizationID)
+	if err != nil {
+		return nil, "", err
+	}
+	role, err := GetOrganizationRole(db, userID, organizationID)
+	if err != nil {
+		return nil, "", err
+	} else if role == "" {
+		return nil, "", gorm.ErrRecordNotFound
+	} else if !RoleAllows(role, minRole) {
+		return organization, role, utils.ErrInsufficientRole
+	}
+	return organization, role, nil
+}
+
+// GetUserMemberships returns the organizations the user belongs to.
+func GetUserMemberships(db *gorm.DB, userID uuid.UUID) ([]OrganizationMember, error) {
+	var members []OrganizationMember
+	if err := db.Where("umkm_data_id = ?", userID).Order("created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func GetOrganizationsByIds(db *gorm.DB, organizationIDs []uuid.UUID) ([]Organization, error) {
+	var organizations []Organization
+	if err := db.Where("id IN ?", organizationIDs).Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+func GetOrganizationMembers(db *gorm.DB, organizationID uuid.UUID) ([]OrganizationMember, error) {
+	var members []OrganizationMember
+	if err := db.Where("organization_id = ?", organizationID).Order("created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func GetOrganizationMember(db *gorm.DB, organizationID uuid.UUID, userID uuid.UUID) (*OrganizationMember, error) {
+	var member OrganizationMember
+	if err := db.Where("organization_id = ? AND umkm_data_id = ?", organizationID, userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// countOtherOwners counts the owners of the organization besides the given member, with the owner rows locked
+// so two owners cannot demote or remove each other at the same time.
+func countOtherOwners(tx *gorm.DB, member *OrganizationMember) (int, error) {
+	var owners []OrganizationMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND role = ? AND id <> ?", member.OrganizationID, RoleOwner, member.ID).
+		Find(&owners).Error
+	return len(owners), err
+}
+
+// UpdateOrganizationMemberRole changes the role of a member. An organization always keeps at least one owner.
+func UpdateOrganizationMemberRole(db *gorm.DB, member *OrganizationMember, role string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if member.Role == RoleOwner && role != RoleOwner {
+			owners, err := countOtherOwners(tx, member)
+			if err != nil {
+				return err
+			} else if owners == 0 {
+				return utils.ErrLastOrganizationOwner
+			}
+		}
+		member.Role = role
+		return tx.Save(member).Error
+	})
+}
+
+// RemoveOrganizationMember takes the user out of the organization. The devices and groups registered to the
+// member stay with the organization and move to its longest-standing remaining owner, and the member's alert
+// rules on them are dropped. The member's active organization is cleared when it was this one.
+func RemoveOrganizationMember(db *gorm.DB, member *OrganizationMember) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		owners, err := countOtherOwners(tx, member)
+		if err != nil {
+			return err
+		} else if owners == 0 {
+			return utils.ErrLastOrganizationOwner
+		}
+
+		var owner OrganizationMember
+		err = tx.Where("organization_id = ? AND role = ? AND id <> ?", member.OrganizationID, RoleOwner, member.ID).
+			Order("created_at ASC").
+			First(&owner).Error
+		if err != nil {
+			return err
+		}
+
+		deviceIDs := tx.Model(&Device{}).Select("id").
+			Where("organization_id = ? AND umkm_data_id = ?", member.OrganizationID, member.UmkmDataId)
+		groupIDs := tx.Model(&DeviceGrouping{}).Select("id").
+			Where("organization_id = ? AND umkm_data_id = ?", member.OrganizationID, member.UmkmDataId)
+		ruleIDs := tx.Model(&AlertRule{}).Select("id").
+			Where("umkm_data_id = ? AND (device_id IN (?) OR group_id IN (?))", member.UmkmDataId, deviceIDs, groupIDs)
+		if err := tx.Unscoped().Where("rule_id IN (?)", ruleIDs).Delete(&AlertState{}).Error; err != nil {
+			return err
+		}
+		err = tx.Unscoped().
+			Where("umkm_data_id = ? AND (device_id IN (?) OR group_id IN (?))", member.UmkmDataId, deviceIDs, groupIDs).
+			Delete(&AlertRule{}).Error
+		if err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Device{}, &DeviceGrouping{}} {
+			err := tx.Model(model).
+				Where("organization_id = ? AND umkm_data_id = ?", member.OrganizationID, member.UmkmDataId).
+				Update("umkm_data_id", owner.UmkmDataId).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Delete(member).Error; err != nil {
+			return err
+		}
+		return tx.Model(&UmkmData{}).
+			Where("id = ? AND active_organization_id = ?", member.UmkmDataId, member.OrganizationID).
+			Update("active_organization_id", nil).Error
+	})
+}
+
+func SetActiveOrganization(db *gorm.DB, userID uuid.UUID, organizationID *uuid.UUID) error {
+	return db.Model(&UmkmData{}).Where("id = ?", userID).Update("active_organization_id", organizationID).Error
+}
+
+func CreateOrganizationInvitation(db *gorm.DB, invitation *OrganizationInvitation) error {
+	if invitation.ID == uuid.Nil {
+		invitation.ID = uuid.New()
+	}
+	return db.Create(invitation).Error
+}
+
+// GetPendingInvitations returns the invitations of the organization that were neither accepted nor expired.
+func GetPendingInvitations(db *gorm.DB, organizationID uuid.UUID) ([]OrganizationInvitation, error) {
+	var invitations []OrganizationInvitation
+	err := db.Where("organization_id = ? AND accepted_at IS NULL AND expires_at > ?", organizationID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func GetOrganizationInvitation(db *gorm.DB, organizationID uuid.UUID, invitationID uuid.UUID) (*OrganizationInvitation, error) {
+	var invitation OrganizationInvitation
+	if err := db.Where("id = ? AND organization_id = ?", invitationID, organizationID).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func DeleteOrganizationInvitation(db *gorm.DB, invitation *OrganizationInvitation) error {
+	return db.Unscoped().Delete(invitation).Error
+}
+
+// AcceptOrganizationInvitation makes the user a member with the invited role. A user who is already a member
+// keeps the higher of the two roles.
+func AcceptOrganizationInvitation(db *gorm.DB, token string, user *UmkmData) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var invitation OrganizationInvitation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invitation, "token = ?", token).Error
+		if err != nil {
+			return err
+		}
+		if invitation.AcceptedAt != nil {
+			return utils.ErrInvitationUsed
+		} else if time.Now().After(invitation.ExpiresAt) {
+			return utils.ErrInvitationExpired
+		} else if !strings.EqualFold(invitation.Email, user.Email) {
+			return gorm.ErrRecordNotFound
+		}
+
+		err = tx.Where("organization_id = ? AND umkm_data_id = ?", invitation.OrganizationID, user.ID).First(&member).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			member = OrganizationMember{
+				ID:             uuid.New(),
+				OrganizationID: invitation.OrganizationID,
+				UmkmDataId:     user.ID,
+				UserName:       user.Name,
+				UserEmail:      user.Email,
+			}
+		} else if err != nil {
+			return err
+		}
+		member.Role = higherRole(member.Role, invitation.Role)
+		if err := tx.Save(&member).Error; err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		invitation.AcceptedAt = &now
+		if err := tx.Save(&invitation).Error; err != nil {
+			return err
+		}
+		if user.ActiveOrganizationID == nil {
+			return tx.Model(&UmkmData{}).Where("id = ?", user.ID).Update("active_organization_id", invitation.OrganizationID).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ProvisioningBatch groups the devices created together for one production run. Name is chosen by
+// whoever runs the provisioning and makes re-runs of the same batch idempotent.
+type ProvisioningBatch struct {
+	gorm.Model
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	DeviceCount int       `json:"device_count"`
+}
+
+func GetProvisioningBatchByName(db *gorm.DB, name string) (*ProvisioningBatch, error) {
+	var batch ProvisioningBatch
+	if err := db.Where("name = ?", name).First(&batch).Error; err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetBatchDevices returns the devices of a batch in the order they were provisioned.
+func GetBatchDevices(db *gorm.DB, batchID uuid.UUID) ([]Device, error) {
+	var devices []Device
+	err := db.Where("provisioning_batch_id = ?", batchID).
+		Order("provisioning_index").
+		Find(&devices).Error
+	return devices, err
+}
+
+// CreateProvisioningBatch stores the batch and all of its devices, or nothing at all.
+func CreateProvisioningBatch(db *gorm.DB, batch *ProvisioningBatch, devices []Device) error {
+	batch.DeviceCount = len(devices)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		for i := range devices {
+			index := i + 1
+			devices[i].ProvisioningBatchID = &batch.ID
+			devices[i].ProvisioningIndex = &index
+		}
+		return tx.CreateInBatches(devices, 100).Error
+	})
+}
+
+// GetDevicesBySerialNumbers returns the devices that already carry one of the serial numbers.
+func GetDevicesBySerialNumbers(db *gorm.DB, serials []string) ([]Device, error) {
+	var devices []Device
+	err := db.Where("serial_number IN ?", serials).Find(&devices).Error
+	return devices, err
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+const (
+	QualityGood     = "good"
+	QualitySuspect  = "suspect"
+	QualityRejected = "rejected"
+)
+
+// DevicePlausibilityRange overrides the ranges of a metric definition for a single device.
+// Values outside MinValue..MaxValue are rejected, values outside SuspectMin..SuspectMax are suspect.
+type DevicePlausibilityRange struct {
+	gorm.Model
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	DeviceID   uuid.UUID `gorm:"type:uuid;column:device_id;uniqueIndex:idx_device_plausibility_metric,priority:1"`
+	Metric     string    `gorm:"uniqueIndex:idx_device_plausibility_metric,priority:2"`
+	MinValue   *float64
+	MaxValue   *float64
+	SuspectMin *float64
+	SuspectMax *float64
+}
+
+// RejectedReading keeps values that failed validation apart from device_readings, so they never reach
+// rollups, alerts or exports but can still be inspected. It holds the whole reading when every value was
+// rejected, otherwise only the rejected values of a reading that was stored without them. A reading is
+// quarantined at most once, so a device retrying it does not add rows.
+type RejectedReading struct {
+	gorm.Model
+	ID         uuid.UUID    `gorm:"type:uuid;primary_key"`
+	DeviceID   uuid.UUID    `gorm:"type:uuid;column:device_id;uniqueIndex:idx_rejected_readings_device_time_key,priority:1"`
+	TimeStamp  time.Time    `gorm:"column:time_stamp;uniqueIndex:idx_rejected_readings_device_time_key,priority:2"`
+	MessageID  *string      `gorm:"column:message_id"`
+	Values     MetricValues `gorm:"column:metric_values;type:jsonb"`
+	Reasons    string
+	ReceivedAt time.Time
+}
+
+func (reading *DeviceReading) FlaggedMetricList() []string {
+	if reading.FlaggedMetrics == "" {
+		return nil
+	}
+	return strings.Split(reading.FlaggedMetrics, ",")
+}
+
+func (reading *DeviceReading) RejectedMetricList() []string {
+	if reading.RejectedMetrics == "" {
+		return nil
+	}
+	return strings.Split(reading.RejectedMetrics, ",")
+}
+
+func (reading *DeviceReading) IsMetricRejected(metric string) bool {
+	for _, name := range reading.RejectedMetricList() {
+		if name == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// QualityOrGood reports readings stored before quality flags existed as good.
+func (reading *DeviceReading) QualityOrGood() string {
+	if reading.Quality == "" {
+		return QualityGood
+	}
+	return reading.Quality
+}
+
+func (rejected *RejectedReading) ReasonList() []string {
+	if rejected.Reasons == "" {
+		return nil
+	}
+	return strings.Split(rejected.Reasons, "; ")
+}
+
+func GetDevicePlausibilityRanges(db *gorm.DB, deviceID uuid.UUID) ([]DevicePlausibilityRange, error) {
+	var ranges []DevicePlausibilityRange
+	if err := db.Where("device_id = ?", deviceID).Order("metric ASC").Find(&ranges).Error; err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+func SaveDevicePlausibilityRange(db *gorm.DB, plausibility *DevicePlausibilityRange) error {
+	if plausibility.ID == uuid.Nil {
+		plausibility.ID = uuid.New()
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}, {Name: "metric"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min_value", "max_value", "suspect_min", "suspect_max", "updated_at"}),
+	}).Create(plausibility).Error
+}
+
+func DeleteDevicePlausibilityRange(db *gorm.DB, deviceID uuid.UUID, metric string) (int64, error) {
+	result := db.Unscoped().Where("device_id = ? AND metric = ?", deviceID, metric).Delete(&DevicePlausibilityRange{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateRejectedReading quarantines the values unless the device already has a rejected reading at the
+// same time stamp, which happens when a device retries.
+func CreateRejectedReading(db *gorm.DB, rejected *RejectedReading) error {
+	if rejected.ID == uuid.Nil {
+		rejected.ID = uuid.New()
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}, {Name: "time_stamp"}},
+		DoNothing: true,
+	}).Create(rejected).Error
+}
+
+func GetRejectedReadings(db *gorm.DB, deviceID uuid.UUID, start time.Time, end time.Time, limit int) ([]RejectedReading, error) {
+	var rejected []RejectedReading
+	err := db.Where("device_id = ? AND time_stamp >= ? AND time_stamp < ?", deviceID, start, end).
+		Order("time_stamp DESC").
+		Limit(limit).
+		Find(&rejected).Error
+	if err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
+
+func DeleteRejectedReadingsBefore(db *gorm.DB, deviceID uuid.UUID, cutoff time.Time) (int64, error) {
+	result := db.Unscoped().Where("device_id = ? AND time_stamp < ?", deviceID, cutoff).Delete(&RejectedReading{})
+	return result.RowsAffected, result.Error
+}
+
+// EnsureRejectedReadingUniqueIndex removes repeated quarantine rows of older databases, keeping the first
+// one of every device and time stamp, and replaces their plain index with the unique one.
+func EnsureRejectedReadingUniqueIndex(db *gorm.DB) (int64, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&RejectedReading{}) {
+		return 0, db.AutoMigrate(&RejectedReading{})
+	}
+
+	result := db.Exec(`DELETE FROM rejected_readings WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY device_id, time_stamp ORDER BY received_at, id) AS position
+			FROM rejected_readings
+		) ranked WHERE position > 1)`)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if migrator.HasIndex(&RejectedReading{}, "idx_rejected_readings_device_time") {
+		if err := migrator.DropIndex(&RejectedReading{}, "idx_rejected_readings_device_time"); err != nil {
+			return result.RowsAffected, err
+		}
+	}
+	return result.RowsAffected, db.AutoMigrate(&RejectedReading{})
+}
+
+// DeleteDeviceQualityData removes the rejected readings and plausibility overrides of a device.
+func DeleteDeviceQualityData(db *gorm.DB, deviceID uuid.UUID) error {
+	if err := db.Unscoped().Where("device_id = ?", deviceID).Delete(&RejectedReading{}).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("device_id = ?", deviceID).Delete(&DevicePlausibilityRange{}).Error
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"time"
+)
+
+const (
+	RollupHourly = "hour"
+	RollupDaily  = "day"
+)
+
+// RollupResolutions maps every maintained rollup resolution to its bucket width. Buckets are aligned to UTC.
+var RollupResolutions = map[string]time.Duration{
+	RollupHourly: time.Hour,
+	RollupDaily:  24 * time.Hour,
+}
+
+// ReadingRollup summarises one metric of a device over an hourly or daily bucket.
+type ReadingRollup struct {
+	gorm.Model
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	DeviceID    uuid.UUID `gorm:"type:uuid;column:device_id;uniqueIndex:idx_reading_rollups_key,priority:1"`
+	Resolution  string    `gorm:"uniqueIndex:idx_reading_rollups_key,priority:2"`
+	Metric      string    `gorm:"uniqueIndex:idx_reading_rollups_key,priority:3"`
+	BucketStart time.Time `gorm:"uniqueIndex:idx_reading_rollups_key,priority:4"`
+	Count       int64
+	MinValue    float64
+	MaxValue    float64
+	SumValue    float64
+	LastValue   float64
+	LastAt      time.Time
+}
+
+type rollupKey struct {
+	deviceID    uuid.UUID
+	resolution  string
+	metric      string
+	bucketStart time.Time
+}
+
+func RollupBucketStart(resolution string, t time.Time) time.Time {
+	return BucketStart(t, RollupResolutions[resolution])
+}
+
+// BucketStart returns the start of the bucket of length bucket holding t, with buckets aligned to the
+// unix epoch. time.Truncate aligns to Go's zero time instead, which differs for buckets that do not
+// divide a day, such as weeks.
+func BucketStart(t time.Time, bucket time.Duration) time.Time {
+	unix := t.UnixNano()
+	offset := unix % int64(bucket)
+	if offset < 0 {
+		offset += int64(bucket)
+	}
+	return time.Unix(0, unix-offset).UTC()
+}
+
+// accumulateRollup folds a reading into the rollups held in acc. The result does not depend on
+// the order readings are folded in, which keeps late and out-of-order readings correct.
+func accumulateRollup(acc map[rollupKey]*ReadingRollup, reading *DeviceReading) {
+	for resolution := range RollupResolutions {
+		bucketStart := RollupBucketStart(resolution, reading.TimeStamp)
+		for _, metric := range reading.MetricNames() {
+			value, ok := reading.MetricValue(metric)
+			if !ok {
+				continue
+			}
+			key := rollupKey{reading.DeviceID, resolution, metric, bucketStart}
+			rollup, exists := acc[key]
+			if !exists {
+				acc[key] = &ReadingRollup{
+					DeviceID:    reading.DeviceID,
+					Resolution:  resolution,
+					Metric:      metric,
+					BucketStart: bucketStart,
+					Count:       1,
+					MinValue:    value,
+					MaxValue:    value,
+					SumValue:    value,
+					LastValue:   value,
+					LastAt:      reading.TimeStamp,
+				}
+				continue
+			}
+			rollup.Count++
+			rollup.SumValue += value
+			if value < rollup.MinValue {
+				rollup.MinValue = value
+			}
+			if value > rollup.MaxValue {
+				rollup.MaxValue = value
+			}
+			if !reading.TimeStamp.Before(rollup.LastAt) {
+				rollup.LastValue = value
+				rollup.LastAt = reading.TimeStamp
+			}
+		}
+	}
+}
+
+// sortedRollups returns the accumulated rollups in key order, so concurrent upserts always
+// lock rollup rows in the same order and cannot deadlock each other.
+func sortedRollups(acc map[rollupKey]*ReadingRollup) []ReadingRollup {
+	rollups := make([]ReadingRollup, 0, len(acc))
+	for _, rollup := range acc {
+		rollup.ID = uuid.New()
+		rollups = append(rollups, *rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID.String() < b.DeviceID.String()
+		}
+		if a.Resolution != b.Resolution {
+			return a.Resolution < b.Resolution
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.BucketStart.Before(b.BucketStart)
+	})
+	return rollups
+}
+
+// UpsertReadingRollups merges readings into the stored rollups. Counts and sums are added, min and max
+// are widened and the last value only moves forward in time, so a late reading never overwrites a newer one.
+func UpsertReadingRollups(db *gorm.DB, readings []DeviceReading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	acc := make(map[rollupKey]*ReadingRollup)
+	for i := range readings {
+		accumulateRollup(acc, &readings[i])
+	}
+	rollups := sortedRollups(acc)
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "device_id"}, {Name: "resolution"}, {Name: "metric"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":      gorm.Expr("reading_rollups.count + excluded.count"),
+			"sum_value":  gorm.Expr("reading_rollups.sum_value + excluded.sum_value"),
+			"min_value":  gorm.Expr("LEAST(reading_rollups.min_value, excluded.min_value)"),
+			"max_value":  gorm.Expr("GREATEST(reading_rollups.max_value, excluded.max_value)"),
+			"last_value": gorm.Expr("CASE WHEN excluded.last_at >= reading_rollups.last_at THEN excluded.last_value ELSE reading_rollups.last_value END"),
+			"last_at":    gorm.Expr("GREATEST(reading_rollups.last_at, excluded.last_at)"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).CreateInBatches(rollups, 500).Error
+}
+
+// RebuildDeviceRollups recomputes the rollups of a device from its raw readings with time_stamp >= from.
+// Rollups before from are left untouched, since their raw readings may already be gone to retention.
+// The device row is locked for the rebuild so ingest for the device waits instead of racing it.
+func RebuildDeviceRollups(db *gorm.DB, deviceID uuid.UUID, from time.Time) (int, error) {
+	var count int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&device, "id = ?", deviceID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("device_id = ? AND bucket_start >= ?", deviceID, from).Delete(&ReadingRollup{}).Error; err != nil {
+			return err
+		}
+
+		acc := make(map[rollupKey]*ReadingRollup)
+		var batch []DeviceReading
+		result := tx.Where("device_id = ? AND time_stamp >= ?", deviceID, from).
+			FindInBatches(&batch, 5000, func(_ *gorm.DB, _ int) error {
+				for i := range batch {
+					accumulateRollup(acc, &batch[i])
+				}
+				return nil
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		rollups := sortedRollups(acc)
+		count = len(rollups)
+		if count == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rollups, 500).Error
+	})
+	return count, err
+}
+
+// GetRollupsForDevices returns rollups of a resolution with start <= bucket_start < end, oldest first.
+func GetRollupsForDevices(db *gorm.DB, deviceIDs []uuid.UUID, resolution string, metrics []string, start time.Time, end time.Time) ([]ReadingRollup, error) {
+	var rollups []ReadingRollup
+	if len(deviceIDs) == 0 {
+		return rollups, nil
+	}
+	err := db.Where("device_id IN ? AND resolution = ? AND metric IN ? AND bucket_start >= ? AND bucket_start < ?",
+		deviceIDs, resolution, metrics, start, end).
+		Order("bucket_start ASC").
+		Find(&rollups).Error
+	if err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// DeleteRollupsBefore removes rollups of one resolution of a device whose bucket starts before cutoff, in
+// batches of batchSize rows like DeleteReadingsBefore.
+func DeleteRollupsBefore(db *gorm.DB, deviceID uuid.UUID, resolution string, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		result := db.Exec(`DELETE FROM reading_rollups WHERE id IN (
+			SELECT id FROM reading_rollups WHERE device_id = ? AND resolution = ? AND bucket_start < ? LIMIT ?)`,
+			deviceID, resolution, cutoff, batchSize)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func DeleteDeviceRollups(db *gorm.DB, deviceID uuid.UUID) error {
+	return db.Unscoped().Where("device_id = ?", deviceID).Delete(&ReadingRollup{}).Error
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStartAlignsToUnixEpoch(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	at := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	// The unix epoch fell on a Thursday, so weekly buckets start on Thursdays.
+	if start := BucketStart(at, week); !start.Equal(time.Date(2024, 4, 25, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("weekly bucket starts at %s", start)
+	}
+	if start := BucketStart(at, 90*time.Minute); start.Unix()%5400 != 0 || at.Sub(start) >= 90*time.Minute {
+		t.Fatalf("90 minute bucket starts at %s", start)
+	}
+	before := time.Date(1969, 12, 31, 23, 0, 0, 0, time.UTC)
+	if start := BucketStart(before, 24*time.Hour); !start.Equal(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("daily bucket before the epoch starts at %s", start)
+	}
+	if start := RollupBucketStart(RollupHourly, at); !start.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("hourly rollup starts at %s", start)
+	}
+}
+
+func TestDeleteRollupsBeforeKeepsOtherResolutions(t *testing.T) {
+	db := openTestDB(t)
+	owner := createTestUser(t, db, "owner")
+	device := createTestDevice(t, db, owner)
+
+	at := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		reading := DeviceReading{DeviceID: device.ID, TimeStamp: at.Add(time.Duration(i) * time.Hour), PhLevel: 7}
+		if err := AppendDeviceReading(db, &reading); err != nil {
+			t.Fatal("Failed to append reading:", err)
+		}
+	}
+
+	deleted, err := DeleteRollupsBefore(db, device.ID, RollupHourly, at.Add(24*time.Hour), 2)
+	if err != nil {
+		t.Fatal("Failed to delete rollups:", err)
+	}
+	var hourly, daily int64
+	db.Model(&ReadingRollup{}).Where("device_id = ? AND resolution = ?", device.ID, RollupHourly).Count(&hourly)
+	db.Model(&ReadingRollup{}).Where("device_id = ? AND resolution = ?", device.ID, RollupDaily).Count(&daily)
+	if deleted == 0 || hourly != 0 {
+		t.Fatalf("deleted %d hourly rollups, %d left", deleted, hourly)
+	}
+	if daily == 0 {
+		t.Fatal("deleting hourly rollups removed the daily ones")
+	}
+}
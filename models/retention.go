@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	RetentionKindRaw          = "raw"
+	RetentionKindHourlyRollup = "hourly_rollup"
+	RetentionKindDailyRollup  = "daily_rollup"
+)
+
+// RetentionPolicy decides how long data of a user is kept. A policy without GroupID is the account
+// default; a group policy overrides it for devices in that group. Zero days means keep forever.
+type RetentionPolicy struct {
+	gorm.Model
+	ID                        uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UmkmDataId                uuid.UUID  `gorm:"column:umkm_data_id;index"`
+	GroupID                   *uuid.UUID `gorm:"type:uuid;column:group_id"`
+	RawRetentionDays          int
+	HourlyRollupRetentionDays int
+	DailyRollupRetentionDays  int
+}
+
+type RetentionAuditLog struct {
+	gorm.Model
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UmkmDataId  uuid.UUID `gorm:"column:umkm_data_id;index"`
+	PolicyID    uuid.UUID `gorm:"type:uuid;column:policy_id"`
+	DeviceID    uuid.UUID `gorm:"type:uuid;column:device_id"`
+	Kind        string
+	Cutoff      time.Time
+	DeletedRows int64
+	RanAt       time.Time
+}
+
+func GetUserRetentionPolicies(db *gorm.DB, userID uuid.UUID) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	if err := db.Where("umkm_data_id = ?", userID).Order("created_at ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func GetRetentionPolicyById(db *gorm.DB, policyID uuid.UUID) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	if err := db.Where("id = ?", policyID).First(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func GetUserRetentionPolicy(db *gorm.DB, userID uuid.UUID, groupID *uuid.UUID) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	query := db.Where("umkm_data_id = ?", userID)
+	if groupID != nil {
+		query = query.Where("group_id = ?", *groupID)
+	} else {
+		query = query.Where("group_id IS NULL")
+	}
+	if err := query.First(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// GetDeviceRetentionPolicy returns the group policy of the device when there is one, otherwise the account default.
+func GetDeviceRetentionPolicy(db *gorm.DB, device *Device) (*RetentionPolicy, error) {
+	if device.UmkmDataId == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if device.GroupID != nil {
+		policy, err := GetUserRetentionPolicy(db, *device.UmkmDataId, device.GroupID)
+		if err == nil {
+			return policy, nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return GetUserRetentionPolicy(db, *device.UmkmDataId, nil)
+}
+
+func SaveRetentionPolicy(db *gorm.DB, policy *RetentionPolicy) error {
+	if policy.ID == uuid.Nil {
+		policy.ID = uuid.New()
+	}
+	return db.Save(policy).Error
+}
+
+// DeleteReadingsBefore removes raw readings of a device older than cutoff, batchSize rows per statement,
+// so the job never holds long locks on the readings table.
+func DeleteReadingsBefore(db *gorm.DB, deviceID uuid.UUID, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		result := db.Exec(`DELETE FROM device_readings WHERE id IN (
+			SELECT id FROM device_readings WHERE device_id = ? AND time_stamp < ? LIMIT ?)`,
+			deviceID, cutoff, batchSize)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func CreateRetentionAuditLog(db *gorm.DB, entry *RetentionAuditLog) error {
+	if entry.ID == uuid.Nil {
+		entry.ID = uuid.New()
+	}
+	return db.Create(entry).Error
+}
+
+func GetUserRetentionAuditLogs(db *gorm.DB, userID uuid.UUID, limit int) ([]RetentionAuditLog, error) {
+	var entries []RetentionAuditLog
+	err := db.Where("umkm_data_id = ?", userID).Order("ran_at DESC").Limit(limit).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func GetRegisteredDevices(db *gorm.DB) ([]Device, error) {
+	var devices []Device
+	err := db.Select("id, name, group_name, group_id, umkm_data_id").
+		Where("umkm_data_id IS NOT NULL").
+		Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
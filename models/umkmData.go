@@ -5,6 +5,7 @@ import (
 	"gin-crud/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"net/http"
 	"strings"
 	"time"
@@ -13,20 +14,22 @@ import (
 type UmkmData struct {
 	ID uuid.UUID `gorm:"type:uuid;primary_key"`
 	gorm.Model
-	Name           string      `json:"name"`
-	Email          string      `json:"email" gorm:"uniqueIndex"`
-	Gender         string      `json:"gender"`
-	Phone          string      `json:"phone" gorm:"uniqueIndex"`
-	Dob            time.Time   `json:"birth_date"`
-	Address        string      `json:"address"`
-	City           string      `json:"city"`
-	Province       string      `json:"province"`
-	BusinessName   string      `json:"business_name"`
-	BusinessDesc   string      `json:"business_desc"`
-	SystemDataID   *uuid.UUID  `gorm:"column:system_data_id;uniqueIndex"`
-	SystemData     *SystemData `gorm:"foreignKey:SystemDataID;constraint:OnDelete:CASCADE;"`
-	Devices        *[]Device
-	DeviceGrouping *[]DeviceGrouping
+	Name         string      `json:"name"`
+	Email        string      `json:"email" gorm:"uniqueIndex"`
+	Gender       string      `json:"gender"`
+	Phone        string      `json:"phone" gorm:"uniqueIndex"`
+	Dob          time.Time   `json:"birth_date"`
+	Address      string      `json:"address"`
+	City         string      `json:"city"`
+	Province     string      `json:"province"`
+	BusinessName string      `json:"business_name"`
+	BusinessDesc string      `json:"business_desc"`
+	SystemDataID *uuid.UUID  `gorm:"column:system_data_id;uniqueIndex"`
+	SystemData   *SystemData `gorm:"foreignKey:SystemDataID;constraint:OnDelete:CASCADE;"`
+	// ActiveOrganizationID is the organization new devices and groups are created in.
+	ActiveOrganizationID *uuid.UUID `gorm:"type:uuid;column:active_organization_id" json:"active_organization_id"`
+	Devices              *[]Device
+	DeviceGrouping       *[]DeviceGrouping
 }
 
 func DeleteDeviceById(db *gorm.DB, userID uuid.UUID, deviceID uuid.UUID) error {
@@ -68,47 +71,82 @@ func DeleteDeviceById(db *gorm.DB, userID uuid.UUID, deviceID uuid.UUID) error {
 	}
 	device.IsActivated = false
 	device.UmkmDataId = nil
+	device.OrganizationID = nil
 	device.Name = ""
 
-	if err := db.Save(&device).Error; err != nil {
-		return err
-	}
-	return db.Save(&user).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&device).Error; err != nil {
+			return err
+		}
+		if err := DeleteDeviceReadings(tx, device.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceRollups(tx, device.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceQualityData(tx, device.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceCalibrations(tx, device.ID); err != nil {
+			return err
+		}
+		if err := DeleteDeviceShares(tx, device.ID); err != nil {
+			return err
+		}
+		return tx.Save(&user).Error
+	})
 }
 
-func RegisterDeviceById(db *gorm.DB, userID uuid.UUID, deviceID uuid.UUID, deviceName string, groupId uuid.UUID) (error, string, int) {
+// RegisterDeviceByClaimCode adds the device holding code to the group. The device joins the group's
+// owner and organization. The device row is locked while it is claimed, so a code can only ever be used once.
+func RegisterDeviceByClaimCode(db *gorm.DB, group *DeviceGrouping, code string, deviceName string) (*Device, error, string, int) {
 	var device Device
-	if err := db.First(&device, "id = ? AND umkm_data_id IS NULL", deviceID).Error; err != nil {
-		return err, "Cannot find device", http.StatusBadRequest
-	}
+	var message string
+	status := http.StatusOK
 
-	var user *UmkmData
-	if err := db.Preload("Devices").First(&user, "id = ?", userID).Error; err != nil {
-		return err, "Cannot find user", http.StatusInternalServerError
-	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&device, "claim_code = ? AND umkm_data_id IS NULL", code).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			message, status = "Invalid or already used claim code", http.StatusNotFound
+			return utils.ErrInvalidClaimCode
+		} else if err != nil {
+			message, status = "Cannot find device", http.StatusInternalServerError
+			return err
+		}
 
-	for _, dev := range *user.Devices {
-		if dev.ID == deviceID {
-			return utils.ErrDeviceAlreadyRegistered, "Device already registered", http.StatusBadRequest
+		if device.ClaimCodeExpiresAt != nil && time.Now().After(*device.ClaimCodeExpiresAt) {
+			message, status = "Claim code has expired", http.StatusGone
+			return utils.ErrClaimCodeExpired
 		}
-	}
-	device.Name = deviceName
-	device.IsActivated = true
-	device.UmkmDataId = &user.ID
-	if err := db.Save(&device).Error; err != nil {
-		return err, "Failed to save device", http.StatusInternalServerError
-	}
 
-	if user.Devices == nil {
-		user.Devices = &[]Device{}
-	}
-	*user.Devices = append(*user.Devices, device)
+		var user UmkmData
+		if err := tx.First(&user, "id = ?", group.UmkmDataId).Error; err != nil {
+			message, status = "Cannot find user", http.StatusInternalServerError
+			return err
+		}
 
-	err, message, status := AssignDeviceToGroup(db, deviceID, userID, groupId)
+		device.Name = deviceName
+		device.IsActivated = true
+		device.UmkmDataId = &user.ID
+		device.OrganizationID = group.OrganizationID
+		device.ClaimCode = nil
+		device.ClaimCodeExpiresAt = nil
+		if err := tx.Save(&device).Error; err != nil {
+			message, status = "Failed to save device", http.StatusInternalServerError
+			return err
+		}
+
+		err, message, status = AssignDeviceToGroup(tx, device.ID, user.ID, group.ID)
+		if err != nil {
+			return err
+		}
+		return tx.First(&device, "id = ?", device.ID).Error
+	})
 	if err != nil {
-		return err, message, status
+		return nil, err, message, status
 	}
-	return db.Save(&user).Error, "Successfully adding device", http.StatusOK
+	return &device, nil, "Successfully adding device", http.StatusOK
 }
 
 func UpdateDeviceName(db *gorm.DB, userID uuid.UUID, deviceID uuid.UUID, newName string) error {
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+const (
+	WebhookEventReadingReceived = "reading.received"
+	WebhookEventAlertFired      = "alert.fired"
+	WebhookEventAlertResolved   = "alert.resolved"
+	WebhookEventDeviceOffline   = "device.offline"
+	WebhookEventDeviceOnline    = "device.online"
+)
+
+// A delivery row is one attempt. It waits as pending until NextAttemptAt, is sending while a worker posts
+// it, and ends as succeeded or failed; a failed attempt that can be retried is followed by a new pending row.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySending   = "sending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+var WebhookEvents = []string{
+	WebhookEventReadingReceived,
+	WebhookEventAlertFired,
+	WebhookEventAlertResolved,
+	WebhookEventDeviceOffline,
+	WebhookEventDeviceOnline,
+}
+
+type Webhook struct {
+	gorm.Model
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UmkmDataId uuid.UUID  `gorm:"column:umkm_data_id;index"`
+	GroupID    *uuid.UUID `gorm:"type:uuid;column:group_id"`
+	URL        string
+	Secret     string `json:"-"`
+	Events     string
+	IsEnabled  bool
+	// ConsecutiveFailures counts deliveries that failed every attempt since the last success.
+	ConsecutiveFailures int
+}
+
+type WebhookDelivery struct {
+	gorm.Model
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	WebhookID     uuid.UUID `gorm:"type:uuid;column:webhook_id;index"`
+	Event         string
+	Payload       string
+	Attempt       int
+	StatusCode    int
+	Error         string
+	Succeeded     bool
+	DeliveredAt   time.Time
+	Status        string     `gorm:"index"`
+	NextAttemptAt *time.Time `gorm:"index"`
+}
+
+func (webhook *Webhook) EventList() []string {
+	if webhook.Events == "" {
+		return nil
+	}
+	return strings.Split(webhook.Events, ",")
+}
+
+func (webhook *Webhook) Subscribes(event string) bool {
+	for _, subscribed := range webhook.EventList() {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordOutcome counts a finished delivery and reports whether the webhook was disabled because the last
+// disableAfter deliveries all failed.
+func (webhook *Webhook) RecordOutcome(succeeded bool, disableAfter int) bool {
+	if succeeded {
+		webhook.ConsecutiveFailures = 0
+		return false
+	}
+	webhook.ConsecutiveFailures++
+	if webhook.IsEnabled && webhook.ConsecutiveFailures >= disableAfter {
+		webhook.IsEnabled = false
+		return true
+	}
+	return false
+}
+
+func CreateWebhook(db *gorm.DB, webhook *Webhook) error {
+	if webhook.ID == uuid.Nil {
+		webhook.ID = uuid.New()
+	}
+	return db.Create(webhook).Error
+}
+
+func GetWebhookById(db *gorm.DB, webhookID uuid.UUID) (*Webhook, error) {
+	var webhook Webhook
+	if err := db.Where("id = ?", webhookID).First(&webhook).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func GetUserWebhook(db *gorm.DB, userID uuid.UUID, webhookID uuid.UUID) (*Webhook, error) {
+	var webhook Webhook
+	if err := db.Where("id = ? AND umkm_data_id = ?", webhookID, userID).First(&webhook).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func GetUserWebhooks(db *gorm.DB, userID uuid.UUID) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := db.Where("umkm_data_id = ?", userID).Order("created_at ASC").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetDeviceWebhooks returns the enabled webhooks of the device owner that cover the whole account, and the
+// enabled webhooks of any user on the device's group.
+func GetDeviceWebhooks(db *gorm.DB, device *Device) ([]Webhook, error) {
+	var webhooks []Webhook
+	if device.UmkmDataId == nil {
+		return webhooks, nil
+	}
+
+	query := db.Where("is_enabled = ?", true)
+	if device.GroupID != nil {
+		query = query.Where("(umkm_data_id = ? AND group_id IS NULL) OR group_id = ?", *device.UmkmDataId, *device.GroupID)
+	} else {
+		query = query.Where("umkm_data_id = ? AND group_id IS NULL", *device.UmkmDataId)
+	}
+	if err := query.Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func DeleteWebhook(db *gorm.DB, webhook *Webhook) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("webhook_id = ?", webhook.ID).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(webhook).Error
+	})
+}
+
+func CreateWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	if delivery.ID == uuid.Nil {
+		delivery.ID = uuid.New()
+	}
+	return db.Create(delivery).Error
+}
+
+func GetWebhookDeliveries(db *gorm.DB, webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("webhook_id = ?", webhookID).Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery moves a due pending delivery to sending, so only one worker posts it. It returns
+// gorm.ErrRecordNotFound when the delivery is not due or another worker already claimed it.
+func ClaimWebhookDelivery(db *gorm.DB, deliveryID uuid.UUID, now time.Time) (*WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Model(&deliveries).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", deliveryID, WebhookDeliveryPending, now).
+		Update("status", WebhookDeliverySending).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &deliveries[0], nil
+}
+
+// FinishWebhookDelivery stores the result of an attempt, schedules next when the attempt is retried and,
+// once a delivery has no attempts left, counts it towards disabling the webhook.
+func FinishWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery, next *WebhookDelivery, disableAfter int) (bool, error) {
+	disabled := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(delivery).Error; err != nil {
+			return err
+		}
+		if next != nil {
+			return CreateWebhookDelivery(tx, next)
+		}
+
+		var webhook Webhook
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&webhook, "id = ?", delivery.WebhookID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		disabled = webhook.RecordOutcome(delivery.Succeeded, disableAfter)
+		return tx.Model(&webhook).Updates(map[string]interface{}{
+			"consecutive_failures": webhook.ConsecutiveFailures,
+			"is_enabled":           webhook.IsEnabled,
+		}).Error
+	})
+	return disabled, err
+}
+
+// GetDueWebhookDeliveryIds returns pending deliveries whose next attempt is due, oldest first.
+func GetDueWebhookDeliveryIds(db *gorm.DB, now time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ResetStaleWebhookDeliveries puts deliveries back to pending whose worker stopped while sending them,
+// for example because the server restarted.
+func ResetStaleWebhookDeliveries(db *gorm.DB, before time.Time) error {
+	return db.Model(&WebhookDelivery{}).
+		Where("status = ? AND updated_at < ?", WebhookDeliverySending, before).
+		Update("status", WebhookDeliveryPending).Error
+}
+
+// DeleteOldWebhookDeliveries removes finished deliveries created before the given time.
+func DeleteOldWebhookDeliveries(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Unscoped().
+		Where("status IN ? AND created_at < ?", []string{WebhookDeliverySucceeded, WebhookDeliveryFailed}, before).
+		Delete(&WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/service"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readSerialNumbers reads one serial number per line. Blank lines, # comments and a serial_number header are skipped.
+func readSerialNumbers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var serials []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || (len(serials) == 0 && strings.EqualFold(line, "serial_number")) {
+			continue
+		}
+		serials = append(serials, line)
+	}
+	return serials, scanner.Err()
+}
+
+func writeCSVManifest(w io.Writer, manifest *response.ProvisioningManifest) error {
+	writer := csv.NewWriter(w)
+	header := []string{"index", "device_id", "serial_number", "secret_key", "claim_code", "claim_qr_payload", "claim_code_expires_at"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range manifest.Devices {
+		var expiresAt string
+		if row.ClaimCodeExpiresAt != nil {
+			expiresAt = row.ClaimCodeExpiresAt.UTC().Format(time.RFC3339)
+		}
+		record := []string{strconv.Itoa(row.Index), row.DeviceID.String(), row.SerialNumber, row.SecretKey, row.ClaimCode, row.ClaimQRPayload, expiresAt}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONManifest(w io.Writer, manifest *response.ProvisioningManifest) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+func main() {
+	batch := flag.String("batch", "", "batch name; re-running with the same name rewrites the manifest without creating devices")
+	count := flag.Int("count", 0, "number of devices to create")
+	input := flag.String("input", "", "file with one serial number per line, creates one device per serial number")
+	output := flag.String("output", "", "manifest path (default <batch>-manifest.<format>)")
+	format := flag.String("format", "", "manifest format, csv or json (default from the output extension, else csv)")
+	flag.Parse()
+
+	if *batch == "" {
+		log.Fatal("-batch is required")
+	}
+	if (*count > 0) == (*input != "") {
+		log.Fatal("Use either -count or -input")
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if strings.EqualFold(filepath.Ext(*output), ".json") {
+			*format = "json"
+		}
+	}
+	if *format != "csv" && *format != "json" {
+		log.Fatalf("Unsupported manifest format %s", *format)
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("%s-manifest.%s", *batch, *format)
+	}
+
+	var serials []string
+	if *input != "" {
+		var err error
+		if serials, err = readSerialNumbers(*input); err != nil {
+			log.Fatalf("Failed reading %s: %v", *input, err)
+		}
+		if len(serials) == 0 {
+			log.Fatalf("%s has no serial numbers", *input)
+		}
+	}
+
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+	if err := initializers.DB.AutoMigrate(&models.ProvisioningBatch{}, &models.Device{}); err != nil {
+		log.Fatalf("Failed migrating provisioning tables: %v", err)
+	}
+
+	manifest, created, err := service.ProvisionDevices(*batch, *count, serials)
+	if err != nil {
+		log.Fatalf("Failed provisioning batch %s: %v", *batch, err)
+	}
+
+	// The manifest holds device secrets, so only the current user may read it.
+	file, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Failed creating manifest: %v", err)
+	}
+	if *format == "json" {
+		err = writeJSONManifest(file, manifest)
+	} else {
+		err = writeCSVManifest(file, manifest)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Failed writing manifest: %v", err)
+	}
+
+	if created {
+		log.Printf("Provisioned %d devices in batch %s, manifest written to %s\n", len(manifest.Devices), *batch, *output)
+	} else {
+		log.Printf("Batch %s already has %d devices, manifest written to %s\n", *batch, len(manifest.Devices), *output)
+	}
+}
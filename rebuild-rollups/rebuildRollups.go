@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/service"
+	"github.com/google/uuid"
+	"log"
+)
+
+func main() {
+	deviceFlag := flag.String("device", "", "rebuild only the rollups of this device id")
+	flag.Parse()
+
+	initializers.LoadEnvVariables()
+	initializers.DatabaseInit()
+
+	if err := initializers.DB.AutoMigrate(&models.ReadingRollup{}); err != nil {
+		log.Fatalf("Error migrating reading rollups table: %v", err)
+	}
+
+	var deviceID *uuid.UUID
+	if *deviceFlag != "" {
+		id, err := uuid.Parse(*deviceFlag)
+		if err != nil {
+			log.Fatalf("Invalid device id %s: %v", *deviceFlag, err)
+		}
+		deviceID = &id
+	}
+
+	if err := service.RebuildReadingRollups(deviceID); err != nil {
+		log.Fatalf("Failed rebuilding rollups: %v", err)
+	}
+
+	log.Println("Rollup rebuild completed successfully")
+}
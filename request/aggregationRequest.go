@@ -0,0 +1,10 @@
+package request
+
+type AggregationRequest struct {
+	DeviceID    string    `json:"device_id"`
+	Start       string    `json:"start"`
+	End         string    `json:"end"`
+	Bucket      string    `json:"bucket"`
+	Metrics     []string  `json:"metrics"`
+	Percentiles []float64 `json:"percentiles"`
+}
@@ -0,0 +1,12 @@
+package request
+
+type AlertMailRequest struct {
+	Name       string
+	Status     string
+	DeviceName string
+	GroupName  string
+	Metric     string
+	Value      string
+	Condition  string
+	OccurredAt string
+}
@@ -0,0 +1,17 @@
+package request
+
+type AlertRuleRequest struct {
+	Name       string  `json:"name"`
+	Expression string  `json:"expression"`
+	DeviceID   string  `json:"device_id"`
+	GroupID    string  `json:"group_id"`
+	Hysteresis float64 `json:"hysteresis"`
+	IsEnabled  *bool   `json:"is_enabled"`
+}
+
+type AlertHistoryRequest struct {
+	DeviceID string `form:"device_id"`
+	RuleID   string `form:"rule_id"`
+	Start    string `form:"start"`
+	End      string `form:"end"`
+}
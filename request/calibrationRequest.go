@@ -0,0 +1,9 @@
+package request
+
+type CalibrationRequest struct {
+	Metric        string   `json:"metric"`
+	Offset        float64  `json:"offset"`
+	Slope         *float64 `json:"slope"`
+	EffectiveFrom string   `json:"effective_from"`
+	Note          string   `json:"note"`
+}
@@ -5,11 +5,15 @@ import (
 )
 
 type CSVData struct {
-	OxygenLevel float32       `uri:"oxygen_level"`
-	WaterTemp   float32       `uri:"water_temp"`
-	EcLevel     float32       `uri:"ec_level"`
-	PhLevel     float32       `uri:"ph_level"`
-	TimeStamp   time.Time     `uri:"time_stamp" binding:"required"`
-	ID          string        `uri:"id" binding:"required,uuid"`
-	Interval    time.Duration `json:"interval"`
+	OxygenLevel float32            `uri:"oxygen_level" json:"oxygen_level"`
+	WaterTemp   float32            `uri:"water_temp" json:"water_temp"`
+	EcLevel     float32            `uri:"ec_level" json:"ec_level"`
+	PhLevel     float32            `uri:"ph_level" json:"ph_level"`
+	TimeStamp   time.Time          `uri:"time_stamp" json:"time_stamp" binding:"required"`
+	ID          string             `uri:"id" json:"id" binding:"required,uuid"`
+	MessageID   string             `json:"message_id" binding:"max=128"`
+	Metrics     map[string]float64 `json:"metrics"`
+	Interval    time.Duration      `json:"interval"`
+	// RejectedMetrics is only set on records read back from stored readings.
+	RejectedMetrics []string `json:"-"`
 }
@@ -0,0 +1,8 @@
+package request
+
+type ExportRequest struct {
+	Start   string `form:"start"`
+	End     string `form:"end"`
+	Format  string `form:"format"`
+	Columns string `form:"columns"`
+}
@@ -0,0 +1,24 @@
+package request
+
+type MetricDefinitionRequest struct {
+	Name       string   `json:"name"`
+	Unit       string   `json:"unit"`
+	MinValue   *float64 `json:"min_value"`
+	MaxValue   *float64 `json:"max_value"`
+	SuspectMin *float64 `json:"suspect_min"`
+	SuspectMax *float64 `json:"suspect_max"`
+	Precision  *int     `json:"precision"`
+}
+
+type PlausibilityRangeRequest struct {
+	Metric     string   `json:"metric"`
+	MinValue   *float64 `json:"min_value"`
+	MaxValue   *float64 `json:"max_value"`
+	SuspectMin *float64 `json:"suspect_min"`
+	SuspectMax *float64 `json:"suspect_max"`
+}
+
+type RejectedReadingRequest struct {
+	Start string `form:"start"`
+	End   string `form:"end"`
+}
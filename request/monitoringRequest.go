@@ -4,4 +4,8 @@ type MonitoringRequest struct {
 	Date     string `json:"date"`
 	DeviceID string `json:"device_id"`
 	Interval string `json:"interval"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Cursor   string `json:"cursor"`
+	Limit    int    `json:"limit"`
 }
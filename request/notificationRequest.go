@@ -0,0 +1,7 @@
+package request
+
+type NotificationPreferenceRequest struct {
+	EmailEnabled       *bool `json:"email_enabled"`
+	NotifyOnResolved   *bool `json:"notify_on_resolved"`
+	MinIntervalMinutes *int  `json:"min_interval_minutes"`
+}
@@ -0,0 +1,22 @@
+package request
+
+import "github.com/google/uuid"
+
+type OrganizationRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type OrganizationMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type OrganizationInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// ActiveOrganizationRequest switches the active organization; a null organization_id leaves only personal devices.
+type ActiveOrganizationRequest struct {
+	OrganizationID *uuid.UUID `json:"organization_id"`
+}
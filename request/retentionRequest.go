@@ -0,0 +1,8 @@
+package request
+
+type RetentionPolicyRequest struct {
+	GroupID                   string `json:"group_id"`
+	RawRetentionDays          int    `json:"raw_retention_days"`
+	HourlyRollupRetentionDays int    `json:"hourly_rollup_retention_days"`
+	DailyRollupRetentionDays  int    `json:"daily_rollup_retention_days"`
+}
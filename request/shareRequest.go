@@ -0,0 +1,6 @@
+package request
+
+type ShareRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
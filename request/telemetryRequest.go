@@ -0,0 +1,11 @@
+package request
+
+import "encoding/json"
+
+// TelemetryMessage is the envelope devices publish over MQTT.
+// Data holds a single reading or an array of readings, signed together with the timestamp.
+type TelemetryMessage struct {
+	Timestamp string          `json:"timestamp"`
+	Signature string          `json:"signature"`
+	Data      json.RawMessage `json:"data"`
+}
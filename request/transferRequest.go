@@ -0,0 +1,14 @@
+package request
+
+type DeviceTransferRequest struct {
+	Email   string `json:"email"`
+	History string `json:"history"`
+	Note    string `json:"note"`
+}
+
+type ArchiveReadingsRequest struct {
+	Start  string `form:"start"`
+	End    string `form:"end"`
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit"`
+}
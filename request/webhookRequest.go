@@ -0,0 +1,9 @@
+package request
+
+type WebhookRequest struct {
+	URL          string   `json:"url"`
+	GroupID      string   `json:"group_id"`
+	Events       []string `json:"events"`
+	IsEnabled    *bool    `json:"is_enabled"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
@@ -0,0 +1,17 @@
+package response
+
+import "time"
+
+type MetricStatsResponse struct {
+	Count       int                `json:"count"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	Last        float64            `json:"last"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+type AggregationBucketResponse struct {
+	BucketStart time.Time                      `json:"bucket_start"`
+	Metrics     map[string]MetricStatsResponse `json:"metrics"`
+}
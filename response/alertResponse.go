@@ -0,0 +1,70 @@
+package response
+
+import (
+	"fmt"
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+type AlertRuleResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Expression string     `json:"expression"`
+	DeviceID   *uuid.UUID `json:"device_id"`
+	GroupID    *uuid.UUID `json:"group_id"`
+	Metric     string     `json:"metric"`
+	Operator   string     `json:"operator"`
+	Threshold  float64    `json:"threshold"`
+	Duration   string     `json:"duration"`
+	Hysteresis float64    `json:"hysteresis"`
+	IsEnabled  bool       `json:"is_enabled"`
+}
+
+func BindAlertRuleToResponse(rule *models.AlertRule) AlertRuleResponse {
+	duration := (time.Duration(rule.DurationSeconds) * time.Second).String()
+	expression := fmt.Sprintf("%s %s %s", rule.Metric, rule.Operator, strconv.FormatFloat(rule.Threshold, 'f', -1, 64))
+	if rule.DurationSeconds > 0 {
+		expression += " for " + duration
+	}
+	return AlertRuleResponse{
+		ID:         rule.ID,
+		Name:       rule.Name,
+		Expression: expression,
+		DeviceID:   rule.DeviceID,
+		GroupID:    rule.GroupID,
+		Metric:     rule.Metric,
+		Operator:   rule.Operator,
+		Threshold:  rule.Threshold,
+		Duration:   duration,
+		Hysteresis: rule.Hysteresis,
+		IsEnabled:  rule.IsEnabled,
+	}
+}
+
+type AlertEventResponse struct {
+	ID         uuid.UUID          `json:"id"`
+	RuleID     *uuid.UUID         `json:"rule_id"`
+	DeviceID   uuid.UUID          `json:"device_id"`
+	Status     models.AlertStatus `json:"status"`
+	Metric     string             `json:"metric"`
+	Value      float64            `json:"value"`
+	Threshold  float64            `json:"threshold"`
+	Message    string             `json:"message"`
+	OccurredAt time.Time          `json:"occurred_at"`
+}
+
+func BindAlertEventToResponse(event *models.AlertEvent) AlertEventResponse {
+	return AlertEventResponse{
+		ID:         event.ID,
+		RuleID:     event.RuleID,
+		DeviceID:   event.DeviceID,
+		Status:     event.Status,
+		Metric:     event.Metric,
+		Value:      event.Value,
+		Threshold:  event.Threshold,
+		Message:    event.Message,
+		OccurredAt: event.OccurredAt,
+	}
+}
@@ -0,0 +1,35 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type CalibrationResponse struct {
+	ID             uuid.UUID `json:"id"`
+	DeviceID       uuid.UUID `json:"device_id"`
+	Metric         string    `json:"metric"`
+	Offset         float64   `json:"offset"`
+	Slope          float64   `json:"slope"`
+	EffectiveFrom  time.Time `json:"effective_from"`
+	CalibratedByID uuid.UUID `json:"calibrated_by_id"`
+	CalibratedBy   string    `json:"calibrated_by"`
+	Note           string    `json:"note"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func BindCalibrationToResponse(calibration *models.Calibration) CalibrationResponse {
+	return CalibrationResponse{
+		ID:             calibration.ID,
+		DeviceID:       calibration.DeviceID,
+		Metric:         calibration.Metric,
+		Offset:         calibration.Offset,
+		Slope:          calibration.Slope,
+		EffectiveFrom:  calibration.EffectiveFrom,
+		CalibratedByID: calibration.CalibratedByID,
+		CalibratedBy:   calibration.CalibratedBy,
+		Note:           calibration.Note,
+		UpdatedAt:      calibration.UpdatedAt,
+	}
+}
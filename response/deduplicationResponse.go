@@ -0,0 +1,18 @@
+package response
+
+import "github.com/google/uuid"
+
+type DeviceDeduplicationResponse struct {
+	DeviceID          uuid.UUID `json:"device_id"`
+	Duplicates        int64     `json:"duplicates"`
+	MessageDuplicates int64     `json:"message_duplicates"`
+	Deleted           int64     `json:"deleted"`
+}
+
+type DeduplicationResponse struct {
+	DryRun            bool                          `json:"dry_run"`
+	Duplicates        int64                         `json:"duplicates"`
+	MessageDuplicates int64                         `json:"message_duplicates"`
+	Deleted           int64                         `json:"deleted"`
+	Devices           []DeviceDeduplicationResponse `json:"devices"`
+}
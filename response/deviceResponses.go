@@ -3,6 +3,7 @@ package response
 import (
 	"gin-crud/models"
 	"github.com/google/uuid"
+	"time"
 )
 
 type DeviceResponse struct {
@@ -12,14 +13,25 @@ type DeviceResponse struct {
 	GroupName   *string    `json:"group_name"`
 	GroupID     *uuid.UUID `json:"group_id"`
 	UmkmDataId  *uuid.UUID `json:"umkm_data_id,omitempty"`
+	Status      string     `json:"status"`
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+	Role        string     `json:"role,omitempty"`
 }
 
 func BindDeviceToResponse(device *models.Device) DeviceResponse {
+	status := "offline"
+	if device.IsOnline {
+		status = "online"
+	}
 	resp := DeviceResponse{
 		ID:          device.ID,
 		Name:        device.Name,
 		IsActivated: device.IsActivated,
+		GroupName:   device.GroupName,
+		GroupID:     device.GroupID,
 		UmkmDataId:  device.UmkmDataId,
+		Status:      status,
+		LastSeenAt:  device.LastSeenAt,
 	}
 	return resp
 }
@@ -29,3 +41,24 @@ type DeviceGroupResponse struct {
 	UmkmDataId uuid.UUID `json:"umkm-data-id"`
 	GroupName  string    `json:"group-name"`
 }
+
+type ReadingResultResponse struct {
+	Index    int    `json:"index"`
+	DeviceID string `json:"device_id"`
+	Status   int    `json:"status"`
+	Message  string `json:"message"`
+}
+
+type DeviceSecretResponse struct {
+	ID                 uuid.UUID  `json:"id"`
+	SecretKey          string     `json:"secret_key"`
+	ClaimCode          string     `json:"claim_code,omitempty"`
+	ClaimQRPayload     string     `json:"claim_qr_payload,omitempty"`
+	ClaimCodeExpiresAt *time.Time `json:"claim_code_expires_at,omitempty"`
+}
+
+type ClaimCodeResponse struct {
+	ClaimCode          string     `json:"claim_code"`
+	ClaimQRPayload     string     `json:"claim_qr_payload"`
+	ClaimCodeExpiresAt *time.Time `json:"claim_code_expires_at"`
+}
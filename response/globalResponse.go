@@ -5,8 +5,10 @@ import (
 	"time"
 )
 
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
 func GlobalResponse(c *gin.Context, message string, status int, data interface{}) {
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	timestamp := time.Now().UTC().Format(timestampLayout)
 
 	response := gin.H{
 		"status":    status,
@@ -17,3 +19,14 @@ func GlobalResponse(c *gin.Context, message string, status int, data interface{}
 
 	c.JSON(status, response)
 }
+
+// WebhookEnvelope wraps an outgoing webhook payload in the same envelope as GlobalResponse.
+func WebhookEnvelope(event string, message string, data interface{}) gin.H {
+	return gin.H{
+		"status":    200,
+		"event":     event,
+		"message":   message,
+		"timestamp": time.Now().UTC().Format(timestampLayout),
+		"data":      data,
+	}
+}
@@ -0,0 +1,21 @@
+package response
+
+const (
+	ImportRowInvalid   = "invalid"
+	ImportRowDuplicate = "duplicate"
+)
+
+// ImportRowResponse reports a CSV row that was not imported. Row is the line number in the file, the header being line 1.
+type ImportRowResponse struct {
+	Row     int    `json:"row"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type ImportResultResponse struct {
+	Total      int                 `json:"total"`
+	Imported   int                 `json:"imported"`
+	Duplicates int                 `json:"duplicates"`
+	Failed     int                 `json:"failed"`
+	Rows       []ImportRowResponse `json:"rows"`
+}
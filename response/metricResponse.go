@@ -0,0 +1,50 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+)
+
+type MetricDefinitionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Unit       string    `json:"unit"`
+	MinValue   *float64  `json:"min_value"`
+	MaxValue   *float64  `json:"max_value"`
+	SuspectMin *float64  `json:"suspect_min"`
+	SuspectMax *float64  `json:"suspect_max"`
+	Precision  *int      `json:"precision"`
+	IsBuiltin  bool      `json:"is_builtin"`
+}
+
+func BindMetricDefinitionToResponse(definition *models.MetricDefinition) MetricDefinitionResponse {
+	return MetricDefinitionResponse{
+		ID:         definition.ID,
+		Name:       definition.Name,
+		Unit:       definition.Unit,
+		MinValue:   definition.MinValue,
+		MaxValue:   definition.MaxValue,
+		SuspectMin: definition.SuspectMin,
+		SuspectMax: definition.SuspectMax,
+		Precision:  definition.Precision,
+		IsBuiltin:  definition.IsBuiltin,
+	}
+}
+
+type PlausibilityRangeResponse struct {
+	Metric     string   `json:"metric"`
+	MinValue   *float64 `json:"min_value"`
+	MaxValue   *float64 `json:"max_value"`
+	SuspectMin *float64 `json:"suspect_min"`
+	SuspectMax *float64 `json:"suspect_max"`
+}
+
+func BindPlausibilityRangeToResponse(plausibility *models.DevicePlausibilityRange) PlausibilityRangeResponse {
+	return PlausibilityRangeResponse{
+		Metric:     plausibility.Metric,
+		MinValue:   plausibility.MinValue,
+		MaxValue:   plausibility.MaxValue,
+		SuspectMin: plausibility.SuspectMin,
+		SuspectMax: plausibility.SuspectMax,
+	}
+}
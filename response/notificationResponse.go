@@ -0,0 +1,17 @@
+package response
+
+import "gin-crud/models"
+
+type NotificationPreferenceResponse struct {
+	EmailEnabled       bool `json:"email_enabled"`
+	NotifyOnResolved   bool `json:"notify_on_resolved"`
+	MinIntervalMinutes int  `json:"min_interval_minutes"`
+}
+
+func BindNotificationPreferenceToResponse(preference *models.NotificationPreference) NotificationPreferenceResponse {
+	return NotificationPreferenceResponse{
+		EmailEnabled:       preference.EmailEnabled,
+		NotifyOnResolved:   preference.NotifyOnResolved,
+		MinIntervalMinutes: preference.MinIntervalMinutes,
+	}
+}
@@ -0,0 +1,85 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type OrganizationResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Role        string    `json:"role,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type OrganizationMemberResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UmkmDataId uuid.UUID `json:"umkm_data_id"`
+	UserName   string    `json:"user_name"`
+	UserEmail  string    `json:"user_email"`
+	Role       string    `json:"role"`
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+type OrganizationInvitationResponse struct {
+	ID             uuid.UUID `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	InvitedBy      string    `json:"invited_by"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func BindOrganizationToResponse(organization *models.Organization, role string, activeID *uuid.UUID) OrganizationResponse {
+	return OrganizationResponse{
+		ID:          organization.ID,
+		Name:        organization.Name,
+		Description: organization.Description,
+		Role:        role,
+		IsActive:    activeID != nil && *activeID == organization.ID,
+		CreatedAt:   organization.CreatedAt,
+	}
+}
+
+func BindOrganizationMemberToResponse(member *models.OrganizationMember) OrganizationMemberResponse {
+	return OrganizationMemberResponse{
+		ID:         member.ID,
+		UmkmDataId: member.UmkmDataId,
+		UserName:   member.UserName,
+		UserEmail:  member.UserEmail,
+		Role:       member.Role,
+		JoinedAt:   member.CreatedAt,
+	}
+}
+
+func BindOrganizationMembersToResponse(members []models.OrganizationMember) []OrganizationMemberResponse {
+	resp := make([]OrganizationMemberResponse, 0, len(members))
+	for i := range members {
+		resp = append(resp, BindOrganizationMemberToResponse(&members[i]))
+	}
+	return resp
+}
+
+func BindOrganizationInvitationToResponse(invitation *models.OrganizationInvitation) OrganizationInvitationResponse {
+	return OrganizationInvitationResponse{
+		ID:             invitation.ID,
+		OrganizationID: invitation.OrganizationID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		InvitedBy:      invitation.InvitedBy,
+		ExpiresAt:      invitation.ExpiresAt,
+		CreatedAt:      invitation.CreatedAt,
+	}
+}
+
+func BindOrganizationInvitationsToResponse(invitations []models.OrganizationInvitation) []OrganizationInvitationResponse {
+	resp := make([]OrganizationInvitationResponse, 0, len(invitations))
+	for i := range invitations {
+		resp = append(resp, BindOrganizationInvitationToResponse(&invitations[i]))
+	}
+	return resp
+}
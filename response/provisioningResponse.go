@@ -0,0 +1,23 @@
+package response
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ProvisioningManifestRow is one line of the manifest handed to the factory for flashing firmware and printing labels.
+type ProvisioningManifestRow struct {
+	Index              int        `json:"index"`
+	DeviceID           uuid.UUID  `json:"device_id"`
+	SerialNumber       string     `json:"serial_number,omitempty"`
+	SecretKey          string     `json:"secret_key"`
+	ClaimCode          string     `json:"claim_code"`
+	ClaimQRPayload     string     `json:"claim_qr_payload"`
+	ClaimCodeExpiresAt *time.Time `json:"claim_code_expires_at"`
+}
+
+type ProvisioningManifest struct {
+	Batch     string                    `json:"batch"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Devices   []ProvisioningManifestRow `json:"devices"`
+}
@@ -0,0 +1,63 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type ReadingResponse struct {
+	DeviceID        uuid.UUID          `json:"device_id"`
+	TimeStamp       time.Time          `json:"time_stamp"`
+	OxygenLevel     float32            `json:"oxygen_level"`
+	WaterTemp       float32            `json:"water_temp"`
+	EcLevel         float32            `json:"ec_level"`
+	PhLevel         float32            `json:"ph_level"`
+	Metrics         map[string]float64 `json:"metrics,omitempty"`
+	RawValues       map[string]float64 `json:"raw_values,omitempty"`
+	Quality         string             `json:"quality"`
+	FlaggedMetrics  []string           `json:"flagged_metrics,omitempty"`
+	RejectedMetrics []string           `json:"rejected_metrics,omitempty"`
+}
+
+func BindReadingToResponse(reading *models.DeviceReading) ReadingResponse {
+	return ReadingResponse{
+		DeviceID:        reading.DeviceID,
+		TimeStamp:       reading.TimeStamp,
+		OxygenLevel:     reading.OxygenLevel,
+		WaterTemp:       reading.WaterTemp,
+		EcLevel:         reading.EcLevel,
+		PhLevel:         reading.PhLevel,
+		Metrics:         reading.Metrics,
+		RawValues:       reading.RawValues,
+		Quality:         reading.QualityOrGood(),
+		FlaggedMetrics:  reading.FlaggedMetricList(),
+		RejectedMetrics: reading.RejectedMetricList(),
+	}
+}
+
+type MonitoringResponse struct {
+	Records    []ReadingResponse `json:"records"`
+	CSV        string            `json:"csv"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+type RejectedReadingResponse struct {
+	ID         uuid.UUID          `json:"id"`
+	DeviceID   uuid.UUID          `json:"device_id"`
+	TimeStamp  time.Time          `json:"time_stamp"`
+	Values     map[string]float64 `json:"values"`
+	Reasons    []string           `json:"reasons"`
+	ReceivedAt time.Time          `json:"received_at"`
+}
+
+func BindRejectedReadingToResponse(rejected *models.RejectedReading) RejectedReadingResponse {
+	return RejectedReadingResponse{
+		ID:         rejected.ID,
+		DeviceID:   rejected.DeviceID,
+		TimeStamp:  rejected.TimeStamp,
+		Values:     rejected.Values,
+		Reasons:    rejected.ReasonList(),
+		ReceivedAt: rejected.ReceivedAt,
+	}
+}
@@ -0,0 +1,45 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type RetentionPolicyResponse struct {
+	ID                        uuid.UUID  `json:"id"`
+	GroupID                   *uuid.UUID `json:"group_id"`
+	RawRetentionDays          int        `json:"raw_retention_days"`
+	HourlyRollupRetentionDays int        `json:"hourly_rollup_retention_days"`
+	DailyRollupRetentionDays  int        `json:"daily_rollup_retention_days"`
+}
+
+func BindRetentionPolicyToResponse(policy *models.RetentionPolicy) RetentionPolicyResponse {
+	return RetentionPolicyResponse{
+		ID:                        policy.ID,
+		GroupID:                   policy.GroupID,
+		RawRetentionDays:          policy.RawRetentionDays,
+		HourlyRollupRetentionDays: policy.HourlyRollupRetentionDays,
+		DailyRollupRetentionDays:  policy.DailyRollupRetentionDays,
+	}
+}
+
+type RetentionAuditLogResponse struct {
+	PolicyID    uuid.UUID `json:"policy_id"`
+	DeviceID    uuid.UUID `json:"device_id"`
+	Kind        string    `json:"kind"`
+	Cutoff      time.Time `json:"cutoff"`
+	DeletedRows int64     `json:"deleted_rows"`
+	RanAt       time.Time `json:"ran_at"`
+}
+
+func BindRetentionAuditLogToResponse(entry *models.RetentionAuditLog) RetentionAuditLogResponse {
+	return RetentionAuditLogResponse{
+		PolicyID:    entry.PolicyID,
+		DeviceID:    entry.DeviceID,
+		Kind:        entry.Kind,
+		Cutoff:      entry.Cutoff,
+		DeletedRows: entry.DeletedRows,
+		RanAt:       entry.RanAt,
+	}
+}
@@ -0,0 +1,43 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type ShareResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	DeviceID   *uuid.UUID `json:"device_id,omitempty"`
+	GroupID    *uuid.UUID `json:"group_id,omitempty"`
+	UmkmDataId uuid.UUID  `json:"umkm_data_id"`
+	UserName   string     `json:"user_name"`
+	UserEmail  string     `json:"user_email"`
+	Role       string     `json:"role"`
+	SharedByID uuid.UUID  `json:"shared_by_id"`
+	SharedBy   string     `json:"shared_by"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func BindShareToResponse(share *models.DeviceShare) ShareResponse {
+	return ShareResponse{
+		ID:         share.ID,
+		DeviceID:   share.DeviceID,
+		GroupID:    share.GroupID,
+		UmkmDataId: share.UmkmDataId,
+		UserName:   share.UserName,
+		UserEmail:  share.UserEmail,
+		Role:       share.Role,
+		SharedByID: share.SharedByID,
+		SharedBy:   share.SharedBy,
+		UpdatedAt:  share.UpdatedAt,
+	}
+}
+
+func BindSharesToResponse(shares []models.DeviceShare) []ShareResponse {
+	resp := make([]ShareResponse, 0, len(shares))
+	for i := range shares {
+		resp = append(resp, BindShareToResponse(&shares[i]))
+	}
+	return resp
+}
@@ -0,0 +1,83 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeviceTransferResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	DeviceID      uuid.UUID  `json:"device_id"`
+	DeviceName    string     `json:"device_name"`
+	FromUserID    uuid.UUID  `json:"from_user_id"`
+	InitiatedByID uuid.UUID  `json:"initiated_by_id"`
+	FromName      string     `json:"from_name"`
+	ToUserID      uuid.UUID  `json:"to_user_id"`
+	ToName        string     `json:"to_name"`
+	ToEmail       string     `json:"to_email"`
+	History       string     `json:"history"`
+	Status        string     `json:"status"`
+	Note          string     `json:"note"`
+	ArchiveID     *uuid.UUID `json:"archive_id,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RespondedAt   *time.Time `json:"responded_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func BindDeviceTransferToResponse(transfer *models.DeviceTransfer) DeviceTransferResponse {
+	return DeviceTransferResponse{
+		ID:            transfer.ID,
+		DeviceID:      transfer.DeviceID,
+		DeviceName:    transfer.DeviceName,
+		FromUserID:    transfer.FromUserID,
+		InitiatedByID: transfer.InitiatedByID,
+		FromName:      transfer.FromName,
+		ToUserID:      transfer.ToUserID,
+		ToName:        transfer.ToName,
+		ToEmail:       transfer.ToEmail,
+		History:       transfer.History,
+		Status:        transfer.Status,
+		Note:          transfer.Note,
+		ArchiveID:     transfer.ArchiveID,
+		ExpiresAt:     transfer.ExpiresAt,
+		RespondedAt:   transfer.RespondedAt,
+		CreatedAt:     transfer.CreatedAt,
+	}
+}
+
+type DeviceTransferAuditResponse struct {
+	Action     string     `json:"action"`
+	ActorID    *uuid.UUID `json:"actor_id"`
+	ActorName  string     `json:"actor_name"`
+	Detail     string     `json:"detail"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
+
+func BindDeviceTransferAuditToResponse(entry *models.DeviceTransferAuditLog) DeviceTransferAuditResponse {
+	return DeviceTransferAuditResponse{
+		Action:     entry.Action,
+		ActorID:    entry.ActorID,
+		ActorName:  entry.ActorName,
+		Detail:     entry.Detail,
+		OccurredAt: entry.OccurredAt,
+	}
+}
+
+type DeviceArchiveResponse struct {
+	ID         uuid.UUID `json:"id"`
+	DeviceID   uuid.UUID `json:"device_id"`
+	DeviceName string    `json:"device_name"`
+	TransferID uuid.UUID `json:"transfer_id"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+func BindDeviceArchiveToResponse(archive *models.DeviceArchive) DeviceArchiveResponse {
+	return DeviceArchiveResponse{
+		ID:         archive.ID,
+		DeviceID:   archive.DeviceID,
+		DeviceName: archive.DeviceName,
+		TransferID: archive.TransferID,
+		ArchivedAt: archive.ArchivedAt,
+	}
+}
@@ -0,0 +1,60 @@
+package response
+
+import (
+	"gin-crud/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type WebhookResponse struct {
+	ID                  uuid.UUID  `json:"id"`
+	URL                 string     `json:"url"`
+	GroupID             *uuid.UUID `json:"group_id"`
+	Events              []string   `json:"events"`
+	IsEnabled           bool       `json:"is_enabled"`
+	Secret              string     `json:"secret,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// BindWebhookToResponse leaves the signing secret out unless withSecret is set,
+// which is only done right after the secret was generated.
+func BindWebhookToResponse(webhook *models.Webhook, withSecret bool) WebhookResponse {
+	resp := WebhookResponse{
+		ID:                  webhook.ID,
+		URL:                 webhook.URL,
+		GroupID:             webhook.GroupID,
+		Events:              webhook.EventList(),
+		IsEnabled:           webhook.IsEnabled,
+		ConsecutiveFailures: webhook.ConsecutiveFailures,
+	}
+	if withSecret {
+		resp.Secret = webhook.Secret
+	}
+	return resp
+}
+
+type WebhookDeliveryResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	Event         string     `json:"event"`
+	Attempt       int        `json:"attempt"`
+	StatusCode    int        `json:"status_code"`
+	Error         string     `json:"error,omitempty"`
+	Succeeded     bool       `json:"succeeded"`
+	DeliveredAt   time.Time  `json:"delivered_at"`
+	Status        string     `json:"status"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+func BindWebhookDeliveryToResponse(delivery *models.WebhookDelivery) WebhookDeliveryResponse {
+	return WebhookDeliveryResponse{
+		ID:            delivery.ID,
+		Event:         delivery.Event,
+		Attempt:       delivery.Attempt,
+		StatusCode:    delivery.StatusCode,
+		Error:         delivery.Error,
+		Succeeded:     delivery.Succeeded,
+		DeliveredAt:   delivery.DeliveredAt,
+		Status:        delivery.Status,
+		NextAttemptAt: delivery.NextAttemptAt,
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// respondAccessError answers for a failed device or group lookup. Users without any access get the same
+// answer as for a missing record, so shared IDs cannot be probed.
+func respondAccessError(c *gin.Context, err error, notFound string) {
+	message, status := accessErrorResponse(err, notFound)
+	response.GlobalResponse(c, message, status, nil)
+}
+
+// accessErrorResponse returns the message and status respondAccessError answers with, for request binders
+// that hand them back to their handler.
+func accessErrorResponse(err error, notFound string) (string, int) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound, http.StatusNotFound
+	case errors.Is(err, utils.ErrInsufficientRole):
+		return "Your role does not allow this action", http.StatusForbidden
+	default:
+		log.Println(err.Error())
+		return "Failed to check access", http.StatusInternalServerError
+	}
+}
+
+// getAccessibleDevice loads a device the user holds at least minRole on and answers the request when it cannot.
+func getAccessibleDevice(c *gin.Context, userID uuid.UUID, deviceID uuid.UUID, minRole string) (*models.Device, bool) {
+	device, _, err := models.GetAccessibleDevice(initializers.DB, userID, deviceID, minRole)
+	if err != nil {
+		respondAccessError(c, err, "Cannot find the device")
+		return nil, false
+	}
+	return device, true
+}
+
+// getAccessibleGroup loads a group the user holds at least minRole on and answers the request when it cannot.
+func getAccessibleGroup(c *gin.Context, userID uuid.UUID, groupID uuid.UUID, minRole string) (*models.DeviceGrouping, bool) {
+	group, _, err := models.GetAccessibleGroup(initializers.DB, userID, groupID, minRole)
+	if err != nil {
+		respondAccessError(c, err, "Group not found")
+		return nil, false
+	}
+	return group, true
+}
+
+// getAuthorizedDevice resolves the device in the id path parameter for the signed in user.
+func getAuthorizedDevice(c *gin.Context, minRole string) (*models.Device, bool) {
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, false
+	}
+	return getAccessibleDevice(c, user.ID, deviceID, minRole)
+}
+
+// getAuthorizedGroup resolves the group in the id path parameter for the signed in user.
+func getAuthorizedGroup(c *gin.Context, minRole string) (*models.DeviceGrouping, bool) {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid group ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, false
+	}
+	return getAccessibleGroup(c, user.ID, groupID, minRole)
+}
+
+// getAccessibleOrganization loads an organization the user holds at least minRole in and answers the request
+// when it cannot.
+func getAccessibleOrganization(c *gin.Context, userID uuid.UUID, organizationID uuid.UUID, minRole string) (*models.Organization, bool) {
+	organization, _, err := models.GetAccessibleOrganization(initializers.DB, userID, organizationID, minRole)
+	if err != nil {
+		respondAccessError(c, err, "Organization not found")
+		return nil, false
+	}
+	return organization, true
+}
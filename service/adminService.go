@@ -221,14 +221,42 @@ func UpdateParticipantById(c *gin.Context) {
 }
 
 func AddDevice(c *gin.Context) {
-	device := model.Device{
-		ID: uuid.New(),
+	device, err := CreateClaimableDevice(initializers.DB)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to create device", http.StatusInternalServerError, nil)
+		return
 	}
 
-	if err := model.CreateDevice(&device); err != nil {
-		response.GlobalResponse(c, "Failed to create device", http.StatusInternalServerError, nil)
+	response.GlobalResponse(c, "Device created successfully", http.StatusOK, bindDeviceSecretToResponse(device))
+}
+
+func AdminRotateDeviceSecret(c *gin.Context) {
+	id := c.Param("id")
+
+	uuId, err := uuid.Parse(id)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
 		return
 	}
 
-	response.GlobalResponse(c, "Device created successfully", http.StatusOK, device)
+	device, err := model.GetDeviceById(initializers.DB, uuId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Device not found", http.StatusNotFound, nil)
+		} else {
+			response.GlobalResponse(c, "Failed to retrieve device", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	if err := model.RotateDeviceSecret(initializers.DB, device); err != nil {
+		response.GlobalResponse(c, "Failed to rotate device secret", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := response.DeviceSecretResponse{
+		ID:        device.ID,
+		SecretKey: device.SecretKey,
+	}
+	response.GlobalResponse(c, "Successfully rotated device secret", http.StatusOK, resp)
 }
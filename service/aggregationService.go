@@ -0,0 +1,299 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAggregationMaxSpan = 31 * 24 * time.Hour
+	defaultRollupMaxSpan      = 366 * 24 * time.Hour
+	maxAggregationBuckets     = 5000
+)
+
+// aggregationMaxSpan reads AGGREGATION_MAX_SPAN (a Go duration such as 744h) and falls back to 31 days.
+func aggregationMaxSpan() time.Duration {
+	if value := os.Getenv("AGGREGATION_MAX_SPAN"); value != "" {
+		span, err := time.ParseDuration(value)
+		if err == nil && span > 0 {
+			return span
+		}
+		log.Println("Invalid AGGREGATION_MAX_SPAN, using default:", value)
+	}
+	return defaultAggregationMaxSpan
+}
+
+// rollupMaxSpan reads ROLLUP_MAX_SPAN and falls back to 366 days. It bounds queries served from rollups.
+func rollupMaxSpan() time.Duration {
+	if value := os.Getenv("ROLLUP_MAX_SPAN"); value != "" {
+		span, err := time.ParseDuration(value)
+		if err == nil && span > 0 {
+			return span
+		}
+		log.Println("Invalid ROLLUP_MAX_SPAN, using default:", value)
+	}
+	return defaultRollupMaxSpan
+}
+
+// parseBucket accepts Go durations plus a "d" suffix for whole days, e.g. 5m, 1h or 1d.
+func parseBucket(value string) (time.Duration, error) {
+	if value == "" {
+		return time.Hour, nil
+	}
+	if value[len(value)-1] == 'd' {
+		days, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || days <= 0 {
+			return 0, errors.New("Invalid bucket. Use values like 5m, 1h or 1d")
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	bucket, err := time.ParseDuration(value)
+	if err != nil || bucket < time.Minute {
+		return 0, errors.New("Invalid bucket. Use values like 5m, 1h or 1d")
+	}
+	return bucket, nil
+}
+
+func validateAggregationRequest(req request.AggregationRequest) (time.Time, time.Time, time.Duration, []string, error) {
+	var start, end time.Time
+	bucket, err := parseBucket(req.Bucket)
+	if err != nil {
+		return start, end, 0, nil, err
+	}
+
+	maxSpan := aggregationMaxSpan()
+	if _, ok := rollupResolutionFor(bucket, req.Percentiles); ok {
+		maxSpan = rollupMaxSpan()
+	}
+	start, end, err = parseTimeRange(req.Start, req.End, maxSpan)
+	if err != nil {
+		return start, end, 0, nil, err
+	}
+	if end.Sub(start)/bucket > maxAggregationBuckets {
+		return start, end, 0, nil, fmt.Errorf("Too many buckets, cannot exceed %d", maxAggregationBuckets)
+	}
+
+	metrics := req.Metrics
+	if len(metrics) == 0 {
+		if metrics, err = definedMetricNames(); err != nil {
+			return start, end, 0, nil, err
+		}
+	}
+	for _, metric := range metrics {
+		ok, err := isDefinedMetric(metric)
+		if err != nil {
+			return start, end, 0, nil, err
+		} else if !ok {
+			return start, end, 0, nil, fmt.Errorf("Unknown metric %s", metric)
+		}
+	}
+
+	for _, p := range req.Percentiles {
+		if p <= 0 || p >= 100 {
+			return start, end, 0, nil, errors.New("Percentiles must be between 0 and 100")
+		}
+	}
+	return start, end, bucket, metrics, nil
+}
+
+// aggregateReadings groups readings into buckets aligned to the unix epoch. Readings must be sorted by time.
+func aggregateReadings(readings []models.DeviceReading, bucket time.Duration, metrics []string, percentiles []float64) []response.AggregationBucketResponse {
+	var buckets []response.AggregationBucketResponse
+	var values map[string][]float64
+	var last map[string]float64
+	var bucketStart time.Time
+
+	flush := func() {
+		if values == nil {
+			return
+		}
+		stats := make(map[string]response.MetricStatsResponse, len(metrics))
+		for _, metric := range metrics {
+			stats[metric] = metricStats(values[metric], last[metric], percentiles)
+		}
+		buckets = append(buckets, response.AggregationBucketResponse{
+			BucketStart: bucketStart,
+			Metrics:     stats,
+		})
+	}
+
+	for i := range readings {
+		start := models.BucketStart(readings[i].TimeStamp, bucket)
+		if values == nil || !start.Equal(bucketStart) {
+			flush()
+			bucketStart = start
+			values = make(map[string][]float64, len(metrics))
+			last = make(map[string]float64, len(metrics))
+		}
+		for _, metric := range metrics {
+			value, ok := readings[i].MetricValue(metric)
+			if !ok {
+				continue
+			}
+			values[metric] = append(values[metric], value)
+			last[metric] = value
+		}
+	}
+	flush()
+	return buckets
+}
+
+// aggregateDevices serves whole-hour and whole-day buckets from the rollups, so long ranges never scan
+// raw readings; the range is then widened to the enclosing rollup buckets. Other buckets and
+// percentile queries are computed from the raw readings.
+func aggregateDevices(deviceIDs []uuid.UUID, start time.Time, end time.Time, bucket time.Duration, metrics []string, percentiles []float64) ([]response.AggregationBucketResponse, error) {
+	if resolution, ok := rollupResolutionFor(bucket, percentiles); ok {
+		rollups, err := models.GetRollupsForDevices(initializers.DB, deviceIDs, resolution, metrics, models.RollupBucketStart(resolution, start), end)
+		if err != nil {
+			return nil, err
+		}
+		return aggregateRollups(rollups, bucket, metrics), nil
+	}
+
+	readings, err := models.GetReadingsForDevices(initializers.DB, deviceIDs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateReadings(readings, bucket, metrics, percentiles), nil
+}
+
+func metricStats(values []float64, last float64, percentiles []float64) response.MetricStatsResponse {
+	stats := response.MetricStatsResponse{Count: len(values)}
+	if len(values) == 0 {
+		return stats
+	}
+
+	stats.Last = last
+	stats.Min = math.Inf(1)
+	stats.Max = math.Inf(-1)
+	var sum float64
+	for _, value := range values {
+		stats.Min = math.Min(stats.Min, value)
+		stats.Max = math.Max(stats.Max, value)
+		sum += value
+	}
+	stats.Mean = sum / float64(len(values))
+
+	if len(percentiles) > 0 {
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		stats.Percentiles = make(map[string]float64, len(percentiles))
+		for _, p := range percentiles {
+			key := "p" + strconv.FormatFloat(p, 'f', -1, 64)
+			stats.Percentiles[key] = percentile(sorted, p)
+		}
+	}
+	return stats
+}
+
+// percentile interpolates linearly between the closest ranks of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+func GetDeviceAggregation(c *gin.Context) {
+	var req request.AggregationRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Invalid aggregation data", http.StatusBadRequest, nil)
+		return
+	}
+
+	deviceID, err := uuid.Parse(req.DeviceID)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	start, end, bucket, metrics, err := validateAggregationRequest(req)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	device, ok := getAccessibleDevice(c, user.ID, deviceID, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	buckets, err := aggregateDevices([]uuid.UUID{device.ID}, start, end, bucket, metrics, req.Percentiles)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve readings", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully aggregated device readings", http.StatusOK, buckets)
+}
+
+func GetGroupAggregation(c *gin.Context) {
+	var req request.AggregationRequest
+
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid group ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Invalid aggregation data", http.StatusBadRequest, nil)
+		return
+	}
+
+	start, end, bucket, metrics, err := validateAggregationRequest(req)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	group, ok := getAccessibleGroup(c, user.ID, groupID, models.RoleViewer)
+	if !ok {
+		return
+	}
+	deviceIDs, err := models.GetUserGroupDeviceIds(initializers.DB, group.UmkmDataId, group.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve group", http.StatusInternalServerError, nil)
+		return
+	}
+
+	buckets, err := aggregateDevices(deviceIDs, start, end, bucket, metrics, req.Percentiles)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve readings", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully aggregated group readings", http.StatusOK, buckets)
+}
@@ -0,0 +1,441 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var alertExpressionRegex = regexp.MustCompile(`^\s*([a-z][a-z0-9_]*)\s*(<=|>=|<|>)\s*(-?\d+(?:\.\d+)?)\s*(?:for\s+(\S+))?\s*$`)
+
+// parseAlertExpression reads expressions such as "ph_level < 6.0 for 10m" into the rule.
+func parseAlertExpression(expression string, rule *models.AlertRule) error {
+	matches := alertExpressionRegex.FindStringSubmatch(expression)
+	if matches == nil {
+		return errors.New("Invalid expression. Use the form: ph_level < 6.0 for 10m")
+	}
+
+	ok, err := isDefinedMetric(matches[1])
+	if err != nil {
+		return errors.New("Failed to retrieve metric definitions")
+	} else if !ok {
+		return fmt.Errorf("Unknown metric %s", matches[1])
+	}
+
+	threshold, err := strconv.ParseFloat(matches[3], 64)
+	if err != nil {
+		return errors.New("Invalid threshold")
+	}
+
+	var duration time.Duration
+	if matches[4] != "" {
+		duration, err = time.ParseDuration(matches[4])
+		if err != nil || duration < 0 {
+			return errors.New("Invalid duration. Use values like 30s, 10m or 1h")
+		}
+	}
+
+	rule.Metric = matches[1]
+	rule.Operator = matches[2]
+	rule.Threshold = threshold
+	rule.DurationSeconds = int(duration.Seconds())
+	return nil
+}
+
+func isBreaching(operator string, value float64, threshold float64) bool {
+	switch operator {
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	}
+	return false
+}
+
+// isCleared applies the hysteresis band: a firing alert only resolves once the value has moved
+// past the threshold by the hysteresis margin, so readings hovering around it do not flap.
+func isCleared(operator string, value float64, threshold float64, hysteresis float64) bool {
+	switch operator {
+	case "<", "<=":
+		return value >= threshold+hysteresis
+	case ">", ">=":
+		return value <= threshold-hysteresis
+	}
+	return true
+}
+
+// evaluateAlertRules runs every rule targeting the device against a stored reading and
+// returns the state transitions it caused. Readings older than the last evaluated one are ignored.
+func evaluateAlertRules(device *models.Device, reading *models.DeviceReading) []models.AlertEvent {
+	var events []models.AlertEvent
+
+	rules, err := models.GetDeviceAlertRules(initializers.DB, device)
+	if err != nil {
+		log.Println("Failed to retrieve alert rules:", err)
+		return nil
+	}
+
+	allowed := map[uuid.UUID]bool{}
+	for i := range rules {
+		rule := rules[i]
+		value, ok := reading.MetricValue(rule.Metric)
+		if !ok {
+			continue
+		}
+		if !userHoldsDeviceRole(device, rule.UmkmDataId, models.RoleOperator, allowed) {
+			continue
+		}
+
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			state, err := models.LockAlertState(tx, rule.ID, device.ID)
+			if err != nil {
+				return err
+			}
+			if reading.TimeStamp.Before(state.LastEvaluatedAt) {
+				return nil
+			}
+
+			event := nextAlertTransition(&rule, state, value, reading.TimeStamp)
+			state.LastValue = value
+			state.LastEvaluatedAt = reading.TimeStamp
+			if err := tx.Save(state).Error; err != nil {
+				return err
+			}
+			if event == nil {
+				return nil
+			}
+
+			event.DeviceID = device.ID
+			event.UmkmDataId = rule.UmkmDataId
+			if err := models.CreateAlertEvent(tx, event); err != nil {
+				return err
+			}
+			events = append(events, *event)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to evaluate alert rule %s: %v\n", rule.ID, err)
+		}
+	}
+	return events
+}
+
+// userHoldsDeviceRole reports whether the user still holds minRole on the device. Rules and webhooks of
+// shared users outlive the share, so they only run while the access lasts. Answers are kept in cache for
+// the rest of the caller's loop.
+func userHoldsDeviceRole(device *models.Device, userID uuid.UUID, minRole string, cache map[uuid.UUID]bool) bool {
+	if allowed, ok := cache[userID]; ok {
+		return allowed
+	}
+	role, err := models.GetDeviceRole(initializers.DB, userID, device)
+	if err != nil {
+		log.Println("Failed to check device role:", err)
+		return false
+	}
+	cache[userID] = models.RoleAllows(role, minRole)
+	return cache[userID]
+}
+
+// nextAlertTransition moves the state forward and returns the event to record, if any.
+func nextAlertTransition(rule *models.AlertRule, state *models.AlertState, value float64, at time.Time) *models.AlertEvent {
+	breaching := isBreaching(rule.Operator, value, rule.Threshold)
+	duration := time.Duration(rule.DurationSeconds) * time.Second
+
+	var status models.AlertStatus
+	switch state.Status {
+	case models.AlertStatusFiring:
+		if !isCleared(rule.Operator, value, rule.Threshold, rule.Hysteresis) {
+			return nil
+		}
+		state.Status = models.AlertStatusResolved
+		state.PendingSince = nil
+		state.FiredAt = nil
+		status = models.AlertStatusResolved
+	case models.AlertStatusPending:
+		if !breaching {
+			state.Status = models.AlertStatusOK
+			state.PendingSince = nil
+			return nil
+		}
+		if at.Sub(*state.PendingSince) < duration {
+			return nil
+		}
+		state.Status = models.AlertStatusFiring
+		state.FiredAt = &at
+		status = models.AlertStatusFiring
+	default:
+		if !breaching {
+			return nil
+		}
+		state.PendingSince = &at
+		if duration > 0 {
+			state.Status = models.AlertStatusPending
+			status = models.AlertStatusPending
+		} else {
+			state.Status = models.AlertStatusFiring
+			state.FiredAt = &at
+			status = models.AlertStatusFiring
+		}
+	}
+
+	ruleID := rule.ID
+	return &models.AlertEvent{
+		RuleID:     &ruleID,
+		Status:     status,
+		Metric:     rule.Metric,
+		Value:      value,
+		Threshold:  rule.Threshold,
+		Message:    fmt.Sprintf("%s is %s: %s %s %g (value %g)", rule.Name, status, rule.Metric, rule.Operator, rule.Threshold, value),
+		OccurredAt: at,
+	}
+}
+
+// bindAlertRuleRequest validates the request and applies it to the rule, checking the target belongs to the user.
+func bindAlertRuleRequest(req request.AlertRuleRequest, userID uuid.UUID, rule *models.AlertRule) (error, string, int) {
+	if req.Name == "" {
+		return errors.New("empty name"), "Rule name cannot be empty", http.StatusBadRequest
+	}
+	if err := parseAlertExpression(req.Expression, rule); err != nil {
+		return err, err.Error(), http.StatusBadRequest
+	}
+	if req.Hysteresis < 0 {
+		return errors.New("negative hysteresis"), "Hysteresis cannot be negative", http.StatusBadRequest
+	}
+	if (req.DeviceID == "") == (req.GroupID == "") {
+		return errors.New("invalid target"), "Set either device_id or group_id", http.StatusBadRequest
+	}
+
+	rule.DeviceID = nil
+	rule.GroupID = nil
+	if req.DeviceID != "" {
+		deviceID, err := uuid.Parse(req.DeviceID)
+		if err != nil {
+			return err, "Invalid device ID format", http.StatusBadRequest
+		}
+		if _, _, err := models.GetAccessibleDevice(initializers.DB, userID, deviceID, models.RoleOperator); err != nil {
+			message, status := accessErrorResponse(err, "Device not found")
+			return err, message, status
+		}
+		rule.DeviceID = &deviceID
+	} else {
+		groupID, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			return err, "Invalid group ID format", http.StatusBadRequest
+		}
+		if _, _, err := models.GetAccessibleGroup(initializers.DB, userID, groupID, models.RoleOperator); err != nil {
+			message, status := accessErrorResponse(err, "Group not found")
+			return err, message, status
+		}
+		rule.GroupID = &groupID
+	}
+
+	rule.Name = req.Name
+	rule.Hysteresis = req.Hysteresis
+	if req.IsEnabled != nil {
+		rule.IsEnabled = *req.IsEnabled
+	}
+	return nil, "", http.StatusOK
+}
+
+func CreateAlertRule(c *gin.Context) {
+	var req request.AlertRuleRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	rule := models.AlertRule{
+		UmkmDataId: user.ID,
+		IsEnabled:  true,
+	}
+	if err, message, status := bindAlertRuleRequest(req, user.ID, &rule); err != nil {
+		response.GlobalResponse(c, message, status, nil)
+		return
+	}
+
+	if err := models.CreateAlertRule(initializers.DB, &rule); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create alert rule", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully created alert rule", http.StatusOK, response.BindAlertRuleToResponse(&rule))
+}
+
+func GetAllAlertRules(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	rules, err := models.GetUserAlertRules(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve alert rules", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.AlertRuleResponse, 0, len(rules))
+	for i := range rules {
+		resp = append(resp, response.BindAlertRuleToResponse(&rules[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved alert rules", http.StatusOK, resp)
+}
+
+func getAlertRuleByParam(c *gin.Context) (*models.AlertRule, bool) {
+	ruleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid rule ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	rule, err := models.GetUserAlertRule(initializers.DB, user.ID, ruleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Alert rule not found", http.StatusNotFound, nil)
+		} else {
+			response.GlobalResponse(c, "Failed to retrieve alert rule", http.StatusInternalServerError, nil)
+		}
+		return nil, false
+	}
+	return rule, true
+}
+
+func GetAlertRuleById(c *gin.Context) {
+	rule, ok := getAlertRuleByParam(c)
+	if !ok {
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved alert rule", http.StatusOK, response.BindAlertRuleToResponse(rule))
+}
+
+func UpdateAlertRule(c *gin.Context) {
+	var req request.AlertRuleRequest
+
+	rule, ok := getAlertRuleByParam(c)
+	if !ok {
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err, message, status := bindAlertRuleRequest(req, rule.UmkmDataId, rule); err != nil {
+		response.GlobalResponse(c, message, status, nil)
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(rule).Error; err != nil {
+			return err
+		}
+		return models.ResetAlertStates(tx, rule.ID)
+	})
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update alert rule", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully updated alert rule", http.StatusOK, response.BindAlertRuleToResponse(rule))
+}
+
+func DeleteAlertRule(c *gin.Context) {
+	rule, ok := getAlertRuleByParam(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteAlertRule(initializers.DB, rule); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to delete alert rule", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully deleted alert rule", http.StatusOK, nil)
+}
+
+func GetAlertHistory(c *gin.Context) {
+	var req request.AlertHistoryRequest
+	var deviceID, ruleID *uuid.UUID
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.BindQuery(&req); err != nil {
+		response.GlobalResponse(c, "Invalid query parameters", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.Start == "" {
+		req.Start = time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339)
+	}
+	start, end, err := parseTimeRange(req.Start, req.End, aggregationMaxSpan())
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.DeviceID != "" {
+		id, err := uuid.Parse(req.DeviceID)
+		if err != nil {
+			response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+			return
+		}
+		deviceID = &id
+	}
+	if req.RuleID != "" {
+		id, err := uuid.Parse(req.RuleID)
+		if err != nil {
+			response.GlobalResponse(c, "Invalid rule ID format", http.StatusBadRequest, nil)
+			return
+		}
+		ruleID = &id
+	}
+
+	events, err := models.GetUserAlertEvents(initializers.DB, user.ID, deviceID, ruleID, start, end)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve alert history", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.AlertEventResponse, 0, len(events))
+	for i := range events {
+		resp = append(resp, response.BindAlertEventToResponse(&events[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved alert history", http.StatusOK, resp)
+}
@@ -176,6 +176,11 @@ func UserRegister(c *gin.Context) {
 		return
 	}
 	initializers.DB.Save(&user)
+	// New accounts start as the only owner of an organization for their business. Registration does not
+	// fail without one: the account works as a personal account until migrate-organizations creates it.
+	if _, err := model.MigrateUserToOrganization(initializers.DB, &user); err != nil {
+		log.Println("Failed to create organization: " + err.Error())
+	}
 	if err != nil {
 		response.GlobalResponse(c, "Failed to generate confirmation token", http.StatusInternalServerError, nil)
 	}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const recalibrationPageSize = 1000
+
+// recalibrationMu runs one history recomputation at a time, so corrections made in quick
+// succession cannot write over each other's results.
+var recalibrationMu sync.Mutex
+
+// calibrationSet holds the calibrations of a device by metric, oldest effective first.
+type calibrationSet map[string][]models.Calibration
+
+func loadCalibrations(deviceID uuid.UUID) (calibrationSet, error) {
+	calibrations, err := models.GetDeviceCalibrations(initializers.DB, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(calibrationSet)
+	for _, calibration := range calibrations {
+		set[calibration.Metric] = append(set[calibration.Metric], calibration)
+	}
+	return set, nil
+}
+
+// at returns the calibration of metric in effect at t, or nil when there is none.
+func (set calibrationSet) at(metric string, t time.Time) *models.Calibration {
+	calibrations := set[metric]
+	i := sort.Search(len(calibrations), func(i int) bool { return calibrations[i].EffectiveFrom.After(t) })
+	if i == 0 {
+		return nil
+	}
+	return &calibrations[i-1]
+}
+
+// applyCalibrations sets every metric of the reading to its raw value corrected by the calibration in
+// effect at the reading's timestamp, and keeps the raw value of each corrected metric. It can be applied
+// again to a stored reading, since it always starts from the raw values.
+func applyCalibrations(reading *models.DeviceReading, set calibrationSet) {
+	for _, name := range reading.MetricNames() {
+		raw, _ := reading.RawMetricValue(name)
+		calibration := set.at(name, reading.TimeStamp)
+		if calibration == nil {
+			if _, ok := reading.RawValues[name]; ok {
+				reading.SetMetricValue(name, raw)
+				delete(reading.RawValues, name)
+			}
+			continue
+		}
+		if reading.RawValues == nil {
+			reading.RawValues = models.MetricValues{}
+		}
+		reading.RawValues[name] = raw
+		reading.SetMetricValue(name, calibration.Apply(raw))
+	}
+	if len(reading.RawValues) == 0 {
+		reading.RawValues = nil
+	}
+}
+
+// recalibrateDeviceReadings recomputes the stored readings of a device from their raw values, starting
+// at from, and rebuilds the affected rollups. Readings already stored are never moved to quarantine;
+// a value a correction pushes out of its valid range is flagged as suspect instead.
+func recalibrateDeviceReadings(deviceID uuid.UUID, from time.Time) {
+	recalibrationMu.Lock()
+	defer recalibrationMu.Unlock()
+
+	device, err := models.GetDeviceById(initializers.DB, deviceID)
+	if err != nil {
+		log.Printf("Failed to recalibrate device %s: %v\n", deviceID, err)
+		return
+	}
+	set, err := loadCalibrations(deviceID)
+	if err != nil {
+		log.Printf("Failed to load calibrations of device %s: %v\n", deviceID, err)
+		return
+	}
+	overrides, err := loadPlausibilityRanges(deviceID)
+	if err != nil {
+		log.Printf("Failed to load plausibility ranges of device %s: %v\n", deviceID, err)
+		return
+	}
+
+	end := time.Now().UTC().Add(24 * time.Hour)
+	var afterTime *time.Time
+	var afterID uuid.UUID
+	var updated int
+	for {
+		readings, err := models.GetDeviceReadingsPage(initializers.DB, deviceID, from, end, afterTime, afterID, recalibrationPageSize)
+		if err != nil {
+			log.Printf("Failed to read readings of device %s for recalibration: %v\n", deviceID, err)
+			return
+		}
+
+		for i := range readings {
+			reading := &readings[i]
+			applyCalibrations(reading, set)
+			if err := roundMetricValues(reading); err != nil {
+				log.Printf("Failed to round readings of device %s: %v\n", deviceID, err)
+				return
+			}
+			if _, _, err := assessReadingQuality(reading, overrides); err != nil {
+				log.Printf("Failed to assess readings of device %s: %v\n", deviceID, err)
+				return
+			}
+			if reading.Quality == models.QualityRejected {
+				reading.Quality = models.QualitySuspect
+			}
+			if err := models.UpdateReadingValues(initializers.DB, reading); err != nil {
+				log.Printf("Failed to update reading %s: %v\n", reading.ID, err)
+				return
+			}
+			updated++
+		}
+
+		if len(readings) < recalibrationPageSize {
+			break
+		}
+		last := readings[len(readings)-1]
+		afterTime, afterID = &last.TimeStamp, last.ID
+	}
+	log.Printf("Recalibrated %d readings of device %s from %s\n", updated, deviceID, from.Format(time.RFC3339))
+
+	rebuildFrom, err := rollupRebuildStart(device)
+	if err != nil {
+		log.Printf("Failed to rebuild rollups of device %s after recalibration: %v\n", deviceID, err)
+		return
+	}
+	if dayStart := models.RollupBucketStart(models.RollupDaily, from); dayStart.After(rebuildFrom) {
+		rebuildFrom = dayStart
+	}
+	if _, err := models.RebuildDeviceRollups(initializers.DB, deviceID, rebuildFrom); err != nil {
+		log.Printf("Failed to rebuild rollups of device %s after recalibration: %v\n", deviceID, err)
+	}
+}
+
+func parseCalibrationRequest(req request.CalibrationRequest, calibration *models.Calibration) error {
+	slope := 1.0
+	if req.Slope != nil {
+		slope = *req.Slope
+	}
+	if slope == 0 {
+		return errors.New("Slope cannot be zero")
+	}
+
+	effectiveFrom := time.Now().UTC()
+	if req.EffectiveFrom != "" {
+		var err error
+		effectiveFrom, err = time.Parse(time.RFC3339, req.EffectiveFrom)
+		if err != nil {
+			return errors.New("Invalid effective_from format. Use RFC3339")
+		}
+	}
+
+	calibration.Offset = req.Offset
+	calibration.Slope = slope
+	calibration.EffectiveFrom = effectiveFrom
+	calibration.Note = req.Note
+	return nil
+}
+
+func getOwnedCalibration(c *gin.Context, device *models.Device) (*models.Calibration, bool) {
+	calibrationID, err := uuid.Parse(c.Param("calibration_id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid calibration ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	calibration, err := models.GetDeviceCalibrationById(initializers.DB, device.ID, calibrationID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Calibration not found", http.StatusNotFound, nil)
+			return nil, false
+		}
+		response.GlobalResponse(c, "Failed to retrieve calibration", http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	return calibration, true
+}
+
+func earlierOf(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func GetDeviceCalibrations(c *gin.Context) {
+	device, ok := getAuthorizedDevice(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	calibrations, err := models.GetDeviceCalibrations(initializers.DB, device.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve calibrations", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.CalibrationResponse, 0, len(calibrations))
+	for i := range calibrations {
+		resp = append(resp, response.BindCalibrationToResponse(&calibrations[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved calibrations", http.StatusOK, resp)
+}
+
+func CreateCalibration(c *gin.Context) {
+	var req request.CalibrationRequest
+
+	device, ok := getAuthorizedDevice(c, models.RoleOperator)
+	if !ok {
+		return
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	defined, err := isDefinedMetric(req.Metric)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve metric definitions", http.StatusInternalServerError, nil)
+		return
+	} else if !defined {
+		response.GlobalResponse(c, fmt.Sprintf("Unknown metric %s", req.Metric), http.StatusBadRequest, nil)
+		return
+	}
+
+	calibration := models.Calibration{
+		DeviceID:       device.ID,
+		Metric:         req.Metric,
+		CalibratedByID: user.ID,
+		CalibratedBy:   user.Name,
+	}
+	if err := parseCalibrationRequest(req, &calibration); err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := models.SaveCalibration(initializers.DB, &calibration); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create calibration", http.StatusInternalServerError, nil)
+		return
+	}
+	go recalibrateDeviceReadings(device.ID, calibration.EffectiveFrom)
+
+	response.GlobalResponse(c, "Successfully created calibration", http.StatusCreated, response.BindCalibrationToResponse(&calibration))
+}
+
+// UpdateCalibration corrects a calibration and recomputes the readings it covered before and after
+// the correction. The metric of a calibration cannot be changed.
+func UpdateCalibration(c *gin.Context) {
+	var req request.CalibrationRequest
+
+	device, ok := getAuthorizedDevice(c, models.RoleOperator)
+	if !ok {
+		return
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	calibration, ok := getOwnedCalibration(c, device)
+	if !ok {
+		return
+	}
+	previousFrom := calibration.EffectiveFrom
+
+	if err := parseCalibrationRequest(req, calibration); err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	calibration.CalibratedByID = user.ID
+	calibration.CalibratedBy = user.Name
+
+	if err := models.SaveCalibration(initializers.DB, calibration); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update calibration", http.StatusInternalServerError, nil)
+		return
+	}
+	go recalibrateDeviceReadings(device.ID, earlierOf(previousFrom, calibration.EffectiveFrom))
+
+	response.GlobalResponse(c, "Successfully updated calibration", http.StatusOK, response.BindCalibrationToResponse(calibration))
+}
+
+func DeleteCalibration(c *gin.Context) {
+	device, ok := getAuthorizedDevice(c, models.RoleOperator)
+	if !ok {
+		return
+	}
+
+	calibration, ok := getOwnedCalibration(c, device)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteCalibration(initializers.DB, calibration); err != nil {
+		response.GlobalResponse(c, "Failed to delete calibration", http.StatusInternalServerError, nil)
+		return
+	}
+	go recalibrateDeviceReadings(device.ID, calibration.EffectiveFrom)
+
+	response.GlobalResponse(c, "Successfully deleted calibration", http.StatusOK, nil)
+}
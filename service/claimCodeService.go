@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultClaimCodeTTL   = 365 * 24 * time.Hour
+	defaultClaimQRBaseURL = "https://imon.andamantau.com/claim?code="
+)
+
+// claimCodeTTL is how long a printed claim code stays valid. Labels can sit in a warehouse for a while.
+func claimCodeTTL() time.Duration {
+	if value := os.Getenv("CLAIM_CODE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Println("Invalid CLAIM_CODE_TTL, using default:", value)
+	}
+	return defaultClaimCodeTTL
+}
+
+// ClaimQRPayload is the text encoded in the QR code printed next to the claim code.
+func ClaimQRPayload(code string) string {
+	base := os.Getenv("CLAIM_QR_BASE_URL")
+	if base == "" {
+		base = defaultClaimQRBaseURL
+	}
+	return base + url.QueryEscape(utils.FormatClaimCode(code))
+}
+
+func newClaimableDevice() (models.Device, error) {
+	code, err := utils.GenerateClaimCode()
+	if err != nil {
+		return models.Device{}, err
+	}
+	expiresAt := time.Now().Add(claimCodeTTL())
+	return models.Device{
+		ID:                 uuid.New(),
+		ClaimCode:          &code,
+		ClaimCodeExpiresAt: &expiresAt,
+	}, nil
+}
+
+// CreateClaimableDevice creates an unregistered device together with the claim code its owner will register it with.
+func CreateClaimableDevice(db *gorm.DB) (*models.Device, error) {
+	device, err := newClaimableDevice()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Create(&device).Error; err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func bindDeviceSecretToResponse(device *models.Device) response.DeviceSecretResponse {
+	resp := response.DeviceSecretResponse{
+		ID:        device.ID,
+		SecretKey: device.SecretKey,
+	}
+	if device.ClaimCode != nil {
+		resp.ClaimCode = utils.FormatClaimCode(*device.ClaimCode)
+		resp.ClaimQRPayload = ClaimQRPayload(*device.ClaimCode)
+		resp.ClaimCodeExpiresAt = device.ClaimCodeExpiresAt
+	}
+	return resp
+}
+
+func bindClaimCodeToResponse(device *models.Device) response.ClaimCodeResponse {
+	return response.ClaimCodeResponse{
+		ClaimCode:          utils.FormatClaimCode(*device.ClaimCode),
+		ClaimQRPayload:     ClaimQRPayload(*device.ClaimCode),
+		ClaimCodeExpiresAt: device.ClaimCodeExpiresAt,
+	}
+}
+
+// AdminIssueClaimCode replaces the claim code of an unregistered device, e.g. when its label got lost or expired.
+func AdminIssueClaimCode(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	device, err := models.GetDeviceById(initializers.DB, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Device not found", http.StatusNotFound, nil)
+		} else {
+			response.GlobalResponse(c, "Failed to retrieve device", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	err = models.IssueClaimCode(initializers.DB, device, time.Now().Add(claimCodeTTL()))
+	if errors.Is(err, utils.ErrDeviceAlreadyRegistered) {
+		response.GlobalResponse(c, "Device is already registered", http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to issue claim code", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully issued claim code", http.StatusOK, bindClaimCodeToResponse(device))
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+const deduplicationBatchSize = 5000
+
+// DeduplicateReadings finds devices with several readings at the same timestamp or with the same message ID
+// and, unless dryRun is set, keeps the first stored copy of each and rebuilds the rollups of the devices it
+// cleaned.
+func DeduplicateReadings(dryRun bool) (response.DeduplicationResponse, error) {
+	result := response.DeduplicationResponse{
+		DryRun:  dryRun,
+		Devices: []response.DeviceDeduplicationResponse{},
+	}
+
+	counts, err := models.GetDuplicateReadingCounts(initializers.DB)
+	if err != nil {
+		return result, err
+	}
+
+	for _, count := range counts {
+		entry := response.DeviceDeduplicationResponse{
+			DeviceID:          count.DeviceID,
+			Duplicates:        count.Duplicates,
+			MessageDuplicates: count.MessageDuplicates,
+		}
+		result.Duplicates += count.Duplicates
+		result.MessageDuplicates += count.MessageDuplicates
+
+		if !dryRun {
+			deleted, err := models.DeleteDuplicateDeviceReadings(initializers.DB, count.DeviceID, deduplicationBatchSize)
+			entry.Deleted = deleted
+			result.Deleted += deleted
+			if err != nil {
+				result.Devices = append(result.Devices, entry)
+				return result, fmt.Errorf("failed removing duplicates of device %s: %w", count.DeviceID, err)
+			}
+			if err := rebuildDeviceRollups(count.DeviceID); err != nil {
+				log.Printf("Failed to rebuild rollups of device %s after deduplication: %v\n", count.DeviceID, err)
+			}
+		}
+		result.Devices = append(result.Devices, entry)
+	}
+	return result, nil
+}
+
+func AdminDeduplicateReadings(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	result, err := DeduplicateReadings(dryRun)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to deduplicate readings", http.StatusInternalServerError, result)
+		return
+	}
+
+	message := fmt.Sprintf("Removed %d duplicate readings", result.Deleted)
+	if dryRun {
+		message = fmt.Sprintf("Found %d readings with a repeated time stamp and %d with a repeated message ID",
+			result.Duplicates, result.MessageDuplicates)
+	}
+	response.GlobalResponse(c, message, http.StatusOK, result)
+}
@@ -19,25 +19,41 @@ import (
 	"time"
 )
 
-func toCSV(data request.CSVData) ([]byte, error) {
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-
-	record := []string{
-		strconv.FormatFloat(float64(data.OxygenLevel), 'f', -1, 32),
-		strconv.FormatFloat(float64(data.WaterTemp), 'f', -1, 32),
-		strconv.FormatFloat(float64(data.EcLevel), 'f', -1, 32),
-		strconv.FormatFloat(float64(data.PhLevel), 'f', -1, 32),
-		data.TimeStamp.Format(time.RFC3339),
-		data.ID,
+func toDeviceReading(data request.CSVData, deviceID uuid.UUID) models.DeviceReading {
+	reading := models.DeviceReading{
+		DeviceID:    deviceID,
+		TimeStamp:   data.TimeStamp,
+		OxygenLevel: data.OxygenLevel,
+		WaterTemp:   data.WaterTemp,
+		EcLevel:     data.EcLevel,
+		PhLevel:     data.PhLevel,
 	}
-
-	if err := writer.Write(record); err != nil {
-		return nil, err
+	// Extra metrics are copied as sent instead of through SetMetricValue, so a built-in name in the map
+	// cannot overwrite the reading's own field; applyMetricDefinitions rejects it.
+	if len(data.Metrics) > 0 {
+		reading.Metrics = make(models.MetricValues, len(data.Metrics))
+		for name, value := range data.Metrics {
+			reading.Metrics[name] = value
+		}
+	}
+	if data.MessageID != "" {
+		messageID := data.MessageID
+		reading.MessageID = &messageID
 	}
+	return reading
+}
 
-	writer.Flush()
-	return buf.Bytes(), nil
+func toCSVData(reading models.DeviceReading) request.CSVData {
+	return request.CSVData{
+		OxygenLevel:     reading.OxygenLevel,
+		WaterTemp:       reading.WaterTemp,
+		EcLevel:         reading.EcLevel,
+		PhLevel:         reading.PhLevel,
+		TimeStamp:       reading.TimeStamp,
+		ID:              reading.DeviceID.String(),
+		Metrics:         reading.Metrics,
+		RejectedMetrics: reading.RejectedMetricList(),
+	}
 }
 
 func ReceiveAndSaveData(c *gin.Context) { // saving data from arduino to server
@@ -48,53 +64,45 @@ func ReceiveAndSaveData(c *gin.Context) { // saving data from arduino to server
 		response.GlobalResponse(c, "Invalid JSON data", http.StatusBadRequest, nil)
 		return
 	}
-	parsedUUID, err := uuid.Parse(csvData.ID)
-
-	if err != nil {
-		log.Println(err)
-		response.GlobalResponse(c, "Unexpected Error", http.StatusInternalServerError, nil)
-		return
-	}
 
-	device, err := models.GetDeviceById(initializers.DB, parsedUUID)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			message := fmt.Sprintf("Device not found ID:%s", csvData.ID)
-			log.Println(message)
-			response.GlobalResponse(c, message, http.StatusNotFound, nil)
-		} else {
-			message := fmt.Sprintf("Failed to retrieve device ID:%s", csvData.ID)
-			log.Println(message)
-			response.GlobalResponse(c, message, http.StatusInternalServerError, nil)
+	// The path only has room for the built-in metrics, any other defined metric is sent as a query parameter.
+	for name, values := range c.Request.URL.Query() {
+		value, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			response.GlobalResponse(c, fmt.Sprintf("Invalid %s value", name), http.StatusBadRequest, nil)
+			return
 		}
-		return
+		if csvData.Metrics == nil {
+			csvData.Metrics = make(map[string]float64)
+		}
+		csvData.Metrics[name] = value
 	}
 
-	if device.UmkmDataId == nil {
-		message := fmt.Sprintf("Device not associated with any user ID:%s", csvData.ID)
-		log.Println(message)
-		response.GlobalResponse(c, message, http.StatusBadRequest, nil)
+	// Path parameters carry the reading itself, so a retrying device passes its message ID as a header.
+	csvData.MessageID = c.GetHeader("X-Message-Id")
+	if len(csvData.MessageID) > 128 {
+		response.GlobalResponse(c, "Message ID cannot be longer than 128 characters", http.StatusBadRequest, nil)
 		return
 	}
 
-	csvBytes, err := toCSV(csvData)
+	device, err := getDeviceByAuth(c)
 	if err != nil {
-		message := fmt.Sprintf("Failed to convert data to CSV ID:%s", csvData.ID)
-		log.Println(message)
-		response.GlobalResponse(c, message, http.StatusInternalServerError, nil)
+		response.GlobalResponse(c, err.Error(), http.StatusUnauthorized, nil)
+		return
+	}
+	if csvData.ID != device.ID.String() {
+		response.GlobalResponse(c, "Reading does not belong to the signing device", http.StatusForbidden, nil)
 		return
 	}
 
-	err = models.SaveCSVToDevice(initializers.DB, csvBytes, parsedUUID)
+	err, message, status := saveDeviceReading(csvData)
 	if err != nil {
-		message := fmt.Sprintf("Failed to save data to database ID:%s", csvData.ID)
 		log.Println(message)
-		response.GlobalResponse(c, message, http.StatusInternalServerError, nil)
+		response.GlobalResponse(c, message, status, nil)
 		return
 	}
 
-	message := "Successfully appended data to CSV"
-	response.GlobalResponse(c, message, http.StatusOK, nil)
+	response.GlobalResponse(c, message, status, nil)
 }
 
 func GetMonitoringData(c *gin.Context) {
@@ -117,6 +125,11 @@ func GetMonitoringData(c *gin.Context) {
 		return
 	}
 
+	if req.Start != "" || req.End != "" || req.Cursor != "" {
+		getMonitoringRange(c, user.ID, deviceID, req)
+		return
+	}
+
 	if req.Date == "" || req.Interval == "" {
 		now := time.Now().UTC().Format(time.RFC3339)
 		targetDate, err = time.Parse(time.RFC3339, now)
@@ -154,12 +167,9 @@ func GetMonitoringData(c *gin.Context) {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			response.GlobalResponse(c, "Cannot find the device", 404, nil)
 			return
-		} else if !errors.Is(err, io.EOF) {
-			log.Println(err.Error())
-			response.GlobalResponse(c, "Failed to retrieve CSV data", http.StatusInternalServerError, nil)
-			return
 		}
-		response.GlobalResponse(c, "Empty", http.StatusOK, csvData)
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve CSV data", http.StatusInternalServerError, nil)
 		return
 	}
 
@@ -222,23 +232,19 @@ func parseCSVData(data []byte) ([]request.CSVData, error) {
 	return records, nil
 }
 
-func filterCSVData(records []request.CSVData, targetDate time.Time, interval time.Duration) []request.CSVData {
-	var filteredRecords []request.CSVData
-
-	for _, record := range records {
-		if record.TimeStamp.After(targetDate.Add(-interval)) && record.TimeStamp.Before(targetDate.Add(1*time.Second)) {
-			filteredRecords = append(filteredRecords, record)
-		}
-	}
-
-	return filteredRecords
-}
-
+// writeFilteredCSVData writes the built-in metrics in their usual columns, followed by one column per
+// other defined metric, left empty where a reading did not measure it.
 func writeFilteredCSVData(records []request.CSVData) (string, error) {
 	var buf bytes.Buffer
 	w := csv.NewWriter(&buf)
 
-	headers := []string{"OxygenLevel", "WaterTemp", "EcLevel", "PhLevel", "TimeStamp", "ID"}
+	names, err := definedMetricNames()
+	if err != nil {
+		return "", err
+	}
+	extraMetrics := names[len(models.ReadingMetrics):]
+
+	headers := append([]string{"OxygenLevel", "WaterTemp", "EcLevel", "PhLevel", "TimeStamp", "ID"}, extraMetrics...)
 	if err := w.Write(headers); err != nil {
 		return "", err
 	}
@@ -252,6 +258,21 @@ func writeFilteredCSVData(records []request.CSVData) (string, error) {
 			record.TimeStamp.Format(time.RFC3339),
 			record.ID,
 		}
+		// Rejected built-in values were zeroed when the reading was stored; they stay empty like missing metrics.
+		for _, name := range record.RejectedMetrics {
+			for i, builtin := range models.ReadingMetrics {
+				if name == builtin {
+					row[i] = ""
+				}
+			}
+		}
+		for _, name := range extraMetrics {
+			if value, ok := record.Metrics[name]; ok {
+				row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
 		if err := w.Write(row); err != nil {
 			return "", err
 		}
@@ -266,20 +287,22 @@ func writeFilteredCSVData(records []request.CSVData) (string, error) {
 }
 
 func GetDeviceCsvData(userID, deviceID uuid.UUID, targetDate time.Time, interval time.Duration) (string, error) {
-	var device models.Device
-
-	if err := initializers.DB.Where("id = ? AND umkm_data_id = ?", deviceID, userID).First(&device).Error; err != nil {
+	device, _, err := models.GetAccessibleDevice(initializers.DB, userID, deviceID, models.RoleViewer)
+	if err != nil {
 		return "", err
 	}
 
-	records, err := parseCSVData(device.Data)
+	readings, err := models.GetDeviceReadings(initializers.DB, device.ID, targetDate.Add(-interval), targetDate.Add(1*time.Second))
 	if err != nil {
 		return "", err
 	}
 
-	filteredRecords := filterCSVData(records, targetDate, interval)
+	var records []request.CSVData
+	for _, reading := range readings {
+		records = append(records, toCSVData(reading))
+	}
 
-	filteredCSV, err := writeFilteredCSVData(filteredRecords)
+	filteredCSV, err := writeFilteredCSVData(records)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExportMaxSpan = 366 * 24 * time.Hour
+	exportPageSize       = 1000
+	exportSheetName      = "Readings"
+	maxExcelRows         = 1048576
+)
+
+type exportColumn func(reading *models.DeviceReading, deviceNames map[uuid.UUID]string) interface{}
+
+var exportColumns = map[string]exportColumn{
+	"oxygen_level": builtinExportColumn("oxygen_level", func(r *models.DeviceReading) float32 { return r.OxygenLevel }),
+	"water_temp":   builtinExportColumn("water_temp", func(r *models.DeviceReading) float32 { return r.WaterTemp }),
+	"ec_level":     builtinExportColumn("ec_level", func(r *models.DeviceReading) float32 { return r.EcLevel }),
+	"ph_level":     builtinExportColumn("ph_level", func(r *models.DeviceReading) float32 { return r.PhLevel }),
+	"time_stamp": func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} {
+		return r.TimeStamp.UTC().Format(time.RFC3339)
+	},
+	"id":          func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} { return r.DeviceID.String() },
+	"device_name": func(r *models.DeviceReading, names map[uuid.UUID]string) interface{} { return names[r.DeviceID] },
+	"quality":     func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} { return r.QualityOrGood() },
+	"flagged_metrics": func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} {
+		return r.FlaggedMetrics
+	},
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportMaxSpan reads EXPORT_MAX_SPAN (a Go duration such as 8784h) and falls back to 366 days.
+func exportMaxSpan() time.Duration {
+	if value := os.Getenv("EXPORT_MAX_SPAN"); value != "" {
+		span, err := time.ParseDuration(value)
+		if err == nil && span > 0 {
+			return span
+		}
+		log.Println("Invalid EXPORT_MAX_SPAN, using default:", value)
+	}
+	return defaultExportMaxSpan
+}
+
+// builtinExportColumn reads a reading column. A rejected value exports as an empty value.
+func builtinExportColumn(metric string, column func(r *models.DeviceReading) float32) exportColumn {
+	return func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} {
+		if r.IsMetricRejected(metric) {
+			return nil
+		}
+		return column(r)
+	}
+}
+
+// metricExportColumn reads a metric that is not a reading column. Readings without it export an empty value.
+func metricExportColumn(metric string) exportColumn {
+	return func(r *models.DeviceReading, _ map[uuid.UUID]string) interface{} {
+		if value, ok := r.MetricValue(metric); ok {
+			return value
+		}
+		return nil
+	}
+}
+
+// defaultExportColumns follows the legacy device CSV header, with any other defined metric before
+// the time stamp and the quality flag last, so exported files can be imported again.
+func defaultExportColumns() (string, error) {
+	metrics, err := definedMetricNames()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append(metrics, "time_stamp", "id", "quality"), ","), nil
+}
+
+// parseExportColumns validates a comma separated column list. The order given is the order written.
+func parseExportColumns(value string) ([]string, []exportColumn, error) {
+	var err error
+	if strings.TrimSpace(value) == "" {
+		if value, err = defaultExportColumns(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var columns []string
+	var readers []exportColumn
+	seen := make(map[string]bool)
+	for _, column := range strings.Split(value, ",") {
+		column = strings.TrimSpace(column)
+		reader, ok := exportColumns[column]
+		if !ok {
+			defined, err := isDefinedMetric(column)
+			if err != nil {
+				return nil, nil, err
+			} else if !defined {
+				return nil, nil, fmt.Errorf("Unknown column %s", column)
+			}
+			reader = metricExportColumn(column)
+		}
+		if seen[column] {
+			return nil, nil, fmt.Errorf("Duplicate column %s", column)
+		}
+		seen[column] = true
+		columns = append(columns, column)
+		readers = append(readers, reader)
+	}
+	return columns, readers, nil
+}
+
+type readingExportWriter interface {
+	writeHeader(columns []string) error
+	writeRow(columns []string, values []interface{}) error
+	flush() error
+	close() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) writeHeader(columns []string) error {
+	return e.w.Write(columns)
+}
+
+func (e *csvExportWriter) writeRow(_ []string, values []interface{}) error {
+	row := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			row[i] = ""
+		case float32:
+			row[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+		case float64:
+			row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvExportWriter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportWriter) close() error {
+	return e.flush()
+}
+
+// ndjsonExportWriter writes each object by hand so its keys keep the requested column order.
+type ndjsonExportWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (e *ndjsonExportWriter) writeHeader(_ []string) error {
+	return nil
+}
+
+func (e *ndjsonExportWriter) writeRow(columns []string, values []interface{}) error {
+	e.buf.Reset()
+	e.buf.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		e.buf.Write(key)
+		e.buf.WriteByte(':')
+		e.buf.Write(value)
+	}
+	e.buf.WriteString("}\n")
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *ndjsonExportWriter) flush() error {
+	return nil
+}
+
+func (e *ndjsonExportWriter) close() error {
+	return nil
+}
+
+// xlsxExportWriter keeps rows in the excelize stream writer, which spills to disk, and writes the
+// workbook out on close since the zip directory of an xlsx file can only be written at the end.
+type xlsxExportWriter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXlsxExportWriter(w io.Writer) (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName("Sheet1", exportSheetName); err != nil {
+		return nil, err
+	}
+	stream, err := file.NewStreamWriter(exportSheetName)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{w: w, file: file, stream: stream}, nil
+}
+
+func (e *xlsxExportWriter) writeHeader(columns []string) error {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	return e.writeRow(columns, values)
+}
+
+func (e *xlsxExportWriter) writeRow(_ []string, values []interface{}) error {
+	e.row++
+	if e.row > maxExcelRows {
+		return errors.New("export exceeds the xlsx row limit")
+	}
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	return e.stream.SetRow(cell, values)
+}
+
+func (e *xlsxExportWriter) flush() error {
+	return nil
+}
+
+func (e *xlsxExportWriter) close() error {
+	defer e.file.Close()
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	return e.file.Write(e.w)
+}
+
+func newReadingExportWriter(format string, w io.Writer) (readingExportWriter, error) {
+	switch format {
+	case "csv":
+		return &csvExportWriter{w: csv.NewWriter(w)}, nil
+	case "ndjson":
+		return &ndjsonExportWriter{w: w}, nil
+	case "xlsx":
+		return newXlsxExportWriter(w)
+	}
+	return nil, fmt.Errorf("Unknown format %s", format)
+}
+
+// exportReadings streams the readings of devices page by page, so the response never holds the full range in memory.
+func exportReadings(c *gin.Context, devices []models.Device, name string, req request.ExportRequest) {
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		response.GlobalResponse(c, "Invalid format. Use csv, ndjson or xlsx", http.StatusBadRequest, nil)
+		return
+	}
+
+	columns, readers, err := parseExportColumns(req.Columns)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	start, end, err := parseTimeRange(req.Start, req.End, exportMaxSpan())
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	deviceIDs := make([]uuid.UUID, 0, len(devices))
+	deviceNames := make(map[uuid.UUID]string, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+		deviceNames[device.ID] = device.Name
+	}
+
+	writer, err := newReadingExportWriter(format, c.Writer)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to prepare export", http.StatusInternalServerError, nil)
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%s_%s.%s", name, start.UTC().Format("20060102T150405Z"), end.UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	if err := writeReadingExport(c, writer, deviceIDs, deviceNames, columns, readers, start, end); err != nil {
+		// The status line is already sent, so the client only sees a truncated file.
+		log.Printf("Failed to export readings for %s: %v\n", name, err)
+	}
+}
+
+func writeReadingExport(c *gin.Context, writer readingExportWriter, deviceIDs []uuid.UUID, deviceNames map[uuid.UUID]string, columns []string, readers []exportColumn, start time.Time, end time.Time) error {
+	if err := writer.writeHeader(columns); err != nil {
+		return err
+	}
+
+	var afterTime *time.Time
+	var afterID uuid.UUID
+	values := make([]interface{}, len(columns))
+	for {
+		readings, err := models.GetReadingsForDevicesPage(initializers.DB, deviceIDs, start, end, afterTime, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range readings {
+			for j, reader := range readers {
+				values[j] = reader(&readings[i], deviceNames)
+			}
+			if err := writer.writeRow(columns, values); err != nil {
+				return err
+			}
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+
+		if len(readings) < exportPageSize {
+			break
+		}
+		last := readings[len(readings)-1]
+		afterTime, afterID = &last.TimeStamp, last.ID
+
+		if c.Request.Context().Err() != nil {
+			return c.Request.Context().Err()
+		}
+	}
+	return writer.close()
+}
+
+func ExportDeviceReadings(c *gin.Context) {
+	var req request.ExportRequest
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.BindQuery(&req); err != nil {
+		response.GlobalResponse(c, "Invalid query parameters", http.StatusBadRequest, nil)
+		return
+	}
+
+	device, ok := getAccessibleDevice(c, user.ID, deviceID, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	exportReadings(c, []models.Device{*device}, "device_"+device.ID.String(), req)
+}
+
+func ExportGroupReadings(c *gin.Context) {
+	var req request.ExportRequest
+
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid group ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.BindQuery(&req); err != nil {
+		response.GlobalResponse(c, "Invalid query parameters", http.StatusBadRequest, nil)
+		return
+	}
+
+	group, ok := getAccessibleGroup(c, user.ID, groupID, models.RoleViewer)
+	if !ok {
+		return
+	}
+	devices, err := models.GetUserGroupDevices(initializers.DB, group.UmkmDataId, group.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve group", http.StatusInternalServerError, nil)
+		return
+	}
+
+	exportReadings(c, devices, "group_"+groupID.String(), req)
+}
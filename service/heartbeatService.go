@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultDeviceOfflineAfter = 15 * time.Minute
+
+// deviceOfflineAfter reads DEVICE_OFFLINE_AFTER (a Go duration such as 15m) and falls back to 15 minutes.
+func deviceOfflineAfter() time.Duration {
+	if value := os.Getenv("DEVICE_OFFLINE_AFTER"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err == nil && window > 0 {
+			return window
+		}
+		log.Println("Invalid DEVICE_OFFLINE_AFTER, using default:", value)
+	}
+	return defaultDeviceOfflineAfter
+}
+
+// recordDeviceHeartbeat updates the last-seen time and raises a resolved offline event
+// when a device that was marked offline reports again.
+func recordDeviceHeartbeat(device *models.Device) []models.AlertEvent {
+	now := time.Now()
+	wasOffline, err := models.TouchDevice(initializers.DB, device.ID, now)
+	if err != nil {
+		log.Println("Failed to update device last seen:", err)
+		return nil
+	}
+	// A device that never reported before is not coming back from an outage.
+	if !wasOffline || device.LastSeenAt == nil {
+		return nil
+	}
+
+	event := offlineAlertEvent(device, models.AlertStatusResolved, now)
+	if err := models.CreateAlertEvent(initializers.DB, &event); err != nil {
+		log.Println("Failed to save device online event:", err)
+		return nil
+	}
+	return []models.AlertEvent{event}
+}
+
+func offlineAlertEvent(device *models.Device, status models.AlertStatus, at time.Time) models.AlertEvent {
+	message := fmt.Sprintf("Device %s is back online", device.Name)
+	if status == models.AlertStatusFiring {
+		lastSeen := "never"
+		if device.LastSeenAt != nil {
+			lastSeen = device.LastSeenAt.Format(time.RFC3339)
+		}
+		message = fmt.Sprintf("Device %s has not reported since %s", device.Name, lastSeen)
+	}
+	return models.AlertEvent{
+		DeviceID:   device.ID,
+		UmkmDataId: *device.UmkmDataId,
+		Status:     status,
+		Metric:     models.AlertMetricOffline,
+		Message:    message,
+		OccurredAt: at,
+	}
+}
+
+func DeviceOfflineCheckScheduler() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		deviceOfflineCheck()
+	}
+}
+
+func deviceOfflineCheck() {
+	now := time.Now()
+	devices, err := models.MarkOfflineDevices(initializers.DB, now.Add(-deviceOfflineAfter()))
+	if err != nil {
+		log.Println("Failed to mark offline devices:", err)
+		return
+	}
+
+	for i := range devices {
+		device := devices[i]
+		event := offlineAlertEvent(&device, models.AlertStatusFiring, now)
+		if err := models.CreateAlertEvent(initializers.DB, &event); err != nil {
+			log.Println("Failed to save device offline event:", err)
+			continue
+		}
+		log.Println(event.Message)
+		handleAlertEvents(&device, []models.AlertEvent{event})
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportFileSize = 20 << 20
+	maxImportRows     = 100000
+	importClockSkew   = 5 * time.Minute
+)
+
+// importRequiredColumns are the columns of the device CSV header that every import needs; id is optional.
+var importRequiredColumns = []string{"oxygen_level", "water_temp", "ec_level", "ph_level", "time_stamp"}
+
+// importIgnoredColumns may appear in files produced by the export and carry nothing to import.
+var importIgnoredColumns = map[string]bool{"device_name": true, "quality": true, "flagged_metrics": true}
+
+type importRow struct {
+	line    int
+	reading models.DeviceReading
+}
+
+// parseImportHeader maps column names to their index and returns the defined metrics the file has
+// besides the built-in ones.
+func parseImportHeader(header []string) (map[string]int, []string, error) {
+	var extraMetrics []string
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+		if name == "time_stamp" || name == "id" || importIgnoredColumns[name] || models.IsBuiltinMetric(name) {
+			continue
+		}
+		defined, err := isDefinedMetric(name)
+		if err != nil {
+			return nil, nil, err
+		} else if !defined {
+			return nil, nil, fmt.Errorf("Unknown column %s", name)
+		}
+		extraMetrics = append(extraMetrics, name)
+	}
+	for _, name := range importRequiredColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("Missing column %s", name)
+		}
+	}
+	return columns, extraMetrics, nil
+}
+
+func parseImportMetric(record []string, columns map[string]int, name string) (float32, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[columns[name]]), 32)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("Invalid %s value %q", name, record[columns[name]])
+	}
+	return float32(value), nil
+}
+
+// parseImportRecord validates a single CSV row against the device it is imported into.
+func parseImportRecord(record []string, columns map[string]int, extraMetrics []string, calibrations calibrationSet, deviceID uuid.UUID, now time.Time) (models.DeviceReading, error) {
+	reading := models.DeviceReading{DeviceID: deviceID}
+
+	for _, name := range importRequiredColumns {
+		if columns[name] >= len(record) {
+			return reading, fmt.Errorf("Missing %s value", name)
+		}
+	}
+
+	var err error
+	if reading.OxygenLevel, err = parseImportMetric(record, columns, "oxygen_level"); err != nil {
+		return reading, err
+	}
+	if reading.WaterTemp, err = parseImportMetric(record, columns, "water_temp"); err != nil {
+		return reading, err
+	}
+	if reading.EcLevel, err = parseImportMetric(record, columns, "ec_level"); err != nil {
+		return reading, err
+	}
+	if reading.PhLevel, err = parseImportMetric(record, columns, "ph_level"); err != nil {
+		return reading, err
+	}
+
+	// Kits report different metrics, so an empty cell means the metric was not measured.
+	for _, name := range extraMetrics {
+		index := columns[name]
+		if index >= len(record) || strings.TrimSpace(record[index]) == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[index]), 64)
+		if err != nil {
+			return reading, fmt.Errorf("Invalid %s value %q", name, record[index])
+		}
+		reading.SetMetricValue(name, value)
+	}
+
+	value := strings.TrimSpace(record[columns["time_stamp"]])
+	reading.TimeStamp, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return reading, fmt.Errorf("Invalid time_stamp %q. Use RFC3339", value)
+	}
+	if reading.TimeStamp.After(now.Add(importClockSkew)) {
+		return reading, fmt.Errorf("time_stamp %s is in the future", value)
+	}
+
+	applyCalibrations(&reading, calibrations)
+	if err := applyMetricDefinitions(&reading); err != nil {
+		return reading, err
+	}
+
+	if index, ok := columns["id"]; ok && index < len(record) {
+		value := strings.TrimSpace(record[index])
+		if value != "" && value != deviceID.String() {
+			return reading, fmt.Errorf("Row belongs to device %s", value)
+		}
+	}
+	return reading, nil
+}
+
+// parseImportFile reads every row of the file and reports the invalid ones instead of stopping at the first.
+func parseImportFile(file io.Reader, deviceID uuid.UUID) ([]importRow, []response.ImportRowResponse, int, error) {
+	var rows []importRow
+	var report []response.ImportRowResponse
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, 0, errors.New("Cannot read the CSV header")
+	}
+	columns, extraMetrics, err := parseImportHeader(header)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	overrides, err := loadPlausibilityRanges(deviceID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	calibrations, err := loadCalibrations(deviceID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[int64]int)
+	var total int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		total++
+		if total > maxImportRows {
+			return nil, nil, total, fmt.Errorf("Cannot import more than %d rows at once", maxImportRows)
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report = append(report, response.ImportRowResponse{Row: parseErr.Line, Status: response.ImportRowInvalid, Message: parseErr.Err.Error()})
+			continue
+		} else if err != nil {
+			return nil, nil, total, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		reading, err := parseImportRecord(record, columns, extraMetrics, calibrations, deviceID, now)
+		if err != nil {
+			report = append(report, response.ImportRowResponse{Row: line, Status: response.ImportRowInvalid, Message: err.Error()})
+			continue
+		}
+		reasons, rejected, err := assessReadingQuality(&reading, overrides)
+		if err != nil {
+			return nil, nil, total, err
+		}
+		if reading.Quality == models.QualityRejected {
+			message := "Rejected: " + strings.Join(reasons, "; ")
+			report = append(report, response.ImportRowResponse{Row: line, Status: response.ImportRowInvalid, Message: message})
+			continue
+		}
+		// Imported files are kept by the user, so rejected values are dropped rather than quarantined.
+		withholdRejectedMetrics(&reading, rejected)
+
+		key := reading.TimeStamp.UnixNano()
+		if first, ok := seen[key]; ok {
+			message := fmt.Sprintf("Same time_stamp as row %d", first)
+			report = append(report, response.ImportRowResponse{Row: line, Status: response.ImportRowDuplicate, Message: message})
+			continue
+		}
+		seen[key] = line
+		rows = append(rows, importRow{line: line, reading: reading})
+	}
+	return rows, report, total, nil
+}
+
+func ImportDeviceReadings(c *gin.Context) {
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user", http.StatusUnauthorized, nil)
+		return
+	}
+
+	device, ok := getAccessibleDevice(c, user.ID, deviceID, models.RoleOperator)
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		response.GlobalResponse(c, "A CSV file is required in the file field", http.StatusBadRequest, nil)
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		response.GlobalResponse(c, "File cannot be larger than 20MB", http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		response.GlobalResponse(c, "Failed to read the uploaded file", http.StatusBadRequest, nil)
+		return
+	}
+	defer file.Close()
+
+	rows, report, total, err := parseImportFile(file, device.ID)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	readings := make([]models.DeviceReading, len(rows))
+	for i := range rows {
+		readings[i] = rows[i].reading
+	}
+	duplicates, err := models.MergeDeviceReadings(initializers.DB, device.ID, readings)
+	if errors.Is(err, utils.ErrDeviceNotRegistered) {
+		response.GlobalResponse(c, "Device not associated with any user", http.StatusBadRequest, nil)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to import readings", http.StatusInternalServerError, nil)
+		return
+	}
+
+	result := response.ImportResultResponse{Total: total}
+	for i, duplicate := range duplicates {
+		if duplicate {
+			report = append(report, response.ImportRowResponse{Row: rows[i].line, Status: response.ImportRowDuplicate, Message: "Device already has a reading at this time_stamp"})
+			continue
+		}
+		result.Imported++
+	}
+	for _, row := range report {
+		if row.Status == response.ImportRowDuplicate {
+			result.Duplicates++
+		} else {
+			result.Failed++
+		}
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Row < report[j].Row })
+	result.Rows = report
+	if result.Rows == nil {
+		result.Rows = []response.ImportRowResponse{}
+	}
+
+	message := fmt.Sprintf("Imported %d of %d rows", result.Imported, total)
+	response.GlobalResponse(c, message, http.StatusOK, result)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const maxReadingBatchSize = 1000
+
+func getDeviceByAuth(c *gin.Context) (*models.Device, error) {
+	value, exists := c.Get("device")
+	if !exists {
+		return nil, errors.New("device not authenticated")
+	}
+	device, ok := value.(models.Device)
+	if !ok {
+		return nil, errors.New("invalid device data")
+	}
+	return &device, nil
+}
+
+// saveDeviceReading is the single storage path for readings sent by devices, whatever transport they use.
+func saveDeviceReading(csvData request.CSVData) (error, string, int) {
+	parsedUUID, err := uuid.Parse(csvData.ID)
+	if err != nil {
+		return err, fmt.Sprintf("Invalid device ID:%s", csvData.ID), http.StatusBadRequest
+	}
+
+	device, err := models.GetDeviceById(initializers.DB, parsedUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return err, fmt.Sprintf("Device not found ID:%s", csvData.ID), http.StatusNotFound
+		}
+		return err, fmt.Sprintf("Failed to retrieve device ID:%s", csvData.ID), http.StatusInternalServerError
+	}
+
+	if device.UmkmDataId == nil {
+		return utils.ErrDeviceNotRegistered, fmt.Sprintf("Device not associated with any user ID:%s", csvData.ID), http.StatusBadRequest
+	}
+
+	reading := toDeviceReading(csvData, parsedUUID)
+	calibrations, err := loadCalibrations(device.ID)
+	if err != nil {
+		return err, fmt.Sprintf("Failed to retrieve calibrations ID:%s", csvData.ID), http.StatusInternalServerError
+	}
+	applyCalibrations(&reading, calibrations)
+	if err := applyMetricDefinitions(&reading); err != nil {
+		return err, fmt.Sprintf("%s ID:%s", err.Error(), csvData.ID), http.StatusBadRequest
+	}
+
+	overrides, err := loadPlausibilityRanges(device.ID)
+	if err != nil {
+		return err, fmt.Sprintf("Failed to retrieve plausibility ranges ID:%s", csvData.ID), http.StatusInternalServerError
+	}
+	reasons, rejected, err := assessReadingQuality(&reading, overrides)
+	if err != nil {
+		return err, fmt.Sprintf("Failed to retrieve metric definitions ID:%s", csvData.ID), http.StatusInternalServerError
+	}
+	if reading.Quality == models.QualityRejected {
+		if err := quarantineReading(&reading, readingMetricValues(&reading), reasons); err != nil {
+			return err, fmt.Sprintf("Failed to save rejected reading ID:%s", csvData.ID), http.StatusInternalServerError
+		}
+		// The device is still reachable even when its probes are not.
+		events := recordDeviceHeartbeat(device)
+		go handleAlertEvents(device, events)
+		message := fmt.Sprintf("Reading rejected: %s ID:%s", strings.Join(reasons, "; "), csvData.ID)
+		return utils.ErrReadingRejected, message, http.StatusUnprocessableEntity
+	}
+
+	// The rejected values are quarantined before the rest is stored, so a failure here makes the device
+	// retry the whole reading. Both steps ignore a reading they already have.
+	if len(rejected) > 0 {
+		if err := quarantineReading(&reading, withholdRejectedMetrics(&reading, rejected), rejectedReasons(rejected)); err != nil {
+			return err, fmt.Sprintf("Failed to save rejected values ID:%s", csvData.ID), http.StatusInternalServerError
+		}
+	}
+
+	err = models.AppendDeviceReading(initializers.DB, &reading)
+	if errors.Is(err, utils.ErrDuplicateReading) {
+		// A retry of a reading that was already stored succeeds without repeating its side effects.
+		return nil, "Reading already stored", http.StatusOK
+	} else if errors.Is(err, utils.ErrDeviceNotRegistered) {
+		return err, fmt.Sprintf("Device not associated with any user ID:%s", csvData.ID), http.StatusBadRequest
+	} else if err != nil {
+		return err, fmt.Sprintf("Failed to save data to database ID:%s", csvData.ID), http.StatusInternalServerError
+	}
+
+	liveReadings.publish(device, &reading)
+
+	events := recordDeviceHeartbeat(device)
+	events = append(events, evaluateAlertRules(device, &reading)...)
+	go func() {
+		dispatchWebhookEvent(device, models.WebhookEventReadingReceived, "Reading received", response.BindReadingToResponse(&reading))
+		handleAlertEvents(device, events)
+	}()
+
+	if len(rejected) > 0 {
+		return nil, fmt.Sprintf("Saved device reading without rejected values: %s", strings.Join(rejectedReasons(rejected), "; ")), http.StatusOK
+	}
+	return nil, "Successfully saved device reading", http.StatusOK
+}
+
+// canRelayReading reports whether the signing device may send a reading for deviceID. A gateway signs the
+// batch with its own secret and may relay readings for any device registered to the same account; devices
+// of other accounts, and unregistered ones, are refused. Answers are cached in relays for the batch.
+func canRelayReading(signer *models.Device, deviceID string, relays map[string]bool) (bool, error) {
+	if deviceID == signer.ID.String() {
+		return true, nil
+	}
+	if allowed, ok := relays[deviceID]; ok {
+		return allowed, nil
+	}
+	if signer.UmkmDataId == nil {
+		relays[deviceID] = false
+		return false, nil
+	}
+
+	id, err := uuid.Parse(deviceID)
+	if err != nil {
+		relays[deviceID] = false
+		return false, nil
+	}
+	device, err := models.GetDeviceById(initializers.DB, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		relays[deviceID] = false
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	relays[deviceID] = device.UmkmDataId != nil && *device.UmkmDataId == *signer.UmkmDataId
+	return relays[deviceID], nil
+}
+
+// ReceiveBatchData stores a batch of readings sent by a gateway. Each reading carries its own device id; see
+// canRelayReading for which devices the signing gateway may report for.
+func ReceiveBatchData(c *gin.Context) {
+	var readings []request.CSVData
+
+	device, err := getDeviceByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusUnauthorized, nil)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		response.GlobalResponse(c, "Failed to read request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := json.Unmarshal(body, &readings); err != nil {
+		response.GlobalResponse(c, "Invalid JSON data, expected an array of readings", http.StatusBadRequest, nil)
+		return
+	}
+
+	if len(readings) == 0 {
+		response.GlobalResponse(c, "Readings cannot be empty", http.StatusBadRequest, nil)
+		return
+	}
+
+	if len(readings) > maxReadingBatchSize {
+		message := fmt.Sprintf("Cannot send more than %d readings at once", maxReadingBatchSize)
+		response.GlobalResponse(c, message, http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+
+	results := make([]response.ReadingResultResponse, 0, len(readings))
+	relays := make(map[string]bool)
+	var saved int
+	for i, csvData := range readings {
+		result := response.ReadingResultResponse{
+			Index:    i,
+			DeviceID: csvData.ID,
+		}
+
+		if err := binding.Validator.ValidateStruct(&csvData); err != nil {
+			result.Status = http.StatusBadRequest
+			result.Message = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		relayed, err := canRelayReading(device, csvData.ID, relays)
+		if err != nil {
+			log.Println(err.Error())
+			result.Status = http.StatusInternalServerError
+			result.Message = fmt.Sprintf("Failed to retrieve device ID:%s", csvData.ID)
+			results = append(results, result)
+			continue
+		} else if !relayed {
+			result.Status = http.StatusForbidden
+			result.Message = "Reading does not belong to the signing device or its account"
+			results = append(results, result)
+			continue
+		}
+
+		err, message, status := saveDeviceReading(csvData)
+		if err != nil {
+			log.Println(message)
+		} else {
+			saved++
+		}
+		result.Status = status
+		result.Message = message
+		results = append(results, result)
+	}
+
+	message := fmt.Sprintf("Saved %d of %d readings", saved, len(readings))
+	response.GlobalResponse(c, message, http.StatusOK, results)
+}
@@ -3,8 +3,10 @@ package service
 import (
 	"crypto/tls"
 	"fmt"
+	"gin-crud/models"
 	"gin-crud/request"
 	gomail "gopkg.in/mail.v2"
+	"html"
 	"io/ioutil"
 	"log"
 	"os"
@@ -89,3 +91,107 @@ func ForgotPasswordMail(emailAddress string, name string, url string) (string, e
 	}
 	return "Successfully sending reset password code to your email", nil
 }
+
+func AlertMail(emailAddress string, alert request.AlertMailRequest) (string, error) {
+	template := "alert_template.html"
+	htmlContent, filePath, err := htmlRenderer(template)
+	if err != nil {
+		log.Println("Error reading HTML file:", err)
+		return "Failed reading HTML file", err
+	}
+
+	// Names come from users and metrics from devices, so they are escaped before going into the HTML.
+	// The subject is plain text and keeps them as they are.
+	deviceName := html.EscapeString(alert.DeviceName)
+	metric := html.EscapeString(alert.Metric)
+
+	heading := "Peringatan Kualitas Air"
+	summary := fmt.Sprintf("Perangkat %s melaporkan nilai %s di luar batas yang Anda tentukan.", deviceName, metric)
+	subject := fmt.Sprintf("[IMON] Alert firing: %s on %s", alert.Metric, alert.DeviceName)
+	if alert.Metric == "offline" {
+		summary = fmt.Sprintf("Perangkat %s tidak mengirimkan data dalam beberapa waktu terakhir.", deviceName)
+		subject = fmt.Sprintf("[IMON] Device offline: %s", alert.DeviceName)
+	}
+	if alert.Status == "resolved" {
+		heading = "Peringatan Telah Pulih"
+		summary = fmt.Sprintf("Nilai %s pada perangkat %s telah kembali normal.", metric, deviceName)
+		subject = fmt.Sprintf("[IMON] Alert resolved: %s on %s", alert.Metric, alert.DeviceName)
+		if alert.Metric == "offline" {
+			summary = fmt.Sprintf("Perangkat %s kembali mengirimkan data.", deviceName)
+			subject = fmt.Sprintf("[IMON] Device online: %s", alert.DeviceName)
+		}
+	}
+
+	htmlBody := fmt.Sprintf(string(htmlContent), filepath.Base(filePath), heading, html.EscapeString(alert.Name), summary,
+		deviceName, html.EscapeString(alert.GroupName), metric, html.EscapeString(alert.Value),
+		html.EscapeString(alert.Condition), alert.OccurredAt)
+	mailRequest := request.EmailRequest{
+		EmailAddressToSend: emailAddress,
+		Subject:            subject,
+		ImagePath:          filePath,
+		HtmlBody:           htmlBody,
+	}
+	_, err = mailSender(mailRequest)
+	if err != nil {
+		log.Println("Failed to send mail: " + err.Error())
+		return "Failed to send the email", err
+	}
+	return "Successfully sending alert notification to your email", nil
+}
+
+func DeviceTransferMail(emailAddress string, transfer *models.DeviceTransfer, url string) (string, error) {
+	template := "device_transfer_template.html"
+	htmlContent, filePath, err := htmlRenderer(template)
+	if err != nil {
+		log.Println("Error reading HTML file:", err)
+		return "Failed reading HTML file", err
+	}
+
+	history := "Riwayat data perangkat ikut dipindahkan ke akun Anda."
+	if transfer.History == models.TransferHistoryArchive {
+		history = "Riwayat data perangkat tetap disimpan oleh pengirim."
+	}
+	if transfer.Note != "" {
+		history += "<br>Pesan: " + html.EscapeString(transfer.Note)
+	}
+
+	htmlBody := fmt.Sprintf(string(htmlContent), filepath.Base(filePath), html.EscapeString(transfer.ToName),
+		html.EscapeString(transfer.FromName), html.EscapeString(transfer.DeviceName), history,
+		transfer.ExpiresAt.Format("02 Jan 2006 15:04 MST"), url)
+	mailRequest := request.EmailRequest{
+		EmailAddressToSend: emailAddress,
+		Subject:            fmt.Sprintf("[IMON] %s wants to transfer %s to you", transfer.FromName, transfer.DeviceName),
+		ImagePath:          filePath,
+		HtmlBody:           htmlBody,
+	}
+	_, err = mailSender(mailRequest)
+	if err != nil {
+		log.Println("Failed to send mail: " + err.Error())
+		return "Failed to send the email", err
+	}
+	return "Successfully sending device transfer request to the recipient", nil
+}
+
+func OrganizationInvitationMail(invitation *models.OrganizationInvitation, organizationName string, url string) (string, error) {
+	template := "organization_invitation_template.html"
+	htmlContent, filePath, err := htmlRenderer(template)
+	if err != nil {
+		log.Println("Error reading HTML file:", err)
+		return "Failed reading HTML file", err
+	}
+
+	htmlBody := fmt.Sprintf(string(htmlContent), filepath.Base(filePath), html.EscapeString(invitation.InvitedBy),
+		html.EscapeString(organizationName), invitation.Role, invitation.ExpiresAt.Format("02 Jan 2006 15:04 MST"), url)
+	mailRequest := request.EmailRequest{
+		EmailAddressToSend: invitation.Email,
+		Subject:            fmt.Sprintf("[IMON] %s invited you to join %s", invitation.InvitedBy, organizationName),
+		ImagePath:          filePath,
+		HtmlBody:           htmlBody,
+	}
+	_, err = mailSender(mailRequest)
+	if err != nil {
+		log.Println("Failed to send mail: " + err.Error())
+		return "Failed to send the email", err
+	}
+	return "Successfully sending organization invitation", nil
+}
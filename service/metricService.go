@@ -0,0 +1,287 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	metricDefinitionTTL = time.Minute
+	maxMetricPrecision  = 6
+)
+
+var metricNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// reservedMetricNames are reading fields that are not metrics and so cannot be defined as one.
+var reservedMetricNames = map[string]bool{
+	"time_stamp":      true,
+	"quality":         true,
+	"flagged_metrics": true,
+	"id":              true,
+	"device_id":       true,
+	"device_name":     true,
+	"message_id":      true,
+	"metrics":         true,
+}
+
+// metricDefinitionCache keeps the definitions in memory, since every stored reading is checked against them.
+// Changes made through this instance apply at once; other instances pick them up within metricDefinitionTTL.
+var metricDefinitionCache struct {
+	sync.RWMutex
+	definitions map[string]models.MetricDefinition
+	loadedAt    time.Time
+}
+
+func getMetricDefinitions() (map[string]models.MetricDefinition, error) {
+	metricDefinitionCache.RLock()
+	definitions, loadedAt := metricDefinitionCache.definitions, metricDefinitionCache.loadedAt
+	metricDefinitionCache.RUnlock()
+	if definitions != nil && time.Since(loadedAt) < metricDefinitionTTL {
+		return definitions, nil
+	}
+
+	list, err := models.GetMetricDefinitions(initializers.DB)
+	if err != nil {
+		return nil, err
+	}
+	definitions = make(map[string]models.MetricDefinition, len(list))
+	for _, definition := range list {
+		definitions[definition.Name] = definition
+	}
+
+	metricDefinitionCache.Lock()
+	metricDefinitionCache.definitions = definitions
+	metricDefinitionCache.loadedAt = time.Now()
+	metricDefinitionCache.Unlock()
+	return definitions, nil
+}
+
+func invalidateMetricDefinitions() {
+	metricDefinitionCache.Lock()
+	metricDefinitionCache.definitions = nil
+	metricDefinitionCache.Unlock()
+}
+
+// definedMetricNames returns the built-in metrics in CSV column order followed by the other defined metrics by name.
+func definedMetricNames() ([]string, error) {
+	definitions, err := getMetricDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	names := append([]string(nil), models.ReadingMetrics...)
+	var extra []string
+	for name := range definitions {
+		if !models.IsBuiltinMetric(name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(names, extra...), nil
+}
+
+func isDefinedMetric(name string) (bool, error) {
+	if models.IsBuiltinMetric(name) {
+		return true, nil
+	}
+	definitions, err := getMetricDefinitions()
+	if err != nil {
+		return false, err
+	}
+	_, ok := definitions[name]
+	return ok, nil
+}
+
+func roundToPrecision(value float64, precision int) float64 {
+	scale := math.Pow(10, float64(precision))
+	return math.Round(value*scale) / scale
+}
+
+// applyMetricDefinitions rejects metrics that are not defined and values that are not numbers,
+// and rounds every value to the precision of its definition. Ranges are checked by assessReadingQuality.
+func applyMetricDefinitions(reading *models.DeviceReading) error {
+	definitions, err := getMetricDefinitions()
+	if err != nil {
+		return err
+	}
+
+	for name := range reading.Metrics {
+		if models.IsBuiltinMetric(name) {
+			return fmt.Errorf("Metric %s must be sent in its own field", name)
+		}
+		if _, ok := definitions[name]; !ok {
+			return fmt.Errorf("Unknown metric %s", name)
+		}
+	}
+
+	for _, name := range reading.MetricNames() {
+		value, _ := reading.MetricValue(name)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("Invalid %s value", name)
+		}
+	}
+	return roundMetricValues(reading)
+}
+
+// roundMetricValues rounds every defined metric of the reading to the precision of its definition.
+func roundMetricValues(reading *models.DeviceReading) error {
+	definitions, err := getMetricDefinitions()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range reading.MetricNames() {
+		definition, ok := definitions[name]
+		if !ok || definition.Precision == nil {
+			continue
+		}
+		value, _ := reading.MetricValue(name)
+		reading.SetMetricValue(name, roundToPrecision(value, *definition.Precision))
+	}
+	return nil
+}
+
+func validateMetricDefinitionRequest(req request.MetricDefinitionRequest) error {
+	if err := validateMetricRanges(req.MinValue, req.MaxValue, req.SuspectMin, req.SuspectMax); err != nil {
+		return err
+	}
+	if req.Precision != nil && (*req.Precision < 0 || *req.Precision > maxMetricPrecision) {
+		return fmt.Errorf("Precision must be between 0 and %d", maxMetricPrecision)
+	}
+	return nil
+}
+
+func GetMetricDefinitions(c *gin.Context) {
+	definitions, err := models.GetMetricDefinitions(initializers.DB)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve metric definitions", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.MetricDefinitionResponse, 0, len(definitions))
+	for i := range definitions {
+		resp = append(resp, response.BindMetricDefinitionToResponse(&definitions[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved metric definitions", http.StatusOK, resp)
+}
+
+func CreateMetricDefinition(c *gin.Context) {
+	var req request.MetricDefinitionRequest
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if !metricNameRegex.MatchString(req.Name) || reservedMetricNames[req.Name] {
+		response.GlobalResponse(c, "Invalid metric name. Use lowercase letters, digits and underscores", http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateMetricDefinitionRequest(req); err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	if _, err := models.GetMetricDefinitionByName(initializers.DB, req.Name); err == nil {
+		response.GlobalResponse(c, "Metric already defined", http.StatusConflict, nil)
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.GlobalResponse(c, "Failed to retrieve metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+
+	definition := models.MetricDefinition{
+		Name:       req.Name,
+		Unit:       req.Unit,
+		MinValue:   req.MinValue,
+		MaxValue:   req.MaxValue,
+		SuspectMin: req.SuspectMin,
+		SuspectMax: req.SuspectMax,
+		Precision:  req.Precision,
+		IsBuiltin:  models.IsBuiltinMetric(req.Name),
+	}
+	if err := models.SaveMetricDefinition(initializers.DB, &definition); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+	invalidateMetricDefinitions()
+
+	response.GlobalResponse(c, "Successfully created metric definition", http.StatusCreated, response.BindMetricDefinitionToResponse(&definition))
+}
+
+func UpdateMetricDefinition(c *gin.Context) {
+	var req request.MetricDefinitionRequest
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateMetricDefinitionRequest(req); err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	definition, err := models.GetMetricDefinitionByName(initializers.DB, c.Param("name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Metric not found", http.StatusNotFound, nil)
+			return
+		}
+		response.GlobalResponse(c, "Failed to retrieve metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+
+	definition.Unit = req.Unit
+	definition.MinValue = req.MinValue
+	definition.MaxValue = req.MaxValue
+	definition.SuspectMin = req.SuspectMin
+	definition.SuspectMax = req.SuspectMax
+	definition.Precision = req.Precision
+	if err := models.SaveMetricDefinition(initializers.DB, definition); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+	invalidateMetricDefinitions()
+
+	response.GlobalResponse(c, "Successfully updated metric definition", http.StatusOK, response.BindMetricDefinitionToResponse(definition))
+}
+
+// DeleteMetricDefinition stops devices from reporting a metric. Values already stored are kept.
+func DeleteMetricDefinition(c *gin.Context) {
+	definition, err := models.GetMetricDefinitionByName(initializers.DB, c.Param("name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Metric not found", http.StatusNotFound, nil)
+			return
+		}
+		response.GlobalResponse(c, "Failed to retrieve metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if definition.IsBuiltin {
+		response.GlobalResponse(c, "Built-in metrics cannot be deleted", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := models.DeleteMetricDefinition(initializers.DB, definition); err != nil {
+		response.GlobalResponse(c, "Failed to delete metric definition", http.StatusInternalServerError, nil)
+		return
+	}
+	invalidateMetricDefinitions()
+
+	response.GlobalResponse(c, "Successfully deleted metric definition", http.StatusOK, nil)
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMonitoringMaxSpan = 7 * 24 * time.Hour
+	defaultMonitoringLimit   = 1000
+	maxMonitoringLimit       = 5000
+)
+
+// monitoringMaxSpan reads MONITORING_MAX_SPAN (a Go duration such as 168h) and falls back to seven days.
+func monitoringMaxSpan() time.Duration {
+	if value := os.Getenv("MONITORING_MAX_SPAN"); value != "" {
+		span, err := time.ParseDuration(value)
+		if err == nil && span > 0 {
+			return span
+		}
+		log.Println("Invalid MONITORING_MAX_SPAN, using default:", value)
+	}
+	return defaultMonitoringMaxSpan
+}
+
+// parseTimeRange parses RFC3339 start and end values. An empty end means now.
+func parseTimeRange(startValue string, endValue string, maxSpan time.Duration) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+
+	if startValue == "" {
+		return start, end, errors.New("Start cannot be empty")
+	}
+	start, err = time.Parse(time.RFC3339, startValue)
+	if err != nil {
+		return start, end, errors.New("Invalid start format. Use RFC3339")
+	}
+
+	if endValue == "" {
+		end = time.Now().UTC()
+	} else {
+		end, err = time.Parse(time.RFC3339, endValue)
+		if err != nil {
+			return start, end, errors.New("Invalid end format. Use RFC3339")
+		}
+	}
+
+	if !end.After(start) {
+		return start, end, errors.New("End must be after start")
+	}
+	if end.Sub(start) > maxSpan {
+		return start, end, fmt.Errorf("Range cannot be longer than %s", maxSpan)
+	}
+	return start, end, nil
+}
+
+func encodeReadingCursor(reading models.DeviceReading) string {
+	value := reading.TimeStamp.UTC().Format(time.RFC3339Nano) + "|" + reading.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeReadingCursor(cursor string) (*time.Time, uuid.UUID, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	parts := strings.SplitN(string(value), "|", 2)
+	if len(parts) != 2 {
+		return nil, uuid.Nil, errors.New("malformed cursor")
+	}
+	timeStamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return &timeStamp, id, nil
+}
+
+func getMonitoringRange(c *gin.Context, userID uuid.UUID, deviceID uuid.UUID, req request.MonitoringRequest) {
+	start, end, err := parseTimeRange(req.Start, req.End, monitoringMaxSpan())
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultMonitoringLimit
+	} else if limit > maxMonitoringLimit {
+		limit = maxMonitoringLimit
+	}
+
+	var afterTime *time.Time
+	var afterID uuid.UUID
+	if req.Cursor != "" {
+		afterTime, afterID, err = decodeReadingCursor(req.Cursor)
+		if err != nil {
+			response.GlobalResponse(c, "Invalid cursor", http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	device, ok := getAccessibleDevice(c, userID, deviceID, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	readings, err := models.GetDeviceReadingsPage(initializers.DB, device.ID, start, end, afterTime, afterID, limit+1)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve monitoring data", http.StatusInternalServerError, nil)
+		return
+	}
+
+	var resp response.MonitoringResponse
+	if len(readings) > limit {
+		readings = readings[:limit]
+		resp.NextCursor = encodeReadingCursor(readings[len(readings)-1])
+	}
+
+	records := make([]request.CSVData, 0, len(readings))
+	resp.Records = make([]response.ReadingResponse, 0, len(readings))
+	for i := range readings {
+		records = append(records, toCSVData(readings[i]))
+		resp.Records = append(resp.Records, response.BindReadingToResponse(&readings[i]))
+	}
+
+	resp.CSV, err = writeFilteredCSVData(records)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to write CSV data", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully retrieved monitoring data", http.StatusOK, resp)
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/utils"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultTelemetryTopic = "devices/+/telemetry"
+
+// StartMQTTListener subscribes to device telemetry when MQTT_BROKER_URL is set, e.g. tcp://localhost:1883.
+// Messages go through the same signature check and storage path as the HTTP gateway.
+func StartMQTTListener() {
+	brokerURL := os.Getenv("MQTT_BROKER_URL")
+	if brokerURL == "" {
+		log.Println("MQTT_BROKER_URL is not set, MQTT listener disabled")
+		return
+	}
+	connectMQTTListener(brokerURL)
+}
+
+// connectMQTTListener connects to the broker and subscribes on every (re)connect. The client keeps retrying
+// in the background when the first attempt fails.
+func connectMQTTListener(brokerURL string) mqtt.Client {
+	topic := os.Getenv("MQTT_TELEMETRY_TOPIC")
+	if topic == "" {
+		topic = defaultTelemetryTopic
+	}
+
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "imon-server-" + uuid.NewString()
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second)
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(topic, 1, handleTelemetryMessage)
+		if token.Wait() && token.Error() != nil {
+			log.Printf("Failed to subscribe to %s: %v\n", topic, token.Error())
+			return
+		}
+		log.Printf("Subscribed to MQTT topic %s\n", topic)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Println("MQTT connection lost:", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Println("Failed to connect to MQTT broker:", token.Error())
+	}
+	return client
+}
+
+func handleTelemetryMessage(client mqtt.Client, msg mqtt.Message) {
+	if err := processTelemetryMessage(msg.Topic(), msg.Payload()); err != nil {
+		log.Printf("Rejected MQTT message on %s: %v\n", msg.Topic(), err)
+	}
+}
+
+// deviceIDFromTopic reads the device UUID from topics shaped like devices/<uuid>/telemetry.
+func deviceIDFromTopic(topic string) (uuid.UUID, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 3 || parts[0] != "devices" {
+		return uuid.Nil, fmt.Errorf("unexpected topic %s", topic)
+	}
+	return uuid.Parse(parts[1])
+}
+
+// telemetryIngester verifies telemetry envelopes and stores their readings. Storage goes through its
+// fields, so the envelope checks can run without a broker or a database.
+type telemetryIngester struct {
+	getDevice   func(deviceID uuid.UUID) (*models.Device, error)
+	useNonce    func(deviceID uuid.UUID, signature string) error
+	saveReading func(csvData request.CSVData) (error, string, int)
+}
+
+var telemetry = telemetryIngester{
+	getDevice: func(deviceID uuid.UUID) (*models.Device, error) {
+		return models.GetDeviceById(initializers.DB, deviceID)
+	},
+	useNonce: func(deviceID uuid.UUID, signature string) error {
+		return models.UseDeviceNonce(initializers.DB, deviceID, signature)
+	},
+	saveReading: saveDeviceReading,
+}
+
+func processTelemetryMessage(topic string, payload []byte) error {
+	return telemetry.ingest(topic, payload)
+}
+
+func (ingester telemetryIngester) ingest(topic string, payload []byte) error {
+	var message request.TelemetryMessage
+
+	deviceID, err := deviceIDFromTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return err
+	}
+
+	device, err := ingester.getDevice(deviceID)
+	if err != nil {
+		return err
+	}
+
+	signed := utils.DeviceSignaturePayload(message.Timestamp, "MQTT", topic, "", message.Data)
+	if err := utils.VerifyDeviceSignature(device.SecretKey, message.Timestamp, message.Signature, signed); err != nil {
+		return err
+	}
+	if err := ingester.useNonce(device.ID, message.Signature); err != nil {
+		return err
+	}
+
+	readings, err := parseTelemetryData(message.Data)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, csvData := range readings {
+		if csvData.ID == "" {
+			csvData.ID = device.ID.String()
+		}
+		if csvData.ID != device.ID.String() {
+			log.Printf("Reading for %s does not belong to the signing device %s\n", csvData.ID, device.ID)
+			failed++
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(&csvData); err != nil {
+			log.Printf("Invalid reading from device %s: %v\n", device.ID, err)
+			failed++
+			continue
+		}
+		if err, message, _ := ingester.saveReading(csvData); err != nil {
+			log.Println(message)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d readings were not saved", failed, len(readings))
+	}
+	return nil
+}
+
+func parseTelemetryData(data json.RawMessage) ([]request.CSVData, error) {
+	var readings []request.CSVData
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return nil, errors.New("telemetry data cannot be empty")
+	}
+
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &readings); err != nil {
+			return nil, err
+		}
+	} else {
+		var reading request.CSVData
+		if err := json.Unmarshal(data, &reading); err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+
+	if len(readings) > maxReadingBatchSize {
+		return nil, fmt.Errorf("cannot send more than %d readings at once", maxReadingBatchSize)
+	}
+	return readings, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const testDeviceSecret = "device-secret"
+
+// newTestIngester keeps devices, nonces and saved readings in memory.
+func newTestIngester(devices ...*models.Device) (*telemetryIngester, *[]request.CSVData) {
+	saved := &[]request.CSVData{}
+	nonces := map[string]bool{}
+	return &telemetryIngester{
+		getDevice: func(deviceID uuid.UUID) (*models.Device, error) {
+			for _, device := range devices {
+				if device.ID == deviceID {
+					return device, nil
+				}
+			}
+			return nil, gorm.ErrRecordNotFound
+		},
+		useNonce: func(deviceID uuid.UUID, signature string) error {
+			key := deviceID.String() + strings.ToLower(signature)
+			if nonces[key] {
+				return utils.ErrSignatureReplayed
+			}
+			nonces[key] = true
+			return nil
+		},
+		saveReading: func(csvData request.CSVData) (error, string, int) {
+			*saved = append(*saved, csvData)
+			return nil, "", http.StatusOK
+		},
+	}, saved
+}
+
+func telemetryTopic(deviceID uuid.UUID) string {
+	return "devices/" + deviceID.String() + "/telemetry"
+}
+
+// signedTelemetry builds the envelope a device with secret publishes on topic.
+func signedTelemetry(t *testing.T, secret string, topic string, data string) []byte {
+	t.Helper()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := utils.SignPayload(secret, utils.DeviceSignaturePayload(timestamp, "MQTT", topic, "", []byte(data)))
+	payload, err := json.Marshal(request.TelemetryMessage{Timestamp: timestamp, Signature: signature, Data: json.RawMessage(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func newTelemetryDevice() *models.Device {
+	return &models.Device{ID: uuid.New(), SecretKey: testDeviceSecret}
+}
+
+const telemetryReading = `{"time_stamp":"2024-05-01T10:00:00Z","ph_level":7.1}`
+
+func TestTelemetryStoresSignedReadings(t *testing.T) {
+	device := newTelemetryDevice()
+	ingester, saved := newTestIngester(device)
+	topic := telemetryTopic(device.ID)
+
+	if err := ingester.ingest(topic, signedTelemetry(t, testDeviceSecret, topic, telemetryReading)); err != nil {
+		t.Fatal("signed message rejected:", err)
+	}
+	if len(*saved) != 1 || (*saved)[0].ID != device.ID.String() || (*saved)[0].PhLevel != 7.1 {
+		t.Fatalf("saved = %+v", *saved)
+	}
+}
+
+func TestTelemetryRejectsBadSignature(t *testing.T) {
+	device := newTelemetryDevice()
+	ingester, saved := newTestIngester(device)
+	topic := telemetryTopic(device.ID)
+
+	for name, payload := range map[string][]byte{
+		"wrong secret": signedTelemetry(t, "other-secret", topic, telemetryReading),
+		"other topic":  signedTelemetry(t, testDeviceSecret, telemetryTopic(uuid.New()), telemetryReading),
+	} {
+		if err := ingester.ingest(topic, payload); !errors.Is(err, utils.ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, want %v", name, err, utils.ErrInvalidSignature)
+		}
+	}
+
+	var message request.TelemetryMessage
+	if err := json.Unmarshal(signedTelemetry(t, testDeviceSecret, topic, telemetryReading), &message); err != nil {
+		t.Fatal(err)
+	}
+	message.Data = json.RawMessage(`{"time_stamp":"2024-05-01T10:00:00Z","ph_level":3}`)
+	tampered, _ := json.Marshal(message)
+	if err := ingester.ingest(topic, tampered); !errors.Is(err, utils.ErrInvalidSignature) {
+		t.Errorf("tampered data: err = %v, want %v", err, utils.ErrInvalidSignature)
+	}
+
+	if len(*saved) != 0 {
+		t.Fatalf("%d readings saved from badly signed messages", len(*saved))
+	}
+}
+
+func TestTelemetryRejectsReplayedNonce(t *testing.T) {
+	device := newTelemetryDevice()
+	ingester, saved := newTestIngester(device)
+	topic := telemetryTopic(device.ID)
+	payload := signedTelemetry(t, testDeviceSecret, topic, telemetryReading)
+
+	if err := ingester.ingest(topic, payload); err != nil {
+		t.Fatal("first delivery rejected:", err)
+	}
+	if err := ingester.ingest(topic, payload); !errors.Is(err, utils.ErrSignatureReplayed) {
+		t.Fatalf("replay: err = %v, want %v", err, utils.ErrSignatureReplayed)
+	}
+
+	var message request.TelemetryMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		t.Fatal(err)
+	}
+	message.Signature = strings.ToUpper(message.Signature)
+	upper, _ := json.Marshal(message)
+	if err := ingester.ingest(topic, upper); !errors.Is(err, utils.ErrSignatureReplayed) {
+		t.Fatalf("replay in upper case: err = %v, want %v", err, utils.ErrSignatureReplayed)
+	}
+
+	if len(*saved) != 1 {
+		t.Fatalf("%d readings saved, want 1", len(*saved))
+	}
+}
+
+func TestTelemetryRejectsUnknownDevice(t *testing.T) {
+	ingester, saved := newTestIngester(newTelemetryDevice())
+	unknown := telemetryTopic(uuid.New())
+
+	if err := ingester.ingest(unknown, signedTelemetry(t, testDeviceSecret, unknown, telemetryReading)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if err := ingester.ingest("devices/not-a-uuid/telemetry", []byte(`{}`)); err == nil {
+		t.Fatal("a topic without a device ID was accepted")
+	}
+	if len(*saved) != 0 {
+		t.Fatalf("%d readings saved for an unknown device", len(*saved))
+	}
+}
+
+// TestMQTTListenerStoresBrokerMessages runs the listener against the broker in MQTT_TEST_BROKER_URL, e.g.
+// tcp://localhost:1883, and is skipped when the variable is not set.
+func TestMQTTListenerStoresBrokerMessages(t *testing.T) {
+	brokerURL := os.Getenv("MQTT_TEST_BROKER_URL")
+	if brokerURL == "" {
+		t.Skip("MQTT_TEST_BROKER_URL is not set")
+	}
+	t.Setenv("MQTT_TELEMETRY_TOPIC", "")
+	t.Setenv("MQTT_CLIENT_ID", "")
+
+	device := newTelemetryDevice()
+	ingester, _ := newTestIngester(device)
+	received := make(chan request.CSVData, 1)
+	ingester.saveReading = func(csvData request.CSVData) (error, string, int) {
+		select {
+		case received <- csvData:
+		default:
+		}
+		return nil, "", http.StatusOK
+	}
+	previous := telemetry
+	telemetry = *ingester
+
+	listener := connectMQTTListener(brokerURL)
+	t.Cleanup(func() {
+		listener.Disconnect(250)
+		telemetry = previous
+	})
+
+	publisher := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(brokerURL).SetClientID("imon-test-" + uuid.NewString()))
+	if token := publisher.Connect(); token.WaitTimeout(10*time.Second) && token.Error() != nil {
+		t.Fatal("Failed to connect publisher:", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	// The listener subscribes once it is connected, so the envelope is published again until it arrives.
+	topic := telemetryTopic(device.ID)
+	timeout := time.After(10 * time.Second)
+	publish := time.NewTicker(250 * time.Millisecond)
+	defer publish.Stop()
+	for {
+		select {
+		case csvData := <-received:
+			if csvData.ID != device.ID.String() || csvData.PhLevel != 7.1 {
+				t.Fatalf("stored %+v", csvData)
+			}
+			return
+		case <-publish.C:
+			token := publisher.Publish(topic, 1, false, signedTelemetry(t, testDeviceSecret, topic, telemetryReading))
+			if token.WaitTimeout(5*time.Second) && token.Error() != nil {
+				t.Fatal("Failed to publish:", token.Error())
+			}
+		case <-timeout:
+			t.Fatal("the published reading never reached the ingester")
+		}
+	}
+}
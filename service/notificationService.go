@@ -0,0 +1,166 @@
+package service
+
+import (
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// alertRecipient is the account an alert event is mailed to, with its notification preference.
+type alertRecipient struct {
+	user       models.UmkmData
+	preference *models.NotificationPreference
+}
+
+// notifyAlertEvents emails firing and resolved alerts to the user who wrote the rule, honouring that
+// user's preferences and the per-rule rate limit. Offline events have no rule author and go to the device owner.
+func notifyAlertEvents(device *models.Device, events []models.AlertEvent) {
+	recipients := map[uuid.UUID]*alertRecipient{}
+	for _, event := range events {
+		recipientID := event.UmkmDataId
+		if event.RuleID == nil {
+			if device.UmkmDataId == nil {
+				continue
+			}
+			recipientID = *device.UmkmDataId
+		}
+
+		recipient, ok := recipients[recipientID]
+		if !ok {
+			recipient = loadAlertRecipient(recipientID)
+			recipients[recipientID] = recipient
+		}
+		if recipient == nil || !recipient.preference.EmailEnabled {
+			continue
+		}
+		if !shouldNotifyAlertEvent(recipient.preference, device, &event) {
+			continue
+		}
+
+		groupName := "-"
+		if device.GroupName != nil {
+			groupName = *device.GroupName
+		}
+		alert := request.AlertMailRequest{
+			Name:       recipient.user.Name,
+			Status:     string(event.Status),
+			DeviceName: device.Name,
+			GroupName:  groupName,
+			Metric:     event.Metric,
+			Value:      strconv.FormatFloat(event.Value, 'f', -1, 64),
+			Condition:  event.Message,
+			OccurredAt: event.OccurredAt.In(time.FixedZone("GMT+7", 7*60*60)).Format("02 Jan 2006 15:04:05 MST"),
+		}
+		if message, err := AlertMail(recipient.user.Email, alert); err != nil {
+			log.Println(message)
+		}
+	}
+}
+
+// loadAlertRecipient returns nil when the user or their preference cannot be loaded.
+func loadAlertRecipient(userID uuid.UUID) *alertRecipient {
+	var recipient alertRecipient
+	if err := initializers.DB.First(&recipient.user, "id = ?", userID).Error; err != nil {
+		log.Println("Failed to retrieve alert recipient:", err)
+		return nil
+	}
+
+	preference, err := models.GetNotificationPreference(initializers.DB, userID)
+	if err != nil {
+		log.Println("Failed to retrieve notification preference:", err)
+		return nil
+	}
+	recipient.preference = preference
+	return &recipient
+}
+
+func shouldNotifyAlertEvent(preference *models.NotificationPreference, device *models.Device, event *models.AlertEvent) bool {
+	if event.RuleID == nil {
+		// Offline events are only raised on a state change, so they need no rate limit.
+		return event.Status == models.AlertStatusFiring || preference.NotifyOnResolved
+	}
+
+	var allowed bool
+	var err error
+	switch event.Status {
+	case models.AlertStatusFiring:
+		interval := time.Duration(preference.MinIntervalMinutes) * time.Minute
+		allowed, err = models.ClaimFiringNotification(initializers.DB, *event.RuleID, device.ID, interval)
+	case models.AlertStatusResolved:
+		if !preference.NotifyOnResolved {
+			return false
+		}
+		allowed, err = models.ClaimResolvedNotification(initializers.DB, *event.RuleID, device.ID)
+	}
+	if err != nil {
+		log.Println("Failed to claim alert notification:", err)
+		return false
+	}
+	return allowed
+}
+
+func GetNotificationPreference(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	preference, err := models.GetNotificationPreference(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve notification preference", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully retrieved notification preference", http.StatusOK, response.BindNotificationPreferenceToResponse(preference))
+}
+
+func UpdateNotificationPreference(c *gin.Context) {
+	var req request.NotificationPreferenceRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	preference, err := models.GetNotificationPreference(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve notification preference", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if req.EmailEnabled != nil {
+		preference.EmailEnabled = *req.EmailEnabled
+	}
+	if req.NotifyOnResolved != nil {
+		preference.NotifyOnResolved = *req.NotifyOnResolved
+	}
+	if req.MinIntervalMinutes != nil {
+		if *req.MinIntervalMinutes < 0 || *req.MinIntervalMinutes > 24*60 {
+			response.GlobalResponse(c, "Minimum interval must be between 0 and 1440 minutes", http.StatusBadRequest, nil)
+			return
+		}
+		preference.MinIntervalMinutes = *req.MinIntervalMinutes
+	}
+
+	if err := models.SaveNotificationPreference(initializers.DB, preference); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to save notification preference", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully updated notification preference", http.StatusOK, response.BindNotificationPreferenceToResponse(preference))
+}
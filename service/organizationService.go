@@ -0,0 +1,471 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	organizationInvitationTTL        = 7 * 24 * time.Hour
+	defaultOrganizationInvitationURL = "https://imon.andamantau.com/organization-invitation/"
+)
+
+// organizationInvitationURL is the page the invitation email links to; it accepts the invitation with the token.
+func organizationInvitationURL(token string) string {
+	base := os.Getenv("ORGANIZATION_INVITE_URL")
+	if base == "" {
+		base = defaultOrganizationInvitationURL
+	}
+	return base + token
+}
+
+func sendOrganizationInvitationMail(invitation *models.OrganizationInvitation, organizationName string) {
+	if _, err := OrganizationInvitationMail(invitation, organizationName, organizationInvitationURL(invitation.Token)); err != nil {
+		log.Printf("Failed to send invitation %s to %s: %v\n", invitation.ID, invitation.Email, err)
+	}
+}
+
+// getUserOrganization resolves the organization in the id path parameter for the signed in user, together
+// with the user's membership role.
+func getUserOrganization(c *gin.Context, minRole string) (*models.Organization, string, *models.UmkmData, bool) {
+	organizationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid organization ID format", http.StatusBadRequest, nil)
+		return nil, "", nil, false
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, "", nil, false
+	}
+
+	organization, role, err := models.GetAccessibleOrganization(initializers.DB, user.ID, organizationID, minRole)
+	if err != nil {
+		respondAccessError(c, err, "Organization not found")
+		return nil, "", nil, false
+	}
+	return organization, role, user, true
+}
+
+// canManageMember reports whether a member holding role may change or remove members holding target. As
+// with shares, only owners hand out or take away the admin and owner roles.
+func canManageMember(role string, target string) bool {
+	if target == models.RoleAdmin || target == models.RoleOwner {
+		return role == models.RoleOwner
+	}
+	return models.RoleAllows(role, models.RoleAdmin)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req request.OrganizationRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		response.GlobalResponse(c, "Organization name cannot be empty", http.StatusBadRequest, nil)
+		return
+	}
+
+	organization := models.Organization{Name: req.Name, Description: req.Description}
+	if err := models.CreateOrganization(initializers.DB, &organization, user); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create organization", http.StatusInternalServerError, nil)
+		return
+	}
+	resp := response.BindOrganizationToResponse(&organization, models.RoleOwner, user.ActiveOrganizationID)
+	response.GlobalResponse(c, "Successfully created organization", http.StatusOK, resp)
+}
+
+// GetOrganizations lists the organizations the signed in user belongs to.
+func GetOrganizations(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	members, err := models.GetUserMemberships(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve organizations", http.StatusInternalServerError, nil)
+		return
+	}
+	roles := make(map[uuid.UUID]string, len(members))
+	organizationIDs := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		roles[member.OrganizationID] = member.Role
+		organizationIDs = append(organizationIDs, member.OrganizationID)
+	}
+
+	resp := make([]response.OrganizationResponse, 0, len(members))
+	if len(organizationIDs) > 0 {
+		organizations, err := models.GetOrganizationsByIds(initializers.DB, organizationIDs)
+		if err != nil {
+			response.GlobalResponse(c, "Failed to retrieve organizations", http.StatusInternalServerError, nil)
+			return
+		}
+		for i := range organizations {
+			resp = append(resp, response.BindOrganizationToResponse(&organizations[i], roles[organizations[i].ID], user.ActiveOrganizationID))
+		}
+	}
+	response.GlobalResponse(c, "Successfully retrieved organizations", http.StatusOK, resp)
+}
+
+func GetOrganizationById(c *gin.Context) {
+	organization, role, user, ok := getUserOrganization(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+	resp := response.BindOrganizationToResponse(organization, role, user.ActiveOrganizationID)
+	response.GlobalResponse(c, "Successfully retrieved organization", http.StatusOK, resp)
+}
+
+func UpdateOrganization(c *gin.Context) {
+	var req request.OrganizationRequest
+
+	organization, role, user, ok := getUserOrganization(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		organization.Name = name
+	}
+	if req.Description != "" {
+		organization.Description = req.Description
+	}
+	if err := models.SaveOrganization(initializers.DB, organization); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update organization", http.StatusInternalServerError, nil)
+		return
+	}
+	resp := response.BindOrganizationToResponse(organization, role, user.ActiveOrganizationID)
+	response.GlobalResponse(c, "Successfully updated organization", http.StatusOK, resp)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	organization, _, _, ok := getUserOrganization(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := models.GetOrganizationMembers(initializers.DB, organization.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve members", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved members", http.StatusOK, response.BindOrganizationMembersToResponse(members))
+}
+
+// getOrganizationMember loads the member in the user_id path parameter.
+func getOrganizationMember(c *gin.Context, organizationID uuid.UUID) (*models.OrganizationMember, bool) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid user ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	member, err := models.GetOrganizationMember(initializers.DB, organizationID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		response.GlobalResponse(c, "Member not found", http.StatusNotFound, nil)
+		return nil, false
+	} else if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve member", http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	return member, true
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	var req request.OrganizationMemberRequest
+
+	organization, role, _, ok := getUserOrganization(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+	if !models.IsOrganizationRole(req.Role) {
+		response.GlobalResponse(c, "Role must be viewer, operator, admin or owner", http.StatusBadRequest, nil)
+		return
+	}
+
+	member, ok := getOrganizationMember(c, organization.ID)
+	if !ok {
+		return
+	}
+	if !canManageMember(role, member.Role) || !canManageMember(role, req.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	err := models.UpdateOrganizationMemberRole(initializers.DB, member, req.Role)
+	if errors.Is(err, utils.ErrLastOrganizationOwner) {
+		response.GlobalResponse(c, "Organization needs at least one owner", http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update member", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully updated member", http.StatusOK, response.BindOrganizationMemberToResponse(member))
+}
+
+// RemoveOrganizationMember takes a member out of the organization. Anyone may also leave an organization.
+func RemoveOrganizationMember(c *gin.Context) {
+	organization, role, user, ok := getUserOrganization(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+	member, ok := getOrganizationMember(c, organization.ID)
+	if !ok {
+		return
+	}
+	if member.UmkmDataId != user.ID && !canManageMember(role, member.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	err := models.RemoveOrganizationMember(initializers.DB, member)
+	if errors.Is(err, utils.ErrLastOrganizationOwner) {
+		response.GlobalResponse(c, "Organization needs at least one owner", http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to remove member", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully removed member", http.StatusOK, nil)
+}
+
+func InviteOrganizationMember(c *gin.Context) {
+	var req request.OrganizationInvitationRequest
+
+	organization, role, user, ok := getUserOrganization(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+	if !models.IsOrganizationRole(req.Role) {
+		response.GlobalResponse(c, "Role must be viewer, operator, admin or owner", http.StatusBadRequest, nil)
+		return
+	}
+	if !canManageMember(role, req.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid email", http.StatusBadRequest, nil)
+		return
+	}
+
+	var invitee models.UmkmData
+	err = initializers.DB.Where("LOWER(email) = LOWER(?)", address.Address).First(&invitee).Error
+	if err == nil {
+		if memberRole, err := models.GetOrganizationRole(initializers.DB, invitee.ID, organization.ID); err != nil {
+			response.GlobalResponse(c, "Failed to retrieve members", http.StatusInternalServerError, nil)
+			return
+		} else if memberRole != "" {
+			response.GlobalResponse(c, "This account is already a member", http.StatusConflict, nil)
+			return
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.GlobalResponse(c, "Failed to retrieve user", http.StatusInternalServerError, nil)
+		return
+	}
+
+	token, err := utils.GenerateSecretKey()
+	if err != nil {
+		response.GlobalResponse(c, "Failed to create invitation", http.StatusInternalServerError, nil)
+		return
+	}
+	invitation := models.OrganizationInvitation{
+		OrganizationID: organization.ID,
+		Email:          address.Address,
+		Role:           req.Role,
+		Token:          token,
+		InvitedByID:    user.ID,
+		InvitedBy:      user.Name,
+		ExpiresAt:      time.Now().Add(organizationInvitationTTL),
+	}
+	if err := models.CreateOrganizationInvitation(initializers.DB, &invitation); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create invitation", http.StatusInternalServerError, nil)
+		return
+	}
+
+	go sendOrganizationInvitationMail(&invitation, organization.Name)
+	response.GlobalResponse(c, "Successfully sent invitation", http.StatusOK, response.BindOrganizationInvitationToResponse(&invitation))
+}
+
+func GetOrganizationInvitations(c *gin.Context) {
+	organization, _, _, ok := getUserOrganization(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := models.GetPendingInvitations(initializers.DB, organization.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve invitations", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved invitations", http.StatusOK, response.BindOrganizationInvitationsToResponse(invitations))
+}
+
+func DeleteOrganizationInvitation(c *gin.Context) {
+	organization, role, _, ok := getUserOrganization(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	invitationID, err := uuid.Parse(c.Param("invitation_id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid invitation ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	invitation, err := models.GetOrganizationInvitation(initializers.DB, organization.ID, invitationID)
+	if err != nil {
+		respondAccessError(c, err, "Invitation not found")
+		return
+	}
+	if !canManageMember(role, invitation.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	if err := models.DeleteOrganizationInvitation(initializers.DB, invitation); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to delete invitation", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully deleted invitation", http.StatusOK, nil)
+}
+
+// AcceptOrganizationInvitation adds the signed in user to the organization. The invitation has to be
+// addressed to the user's email.
+func AcceptOrganizationInvitation(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	member, err := models.AcceptOrganizationInvitation(initializers.DB, c.Param("token"), user)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		response.GlobalResponse(c, "Cannot find the invitation", http.StatusNotFound, nil)
+		return
+	case errors.Is(err, utils.ErrInvitationExpired):
+		response.GlobalResponse(c, "Invitation has expired", http.StatusGone, nil)
+		return
+	case errors.Is(err, utils.ErrInvitationUsed):
+		response.GlobalResponse(c, "Invitation was already accepted", http.StatusConflict, nil)
+		return
+	case err != nil:
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to accept invitation", http.StatusInternalServerError, nil)
+		return
+	}
+
+	organization, err := models.GetOrganizationById(initializers.DB, member.OrganizationID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve organization", http.StatusInternalServerError, nil)
+		return
+	}
+	if user.ActiveOrganizationID == nil {
+		user.ActiveOrganizationID = &organization.ID
+	}
+	resp := response.BindOrganizationToResponse(organization, member.Role, user.ActiveOrganizationID)
+	response.GlobalResponse(c, "Successfully joined organization", http.StatusOK, resp)
+}
+
+// SetActiveOrganization switches the organization whose devices and groups the user works with.
+func SetActiveOrganization(c *gin.Context) {
+	var req request.ActiveOrganizationRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.OrganizationID != nil {
+		if _, ok := getAccessibleOrganization(c, user.ID, *req.OrganizationID, models.RoleViewer); !ok {
+			return
+		}
+	}
+	if err := models.SetActiveOrganization(initializers.DB, user.ID, req.OrganizationID); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to switch organization", http.StatusInternalServerError, nil)
+		return
+	}
+	user.ActiveOrganizationID = req.OrganizationID
+	response.GlobalResponse(c, "Successfully switched organization", http.StatusOK, response.BindUserToResponse(user))
+}
+
+// MigrateUsersToOrganizations gives every account that is not in an organization yet a one-member
+// organization owning its devices and groups. Accounts already in an organization are left alone, so
+// it can run again safely. Every account is attempted; an error is returned when any of them failed.
+//
+// New accounts get their organization at registration, so this is only needed for accounts created
+// before organizations existed or whose organization could not be created then. Accounts without an
+// organization keep working as personal accounts, owning their devices directly.
+func MigrateUsersToOrganizations() error {
+	var users []models.UmkmData
+	if err := initializers.DB.Find(&users).Error; err != nil {
+		return err
+	}
+
+	var migrated, failed int
+	for i := range users {
+		done, err := models.MigrateUserToOrganization(initializers.DB, &users[i])
+		if err != nil {
+			log.Printf("Failed to migrate user %s: %v\n", users[i].ID, err)
+			failed++
+			continue
+		}
+		if done {
+			migrated++
+		}
+	}
+	log.Printf("Migrated %d of %d users to organizations\n", migrated, len(users))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users could not be migrated", failed, len(users))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+const maxProvisioningBatchSize = 10000
+
+// ProvisionDevices creates the devices of a batch in one transaction, either count anonymous devices or one per
+// serial number. Running it again with the same batch name creates nothing and returns the devices provisioned
+// the first time, so a manifest can be written again; created reports which of the two happened.
+func ProvisionDevices(batchName string, count int, serials []string) (*response.ProvisioningManifest, bool, error) {
+	batchName = strings.TrimSpace(batchName)
+	if batchName == "" {
+		return nil, false, fmt.Errorf("batch name cannot be empty")
+	}
+	if len(serials) > 0 {
+		count = len(serials)
+	}
+
+	batch, err := models.GetProvisioningBatchByName(initializers.DB, batchName)
+	if err == nil {
+		devices, err := models.GetBatchDevices(initializers.DB, batch.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := checkBatchMatches(batch, devices, count, serials); err != nil {
+			return nil, false, err
+		}
+		return buildProvisioningManifest(batch, devices), false, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	if count < 1 || count > maxProvisioningBatchSize {
+		return nil, false, fmt.Errorf("a batch must have between 1 and %d devices", maxProvisioningBatchSize)
+	}
+	if err := checkSerialNumbers(serials); err != nil {
+		return nil, false, err
+	}
+
+	devices := make([]models.Device, count)
+	for i := range devices {
+		if devices[i], err = newClaimableDevice(); err != nil {
+			return nil, false, err
+		}
+		if len(serials) > 0 {
+			devices[i].SerialNumber = &serials[i]
+		}
+	}
+
+	batch = &models.ProvisioningBatch{ID: uuid.New(), Name: batchName}
+	if err := models.CreateProvisioningBatch(initializers.DB, batch, devices); err != nil {
+		return nil, false, err
+	}
+	return buildProvisioningManifest(batch, devices), true, nil
+}
+
+// checkBatchMatches refuses a re-run that asks for something else than the stored batch, which would otherwise
+// silently hand out a manifest that does not match the labels the caller is about to print.
+func checkBatchMatches(batch *models.ProvisioningBatch, devices []models.Device, count int, serials []string) error {
+	if count != 0 && count != len(devices) {
+		return fmt.Errorf("batch %s already exists with %d devices, not %d", batch.Name, len(devices), count)
+	}
+	for i, serial := range serials {
+		if devices[i].SerialNumber == nil || *devices[i].SerialNumber != serial {
+			return fmt.Errorf("batch %s already exists with different serial numbers (first difference at line %d)", batch.Name, i+1)
+		}
+	}
+	return nil
+}
+
+func checkSerialNumbers(serials []string) error {
+	if len(serials) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(serials))
+	for _, serial := range serials {
+		if seen[serial] {
+			return fmt.Errorf("serial number %s is listed twice", serial)
+		}
+		seen[serial] = true
+	}
+
+	existing, err := models.GetDevicesBySerialNumbers(initializers.DB, serials)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("serial number %s already belongs to device %s", *existing[0].SerialNumber, existing[0].ID)
+	}
+	return nil
+}
+
+func buildProvisioningManifest(batch *models.ProvisioningBatch, devices []models.Device) *response.ProvisioningManifest {
+	manifest := &response.ProvisioningManifest{
+		Batch:     batch.Name,
+		CreatedAt: batch.CreatedAt,
+		Devices:   make([]response.ProvisioningManifestRow, len(devices)),
+	}
+	for i, device := range devices {
+		row := response.ProvisioningManifestRow{
+			Index:     i + 1,
+			DeviceID:  device.ID,
+			SecretKey: device.SecretKey,
+		}
+		if device.SerialNumber != nil {
+			row.SerialNumber = *device.SerialNumber
+		}
+		// Devices that were claimed since the batch was provisioned no longer have a code to print.
+		if device.ClaimCode != nil {
+			row.ClaimCode = utils.FormatClaimCode(*device.ClaimCode)
+			row.ClaimQRPayload = ClaimQRPayload(*device.ClaimCode)
+			row.ClaimCodeExpiresAt = device.ClaimCodeExpiresAt
+		}
+		manifest.Devices[i] = row
+	}
+	return manifest
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRejectedReadings = 500
+
+// metricRanges are the rejection and suspicion bounds applied to one metric. Nil bounds are open.
+type metricRanges struct {
+	minValue, maxValue     *float64
+	suspectMin, suspectMax *float64
+}
+
+func validateMetricRanges(minValue, maxValue, suspectMin, suspectMax *float64) error {
+	if minValue != nil && maxValue != nil && *minValue > *maxValue {
+		return errors.New("Min value cannot be greater than max value")
+	}
+	if suspectMin != nil && suspectMax != nil && *suspectMin > *suspectMax {
+		return errors.New("Suspect min cannot be greater than suspect max")
+	}
+	return nil
+}
+
+func formatBound(bound *float64) string {
+	if bound == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*bound, 'f', -1, 64)
+}
+
+func outsideRange(value float64, minValue, maxValue *float64) bool {
+	return (minValue != nil && value < *minValue) || (maxValue != nil && value > *maxValue)
+}
+
+// loadPlausibilityRanges returns the device overrides by metric.
+func loadPlausibilityRanges(deviceID uuid.UUID) (map[string]models.DevicePlausibilityRange, error) {
+	list, err := models.GetDevicePlausibilityRanges(initializers.DB, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]models.DevicePlausibilityRange, len(list))
+	for _, plausibility := range list {
+		overrides[plausibility.Metric] = plausibility
+	}
+	return overrides, nil
+}
+
+// assessReadingQuality tags the reading as good, suspect or rejected and lists the metrics that caused it.
+// A device override of a metric replaces the ranges of its definition. The returned reasons explain every
+// flagged value, and rejected holds the reason of every value outside its valid range. The reading is only
+// rejected as a whole when all of its values are; otherwise it is suspect and withholdRejectedMetrics takes
+// the rejected values out before it is stored.
+func assessReadingQuality(reading *models.DeviceReading, overrides map[string]models.DevicePlausibilityRange) ([]string, map[string]string, error) {
+	definitions, err := getMetricDefinitions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Metrics withheld earlier stay flagged when a stored reading is assessed again.
+	flagged := reading.RejectedMetricList()
+	var reasons []string
+	rejected := make(map[string]string)
+	names := reading.MetricNames()
+	for _, name := range names {
+		var ranges metricRanges
+		if override, ok := overrides[name]; ok {
+			ranges = metricRanges{override.MinValue, override.MaxValue, override.SuspectMin, override.SuspectMax}
+		} else if definition, ok := definitions[name]; ok {
+			ranges = metricRanges{definition.MinValue, definition.MaxValue, definition.SuspectMin, definition.SuspectMax}
+		} else {
+			continue
+		}
+
+		value, _ := reading.MetricValue(name)
+		if outsideRange(value, ranges.minValue, ranges.maxValue) {
+			reason := fmt.Sprintf("%s %v is outside the valid range %s..%s", name, value, formatBound(ranges.minValue), formatBound(ranges.maxValue))
+			rejected[name] = reason
+			flagged = append(flagged, name)
+			reasons = append(reasons, reason)
+		} else if outsideRange(value, ranges.suspectMin, ranges.suspectMax) {
+			flagged = append(flagged, name)
+			reasons = append(reasons, fmt.Sprintf("%s %v is outside the plausible range %s..%s", name, value, formatBound(ranges.suspectMin), formatBound(ranges.suspectMax)))
+		}
+	}
+
+	switch {
+	case len(rejected) > 0 && len(rejected) == len(names):
+		reading.Quality = models.QualityRejected
+	case len(flagged) > 0:
+		reading.Quality = models.QualitySuspect
+	default:
+		reading.Quality = models.QualityGood
+	}
+	reading.FlaggedMetrics = strings.Join(flagged, ",")
+	return reasons, rejected, nil
+}
+
+// withholdRejectedMetrics takes the rejected values out of a reading that is stored without them and returns
+// them for quarantine. Built-in columns cannot be left empty, so they are zeroed; RejectedMetrics makes the
+// reading report them as missing.
+func withholdRejectedMetrics(reading *models.DeviceReading, rejected map[string]string) models.MetricValues {
+	values := make(models.MetricValues, len(rejected))
+	names := make([]string, 0, len(rejected))
+	for _, name := range reading.MetricNames() {
+		if _, ok := rejected[name]; !ok {
+			continue
+		}
+		values[name], _ = reading.MetricValue(name)
+		names = append(names, name)
+		if _, ok := reading.Metrics[name]; ok {
+			delete(reading.Metrics, name)
+		} else {
+			reading.SetMetricValue(name, 0)
+		}
+		delete(reading.RawValues, name)
+	}
+	reading.RejectedMetrics = strings.Join(append(reading.RejectedMetricList(), names...), ",")
+	return values
+}
+
+// quarantineReading stores rejected values of a reading apart from the device history.
+func quarantineReading(reading *models.DeviceReading, values models.MetricValues, reasons []string) error {
+	rejected := models.RejectedReading{
+		DeviceID:   reading.DeviceID,
+		TimeStamp:  reading.TimeStamp,
+		MessageID:  reading.MessageID,
+		Values:     values,
+		Reasons:    strings.Join(reasons, "; "),
+		ReceivedAt: time.Now().UTC(),
+	}
+	return models.CreateRejectedReading(initializers.DB, &rejected)
+}
+
+// readingMetricValues returns every value the reading carries.
+func readingMetricValues(reading *models.DeviceReading) models.MetricValues {
+	values := make(models.MetricValues)
+	for _, name := range reading.MetricNames() {
+		values[name], _ = reading.MetricValue(name)
+	}
+	return values
+}
+
+// rejectedReasons lists the reasons of the rejected metrics in metric order.
+func rejectedReasons(rejected map[string]string) []string {
+	names := make([]string, 0, len(rejected))
+	for name := range rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, rejected[name])
+	}
+	return reasons
+}
+
+func GetDevicePlausibilityRanges(c *gin.Context) {
+	device, ok := getAuthorizedDevice(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	ranges, err := models.GetDevicePlausibilityRanges(initializers.DB, device.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve plausibility ranges", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.PlausibilityRangeResponse, 0, len(ranges))
+	for i := range ranges {
+		resp = append(resp, response.BindPlausibilityRangeToResponse(&ranges[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved plausibility ranges", http.StatusOK, resp)
+}
+
+func SaveDevicePlausibilityRange(c *gin.Context) {
+	var req request.PlausibilityRangeRequest
+
+	device, ok := getAuthorizedDevice(c, models.RoleOperator)
+	if !ok {
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	defined, err := isDefinedMetric(req.Metric)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve metric definitions", http.StatusInternalServerError, nil)
+		return
+	} else if !defined {
+		response.GlobalResponse(c, fmt.Sprintf("Unknown metric %s", req.Metric), http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateMetricRanges(req.MinValue, req.MaxValue, req.SuspectMin, req.SuspectMax); err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	plausibility := models.DevicePlausibilityRange{
+		DeviceID:   device.ID,
+		Metric:     req.Metric,
+		MinValue:   req.MinValue,
+		MaxValue:   req.MaxValue,
+		SuspectMin: req.SuspectMin,
+		SuspectMax: req.SuspectMax,
+	}
+	if err := models.SaveDevicePlausibilityRange(initializers.DB, &plausibility); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to save plausibility range", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully saved plausibility range", http.StatusOK, response.BindPlausibilityRangeToResponse(&plausibility))
+}
+
+func DeleteDevicePlausibilityRange(c *gin.Context) {
+	device, ok := getAuthorizedDevice(c, models.RoleOperator)
+	if !ok {
+		return
+	}
+
+	deleted, err := models.DeleteDevicePlausibilityRange(initializers.DB, device.ID, c.Param("metric"))
+	if err != nil {
+		response.GlobalResponse(c, "Failed to delete plausibility range", http.StatusInternalServerError, nil)
+		return
+	}
+	if deleted == 0 {
+		response.GlobalResponse(c, "Plausibility range not found", http.StatusNotFound, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully deleted plausibility range", http.StatusOK, nil)
+}
+
+func GetRejectedReadings(c *gin.Context) {
+	var req request.RejectedReadingRequest
+
+	device, ok := getAuthorizedDevice(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := c.BindQuery(&req); err != nil {
+		response.GlobalResponse(c, "Invalid query parameters", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.Start == "" {
+		req.Start = time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339)
+	}
+	start, end, err := parseTimeRange(req.Start, req.End, exportMaxSpan())
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	rejected, err := models.GetRejectedReadings(initializers.DB, device.ID, start, end, maxRejectedReadings)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve rejected readings", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.RejectedReadingResponse, 0, len(rejected))
+	for i := range rejected {
+		resp = append(resp, response.BindRejectedReadingToResponse(&rejected[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved rejected readings", http.StatusOK, resp)
+}
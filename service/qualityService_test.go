@@ -0,0 +1,110 @@
+package service
+
+import (
+	"gin-crud/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func floatBound(value float64) *float64 {
+	return &value
+}
+
+// useMetricDefinitions primes the definition cache, so quality checks run without a database.
+func useMetricDefinitions(t *testing.T, definitions ...models.MetricDefinition) {
+	byName := make(map[string]models.MetricDefinition, len(definitions))
+	for _, definition := range definitions {
+		byName[definition.Name] = definition
+	}
+	metricDefinitionCache.Lock()
+	metricDefinitionCache.definitions = byName
+	metricDefinitionCache.loadedAt = time.Now()
+	metricDefinitionCache.Unlock()
+	t.Cleanup(invalidateMetricDefinitions)
+}
+
+func useTestMetricDefinitions(t *testing.T) {
+	useMetricDefinitions(t,
+		models.MetricDefinition{Name: "ph_level", MinValue: floatBound(0), MaxValue: floatBound(14)},
+		models.MetricDefinition{Name: "water_temp", MinValue: floatBound(-5), MaxValue: floatBound(60)},
+		models.MetricDefinition{Name: "ammonia", MinValue: floatBound(0), MaxValue: floatBound(10)},
+		models.MetricDefinition{Name: "turbidity", MinValue: floatBound(0), MaxValue: floatBound(1000), SuspectMax: floatBound(100)},
+	)
+}
+
+func TestOneBadMetricKeepsTheOtherValues(t *testing.T) {
+	useTestMetricDefinitions(t)
+	reading := models.DeviceReading{
+		OxygenLevel: 6,
+		WaterTemp:   27,
+		EcLevel:     1.2,
+		PhLevel:     20,
+		Metrics:     models.MetricValues{"ammonia": 99, "turbidity": 150},
+		RawValues:   models.MetricValues{"ph_level": 19.5},
+	}
+
+	_, rejected, err := assessReadingQuality(&reading, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Quality != models.QualitySuspect {
+		t.Fatalf("quality = %s, want %s", reading.Quality, models.QualitySuspect)
+	}
+	if len(rejected) != 2 || rejected["ph_level"] == "" || rejected["ammonia"] == "" {
+		t.Fatalf("rejected = %v", rejected)
+	}
+
+	values := withholdRejectedMetrics(&reading, rejected)
+	if !reflect.DeepEqual(values, models.MetricValues{"ph_level": 20, "ammonia": 99}) {
+		t.Fatalf("quarantined values = %v", values)
+	}
+	if reading.RejectedMetrics != "ph_level,ammonia" {
+		t.Fatalf("rejected metrics = %q", reading.RejectedMetrics)
+	}
+	if _, ok := reading.MetricValue("ph_level"); ok || reading.PhLevel != 0 {
+		t.Fatal("the rejected built-in value is still reported")
+	}
+	if _, ok := reading.MetricValue("ammonia"); ok {
+		t.Fatal("the rejected metric is still reported")
+	}
+	if _, ok := reading.RawValues["ph_level"]; ok {
+		t.Fatal("the raw value of the rejected metric was kept")
+	}
+	want := []string{"oxygen_level", "water_temp", "ec_level", "turbidity"}
+	if names := reading.MetricNames(); !reflect.DeepEqual(names, want) {
+		t.Fatalf("metric names = %v, want %v", names, want)
+	}
+	if value, ok := reading.MetricValue("water_temp"); !ok || value != 27 {
+		t.Fatalf("water_temp = %v, %v", value, ok)
+	}
+
+	// Assessing the stored reading again, as recalibration does, keeps the withheld metrics flagged.
+	if _, _, err := assessReadingQuality(&reading, nil); err != nil {
+		t.Fatal(err)
+	}
+	if reading.Quality != models.QualitySuspect || reading.FlaggedMetrics != "ph_level,ammonia,turbidity" {
+		t.Fatalf("quality = %s, flagged = %q", reading.Quality, reading.FlaggedMetrics)
+	}
+	if value := exportColumns["ph_level"](&reading, nil); value != nil {
+		t.Fatalf("ph_level exports as %v, want an empty value", value)
+	}
+}
+
+func TestReadingWithOnlyBadValuesIsRejected(t *testing.T) {
+	useMetricDefinitions(t,
+		models.MetricDefinition{Name: "oxygen_level", MaxValue: floatBound(20)},
+		models.MetricDefinition{Name: "water_temp", MaxValue: floatBound(60)},
+		models.MetricDefinition{Name: "ec_level", MaxValue: floatBound(10)},
+		models.MetricDefinition{Name: "ph_level", MaxValue: floatBound(14)},
+	)
+	reading := models.DeviceReading{OxygenLevel: 50, WaterTemp: 90, EcLevel: 30, PhLevel: 20}
+
+	reasons, rejected, err := assessReadingQuality(&reading, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Quality != models.QualityRejected || len(rejected) != 4 || len(reasons) != 4 {
+		t.Fatalf("quality = %s, rejected = %v", reading.Quality, rejected)
+	}
+}
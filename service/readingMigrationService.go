@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+)
+
+// MigrateDeviceDataToReadings moves the legacy CSV blob of every device into the device_readings table.
+// Each device is migrated in its own transaction and its blob is reduced to the CSV header afterwards,
+// so running the migration again does not duplicate readings.
+func MigrateDeviceDataToReadings() error {
+	var devices []models.Device
+	if err := initializers.DB.Find(&devices).Error; err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		count, err := migrateDeviceData(initializers.DB, device)
+		if err != nil {
+			return fmt.Errorf("failed migrating device %s: %w", device.ID, err)
+		}
+		log.Printf("Migrated %d readings from device %s\n", count, device.ID)
+	}
+	return nil
+}
+
+func migrateDeviceData(db *gorm.DB, device models.Device) (int, error) {
+	lines := strings.SplitN(string(device.Data), "\n", 2)
+	if len(lines) < 2 || strings.TrimSpace(lines[1]) == "" {
+		return 0, nil
+	}
+
+	records, err := parseCSVData(device.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	// The legacy blob holds a copy of every retried post, only the first reading of a timestamp is kept.
+	// Ingestion only ever wrote the device's own ID into its blob, so every row belongs to the device; a
+	// row naming another device is logged rather than moved to it.
+	seen := make(map[int64]bool, len(records))
+	readings := make([]models.DeviceReading, 0, len(records))
+	var foreign int
+	for _, record := range records {
+		if record.ID != "" && !strings.EqualFold(record.ID, device.ID.String()) {
+			foreign++
+		}
+		key := record.TimeStamp.UnixNano()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		readings = append(readings, toDeviceReading(record, device.ID))
+	}
+	if foreign > 0 {
+		log.Printf("Device %s has %d rows naming another device ID, migrated as its own readings\n", device.ID, foreign)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreateDeviceReadings(tx, readings); err != nil {
+			return err
+		}
+		return tx.Model(&models.Device{}).Where("id = ?", device.ID).Update("data", []byte(lines[0]+"\n")).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(readings), nil
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	retentionBatchSize      = 5000
+	retentionAuditLimit     = 100
+	maxRetentionDays        = 3650
+	retentionSchedulerDelay = 5 * time.Minute
+)
+
+// RetentionScheduler enforces retention policies once a day. Devices without a policy keep all their data.
+func RetentionScheduler() {
+	time.Sleep(retentionSchedulerDelay)
+	enforceRetentionPolicies()
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		enforceRetentionPolicies()
+	}
+}
+
+func enforceRetentionPolicies() {
+	log.Println("Running retention policy enforcement...")
+	devices, err := models.GetRegisteredDevices(initializers.DB)
+	if err != nil {
+		log.Println("Failed to retrieve devices for retention:", err)
+		return
+	}
+
+	for i := range devices {
+		policy, err := models.GetDeviceRetentionPolicy(initializers.DB, &devices[i])
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			log.Println("Failed to retrieve retention policy:", err)
+			continue
+		}
+		enforceDeviceRetention(&devices[i], policy)
+	}
+
+	// Archived history follows the account-wide policy of the user who keeps it.
+	archives, err := models.GetDeviceArchives(initializers.DB)
+	if err != nil {
+		log.Println("Failed to retrieve device archives for retention:", err)
+	}
+	for _, archive := range archives {
+		device := models.Device{ID: archive.ID, Name: archive.DeviceName, UmkmDataId: &archive.UmkmDataId}
+		policy, err := models.GetDeviceRetentionPolicy(initializers.DB, &device)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			log.Println("Failed to retrieve retention policy:", err)
+			continue
+		}
+		enforceDeviceRetention(&device, policy)
+	}
+	log.Println("Retention policy enforcement finished")
+}
+
+func enforceDeviceRetention(device *models.Device, policy *models.RetentionPolicy) {
+	now := time.Now().UTC()
+
+	if policy.RawRetentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.RawRetentionDays)
+		deleted, err := models.DeleteReadingsBefore(initializers.DB, device.ID, cutoff, retentionBatchSize)
+		if err != nil {
+			log.Printf("Failed to apply raw retention to device %s after deleting %d readings: %v\n", device.ID, deleted, err)
+		}
+		rejected, err := models.DeleteRejectedReadingsBefore(initializers.DB, device.ID, cutoff)
+		if err != nil {
+			log.Printf("Failed to apply raw retention to rejected readings of device %s: %v\n", device.ID, err)
+		}
+		deleted += rejected
+		writeRetentionAudit(device, policy, models.RetentionKindRaw, cutoff, deleted, now)
+	}
+
+	// Hourly and daily rollups have their own horizons, so daily trends can outlive hourly detail.
+	enforceRollupRetention(device, policy, models.RollupHourly, models.RetentionKindHourlyRollup, policy.HourlyRollupRetentionDays, now)
+	enforceRollupRetention(device, policy, models.RollupDaily, models.RetentionKindDailyRollup, policy.DailyRollupRetentionDays, now)
+}
+
+func enforceRollupRetention(device *models.Device, policy *models.RetentionPolicy, resolution string, kind string, days int, now time.Time) {
+	if days <= 0 {
+		return
+	}
+	cutoff := now.AddDate(0, 0, -days)
+	deleted, err := models.DeleteRollupsBefore(initializers.DB, device.ID, resolution, cutoff, retentionBatchSize)
+	if err != nil {
+		log.Printf("Failed to apply %s rollup retention to device %s after deleting %d rollups: %v\n", resolution, device.ID, deleted, err)
+	}
+	writeRetentionAudit(device, policy, kind, cutoff, deleted, now)
+}
+
+func writeRetentionAudit(device *models.Device, policy *models.RetentionPolicy, kind string, cutoff time.Time, deleted int64, ranAt time.Time) {
+	if deleted == 0 {
+		return
+	}
+
+	entry := models.RetentionAuditLog{
+		UmkmDataId:  policy.UmkmDataId,
+		PolicyID:    policy.ID,
+		DeviceID:    device.ID,
+		Kind:        kind,
+		Cutoff:      cutoff,
+		DeletedRows: deleted,
+		RanAt:       ranAt,
+	}
+	if err := models.CreateRetentionAuditLog(initializers.DB, &entry); err != nil {
+		log.Println("Failed to write retention audit log:", err)
+	}
+}
+
+func GetRetentionPolicies(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	policies, err := models.GetUserRetentionPolicies(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve retention policies", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.RetentionPolicyResponse, 0, len(policies))
+	for i := range policies {
+		resp = append(resp, response.BindRetentionPolicyToResponse(&policies[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved retention policies", http.StatusOK, resp)
+}
+
+func SaveRetentionPolicy(c *gin.Context) {
+	var req request.RetentionPolicyRequest
+	var groupID *uuid.UUID
+	var ownerID uuid.UUID
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.RawRetentionDays < 0 || req.RawRetentionDays > maxRetentionDays ||
+		req.HourlyRollupRetentionDays < 0 || req.HourlyRollupRetentionDays > maxRetentionDays ||
+		req.DailyRollupRetentionDays < 0 || req.DailyRollupRetentionDays > maxRetentionDays {
+		response.GlobalResponse(c, "Retention days must be between 0 (keep forever) and 3650", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.GroupID != "" {
+		id, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			response.GlobalResponse(c, "Invalid group ID format", http.StatusBadRequest, nil)
+			return
+		}
+		// A group policy belongs to the group owner, whose policies the retention job applies, so admins
+		// of a shared group edit the same policy.
+		group, ok := getAccessibleGroup(c, user.ID, id, models.RoleAdmin)
+		if !ok {
+			return
+		}
+		groupID = &id
+		ownerID = group.UmkmDataId
+	} else {
+		ownerID = user.ID
+	}
+
+	policy, err := models.GetUserRetentionPolicy(initializers.DB, ownerID, groupID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy = &models.RetentionPolicy{
+			UmkmDataId: ownerID,
+			GroupID:    groupID,
+		}
+	} else if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve retention policy", http.StatusInternalServerError, nil)
+		return
+	}
+
+	policy.RawRetentionDays = req.RawRetentionDays
+	policy.HourlyRollupRetentionDays = req.HourlyRollupRetentionDays
+	policy.DailyRollupRetentionDays = req.DailyRollupRetentionDays
+	if err := models.SaveRetentionPolicy(initializers.DB, policy); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to save retention policy", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully saved retention policy", http.StatusOK, response.BindRetentionPolicyToResponse(policy))
+}
+
+func DeleteRetentionPolicy(c *gin.Context) {
+	policyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid policy ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	policy, err := models.GetRetentionPolicyById(initializers.DB, policyID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.GlobalResponse(c, "Failed to retrieve retention policy", http.StatusInternalServerError, nil)
+		return
+	}
+	if err != nil || (policy.UmkmDataId != user.ID && policy.GroupID == nil) {
+		response.GlobalResponse(c, "Retention policy not found", http.StatusNotFound, nil)
+		return
+	}
+	if policy.UmkmDataId != user.ID {
+		if _, _, err := models.GetAccessibleGroup(initializers.DB, user.ID, *policy.GroupID, models.RoleAdmin); err != nil {
+			respondAccessError(c, err, "Retention policy not found")
+			return
+		}
+	}
+
+	if err := initializers.DB.Unscoped().Delete(policy).Error; err != nil {
+		response.GlobalResponse(c, "Failed to delete retention policy", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully deleted retention policy", http.StatusOK, nil)
+}
+
+func GetRetentionAuditLogs(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	entries, err := models.GetUserRetentionAuditLogs(initializers.DB, user.ID, retentionAuditLimit)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve retention audit log", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.RetentionAuditLogResponse, 0, len(entries))
+	for i := range entries {
+		resp = append(resp, response.BindRetentionAuditLogToResponse(&entries[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved retention audit log", http.StatusOK, resp)
+}
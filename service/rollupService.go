@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// RebuildReadingRollups recomputes the hourly and daily rollups from raw readings, for a single
+// device when deviceID is set and for every device otherwise.
+func RebuildReadingRollups(deviceID *uuid.UUID) error {
+	var devices []models.Device
+	query := initializers.DB.Select("id, group_id, umkm_data_id")
+	if deviceID != nil {
+		query = query.Where("id = ?", *deviceID)
+	}
+	if err := query.Find(&devices).Error; err != nil {
+		return err
+	}
+	if deviceID != nil && len(devices) == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	for i := range devices {
+		if err := rebuildRollups(&devices[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rebuildDeviceRollups(deviceID uuid.UUID) error {
+	device, err := models.GetDeviceById(initializers.DB, deviceID)
+	if err != nil {
+		return err
+	}
+	return rebuildRollups(device)
+}
+
+func rebuildRollups(device *models.Device) error {
+	from, err := rollupRebuildStart(device)
+	if err != nil {
+		return fmt.Errorf("failed reading retention policy of device %s: %w", device.ID, err)
+	}
+	count, err := models.RebuildDeviceRollups(initializers.DB, device.ID, from)
+	if err != nil {
+		return fmt.Errorf("failed rebuilding rollups of device %s: %w", device.ID, err)
+	}
+	log.Printf("Rebuilt %d rollups for device %s\n", count, device.ID)
+	return nil
+}
+
+// rollupRebuildStart returns the first day whose raw readings are guaranteed to be complete.
+// Raw readings before the retention cutoff may already be deleted, so the rollups covering
+// them are the only copy left and must not be recomputed.
+func rollupRebuildStart(device *models.Device) (time.Time, error) {
+	policy, err := models.GetDeviceRetentionPolicy(initializers.DB, device)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	if policy.RawRetentionDays <= 0 {
+		return time.Time{}, nil
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -policy.RawRetentionDays)
+	return models.RollupBucketStart(models.RollupDaily, cutoff).Add(models.RollupResolutions[models.RollupDaily]), nil
+}
+
+// rollupResolutionFor picks the coarsest rollup resolution that divides bucket. It returns false when
+// no rollup fits, or when percentiles are requested since those need the raw values.
+func rollupResolutionFor(bucket time.Duration, percentiles []float64) (string, bool) {
+	if len(percentiles) > 0 {
+		return "", false
+	}
+	if bucket%models.RollupResolutions[models.RollupDaily] == 0 {
+		return models.RollupDaily, true
+	}
+	if bucket%models.RollupResolutions[models.RollupHourly] == 0 {
+		return models.RollupHourly, true
+	}
+	return "", false
+}
+
+// aggregateRollups folds rollups into buckets aligned to the unix epoch, like aggregateReadings.
+// Rollups must be sorted by bucket start.
+func aggregateRollups(rollups []models.ReadingRollup, bucket time.Duration, metrics []string) []response.AggregationBucketResponse {
+	var buckets []response.AggregationBucketResponse
+	var stats map[string]response.MetricStatsResponse
+	var sums map[string]float64
+	var lastAt map[string]time.Time
+	var bucketStart time.Time
+
+	flush := func() {
+		if stats == nil {
+			return
+		}
+		for _, metric := range metrics {
+			stat := stats[metric]
+			if stat.Count > 0 {
+				stat.Mean = sums[metric] / float64(stat.Count)
+			}
+			stats[metric] = stat
+		}
+		buckets = append(buckets, response.AggregationBucketResponse{
+			BucketStart: bucketStart,
+			Metrics:     stats,
+		})
+	}
+
+	for i := range rollups {
+		rollup := &rollups[i]
+		start := models.BucketStart(rollup.BucketStart, bucket)
+		if stats == nil || !start.Equal(bucketStart) {
+			flush()
+			bucketStart = start
+			stats = make(map[string]response.MetricStatsResponse, len(metrics))
+			for _, metric := range metrics {
+				stats[metric] = response.MetricStatsResponse{}
+			}
+			sums = make(map[string]float64, len(metrics))
+			lastAt = make(map[string]time.Time, len(metrics))
+		}
+
+		stat, ok := stats[rollup.Metric]
+		if !ok {
+			continue
+		}
+		if stat.Count == 0 || rollup.MinValue < stat.Min {
+			stat.Min = rollup.MinValue
+		}
+		if stat.Count == 0 || rollup.MaxValue > stat.Max {
+			stat.Max = rollup.MaxValue
+		}
+		if stat.Count == 0 || !rollup.LastAt.Before(lastAt[rollup.Metric]) {
+			stat.Last = rollup.LastValue
+			lastAt[rollup.Metric] = rollup.LastAt
+		}
+		stat.Count += int(rollup.Count)
+		sums[rollup.Metric] += rollup.SumValue
+		stats[rollup.Metric] = stat
+	}
+	flush()
+	return buckets
+}
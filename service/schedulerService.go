@@ -2,9 +2,9 @@ package service
 
 import (
 	"errors"
-	"fmt"
 	"gin-crud/initializers"
 	model "gin-crud/models"
+	"gin-crud/utils"
 	"gorm.io/gorm"
 	"log"
 	"time"
@@ -52,55 +52,13 @@ func tokenExpirationCheckAndUpdate() {
 	}
 }
 
-func ClearDeviceDataScheduler() {
-	now := time.Now().UTC()
-
-	nextFirstOfMonth := time.Date(now.Year(), now.Month(), 1, 23, 59, 0, 0, time.UTC)
-	if now.Day() == 1 {
-		nextFirstOfMonth = nextFirstOfMonth.AddDate(0, 1, 0) // Move to next month if today is the first day
-	}
-	durationUntilNext := nextFirstOfMonth.Sub(now)
-
-	time.Sleep(durationUntilNext)
-
-	ticker := time.Tick(24 * time.Hour)
-
-	for range ticker {
-		if time.Now().UTC().Day() == 1 && time.Now().UTC().Hour() == 23 && time.Now().UTC().Minute() == 59 {
-			message := fmt.Sprintln("Running scheduler to clear Device Data...")
-			log.Println(message)
-			err := clearDeviceData()
-			if err != nil {
-				message = fmt.Sprintf("Error clearing Device Data: %v\n", err)
-				log.Println(message)
-			} else {
-				message = fmt.Sprintln("Device Data cleared successfully.")
-				log.Println(message)
-			}
-
-			nextFirstOfMonth = time.Now().UTC().AddDate(0, 1, 0)
-			nextFirstOfMonth = time.Date(nextFirstOfMonth.Year(), nextFirstOfMonth.Month(), 1, 23, 59, 0, 0, time.UTC)
-
-			durationUntilNext = nextFirstOfMonth.Sub(time.Now().UTC())
-
-			time.Sleep(durationUntilNext)
-		}
-	}
-}
-
-func clearDeviceData() error {
-	var devices []model.Device
-
-	if err := initializers.DB.Find(&devices).Error; err != nil {
-		return err
-	}
-
-	for i := range devices {
-		devices[i].Data = nil
-		if err := initializers.DB.Save(&devices[i]).Error; err != nil {
-			return err
+func DeviceNonceCleanupScheduler() {
+	ticker := time.NewTicker(time.Minute * 10)
+	defer ticker.Stop()
+	for range ticker.C {
+		before := time.Now().Add(-2 * utils.SignatureWindow)
+		if err := model.DeleteExpiredDeviceNonces(initializers.DB, before); err != nil {
+			log.Println("Failed to delete expired device nonces:", err)
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// shareTarget is the device or group whose shares are managed, with the role the signed in user holds on it.
+type shareTarget struct {
+	deviceID *uuid.UUID
+	groupID  *uuid.UUID
+	ownerID  uuid.UUID
+	role     string
+}
+
+func getShareTarget(c *gin.Context, group bool) (*shareTarget, *models.UmkmData, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid ID format", http.StatusBadRequest, nil)
+		return nil, nil, false
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, nil, false
+	}
+
+	if group {
+		group, role, err := models.GetAccessibleGroup(initializers.DB, user.ID, id, models.RoleViewer)
+		if err != nil {
+			respondAccessError(c, err, "Group not found")
+			return nil, nil, false
+		}
+		return &shareTarget{groupID: &group.ID, ownerID: group.UmkmDataId, role: role}, user, true
+	}
+
+	device, role, err := models.GetAccessibleDevice(initializers.DB, user.ID, id, models.RoleViewer)
+	if err != nil {
+		respondAccessError(c, err, "Cannot find the device")
+		return nil, nil, false
+	}
+	return &shareTarget{deviceID: &device.ID, ownerID: *device.UmkmDataId, role: role}, user, true
+}
+
+// canManageShare reports whether the user may change or remove a share holding role. Only the owner
+// hands out or takes away the admin role, so admins cannot lock each other out.
+func (target *shareTarget) canManageShare(role string) bool {
+	if role == models.RoleAdmin {
+		return target.role == models.RoleOwner
+	}
+	return models.RoleAllows(target.role, models.RoleAdmin)
+}
+
+func getShares(c *gin.Context, group bool) {
+	target, _, ok := getShareTarget(c, group)
+	if !ok {
+		return
+	}
+	if !models.RoleAllows(target.role, models.RoleAdmin) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	var shares []models.DeviceShare
+	var err error
+	if group {
+		shares, err = models.GetGroupShares(initializers.DB, *target.groupID)
+	} else {
+		shares, err = models.GetDeviceShares(initializers.DB, *target.deviceID)
+	}
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve shares", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved shares", http.StatusOK, response.BindSharesToResponse(shares))
+}
+
+func saveShare(c *gin.Context, group bool) {
+	var req request.ShareRequest
+
+	target, user, ok := getShareTarget(c, group)
+	if !ok {
+		return
+	}
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if !models.IsShareRole(req.Role) {
+		response.GlobalResponse(c, "Role must be viewer, operator or admin", http.StatusBadRequest, nil)
+		return
+	}
+	if !target.canManageShare(req.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid email", http.StatusBadRequest, nil)
+		return
+	}
+
+	var recipient models.UmkmData
+	if err := initializers.DB.Where("email = ?", address.Address).First(&recipient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "No account uses this email", http.StatusNotFound, nil)
+			return
+		}
+		response.GlobalResponse(c, "Failed to retrieve user", http.StatusInternalServerError, nil)
+		return
+	}
+	if recipient.ID == target.ownerID {
+		response.GlobalResponse(c, "The owner already has full access", http.StatusBadRequest, nil)
+		return
+	}
+
+	existing, err := models.FindShare(initializers.DB, recipient.ID, target.deviceID, target.groupID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve shares", http.StatusInternalServerError, nil)
+		return
+	}
+	if existing != nil && !target.canManageShare(existing.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	share := models.DeviceShare{
+		UmkmDataId: recipient.ID,
+		DeviceID:   target.deviceID,
+		GroupID:    target.groupID,
+		Role:       req.Role,
+		UserName:   recipient.Name,
+		UserEmail:  recipient.Email,
+		SharedByID: user.ID,
+		SharedBy:   user.Name,
+	}
+	if err := models.SaveShare(initializers.DB, &share); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to save share", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully saved share", http.StatusOK, response.BindShareToResponse(&share))
+}
+
+// deleteShare revokes a share. Anyone may also give up a share of their own.
+func deleteShare(c *gin.Context, group bool) {
+	target, user, ok := getShareTarget(c, group)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("share_id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid share ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	share, err := models.GetShareById(initializers.DB, shareID)
+	if err == nil && ((group && (share.GroupID == nil || *share.GroupID != *target.groupID)) ||
+		(!group && (share.DeviceID == nil || *share.DeviceID != *target.deviceID))) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		respondAccessError(c, err, "Share not found")
+		return
+	}
+	if share.UmkmDataId != user.ID && !target.canManageShare(share.Role) {
+		respondAccessError(c, utils.ErrInsufficientRole, "")
+		return
+	}
+
+	if err := models.DeleteShare(initializers.DB, share); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to delete share", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully deleted share", http.StatusOK, nil)
+}
+
+func GetDeviceShares(c *gin.Context) {
+	getShares(c, false)
+}
+
+func ShareDevice(c *gin.Context) {
+	saveShare(c, false)
+}
+
+func DeleteDeviceShare(c *gin.Context) {
+	deleteShare(c, false)
+}
+
+func GetGroupShares(c *gin.Context) {
+	getShares(c, true)
+}
+
+func ShareGroup(c *gin.Context) {
+	saveShare(c, true)
+}
+
+func DeleteGroupShare(c *gin.Context) {
+	deleteShare(c, true)
+}
+
+// GetSharedWithMe lists the devices and groups other accounts shared with the signed in user.
+func GetSharedWithMe(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	shares, err := models.GetUserShares(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve shares", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved shares", http.StatusOK, response.BindSharesToResponse(shares))
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	streamBufferSize     = 64
+	streamMaxDropped     = 256
+	streamPingInterval   = 30 * time.Second
+	streamAccessInterval = time.Minute
+	streamSlowClientMsg  = "Stream closed because the client is not keeping up"
+	streamRevokedMsg     = "Stream closed because access to it was revoked"
+)
+
+// readingSubscriber receives readings for one stream. When its buffer is full new readings are
+// dropped instead of blocking ingestion; a client that drops streamMaxDropped readings without
+// catching up in between is disconnected. The count starts over whenever the client drains its buffer.
+type readingSubscriber struct {
+	topic    uuid.UUID
+	readings chan response.ReadingResponse
+	slow     chan struct{}
+	dropped  int
+}
+
+// readingHub fans stored readings out to live streams. Topics are device IDs and group IDs.
+type readingHub struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[*readingSubscriber]struct{}
+}
+
+var liveReadings = &readingHub{
+	subscribers: make(map[uuid.UUID]map[*readingSubscriber]struct{}),
+}
+
+func (hub *readingHub) subscribe(topic uuid.UUID) *readingSubscriber {
+	subscriber := &readingSubscriber{
+		topic:    topic,
+		readings: make(chan response.ReadingResponse, streamBufferSize),
+		slow:     make(chan struct{}),
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.subscribers[topic] == nil {
+		hub.subscribers[topic] = make(map[*readingSubscriber]struct{})
+	}
+	hub.subscribers[topic][subscriber] = struct{}{}
+	return subscriber
+}
+
+func (hub *readingHub) unsubscribe(subscriber *readingSubscriber) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.subscribers[subscriber.topic], subscriber)
+	if len(hub.subscribers[subscriber.topic]) == 0 {
+		delete(hub.subscribers, subscriber.topic)
+	}
+}
+
+func (hub *readingHub) publish(device *models.Device, reading *models.DeviceReading) {
+	payload := response.BindReadingToResponse(reading)
+	topics := []uuid.UUID{device.ID}
+	if device.GroupID != nil {
+		topics = append(topics, *device.GroupID)
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, topic := range topics {
+		for subscriber := range hub.subscribers[topic] {
+			select {
+			case subscriber.readings <- payload:
+			default:
+				subscriber.dropped++
+				if subscriber.dropped == streamMaxDropped {
+					close(subscriber.slow)
+				}
+			}
+		}
+	}
+}
+
+// drained starts the dropped count of the subscriber over once it has emptied its buffer. A subscriber
+// that was already disconnected stays so.
+func (hub *readingHub) drained(subscriber *readingSubscriber) {
+	if len(subscriber.readings) > 0 {
+		return
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if subscriber.dropped < streamMaxDropped {
+		subscriber.dropped = 0
+	}
+}
+
+// streamAccessLost reports whether the user lost access to a stream, from the error of the access lookup.
+// Other errors keep the stream open until the next check.
+func streamAccessLost(err error) bool {
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, utils.ErrInsufficientRole) {
+		return true
+	} else if err != nil {
+		log.Println("Failed to check stream access:", err)
+	}
+	return false
+}
+
+// streamReadings writes server-sent events until the client goes away or falls too far behind. Access is
+// checked again every streamAccessInterval, so revoked shares, transfers and removed organization members
+// stop receiving readings.
+func streamReadings(c *gin.Context, topic uuid.UUID, accessLost func() bool) {
+	subscriber := liveReadings.subscribe(topic)
+	defer liveReadings.unsubscribe(subscriber)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	access := time.NewTicker(streamAccessInterval)
+	defer access.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-subscriber.slow:
+			c.SSEvent("error", streamSlowClientMsg)
+			return false
+		case reading := <-subscriber.readings:
+			c.SSEvent("reading", reading)
+			liveReadings.drained(subscriber)
+			return true
+		case <-ping.C:
+			c.SSEvent("ping", time.Now().UTC().Format(time.RFC3339))
+			return true
+		case <-access.C:
+			if accessLost() {
+				c.SSEvent("error", streamRevokedMsg)
+				return false
+			}
+			return true
+		}
+	})
+}
+
+func StreamDeviceReadings(c *gin.Context) {
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if _, ok := getAccessibleDevice(c, user.ID, deviceID, models.RoleViewer); !ok {
+		return
+	}
+
+	streamReadings(c, deviceID, func() bool {
+		_, _, err := models.GetAccessibleDevice(initializers.DB, user.ID, deviceID, models.RoleViewer)
+		return streamAccessLost(err)
+	})
+}
+
+func StreamGroupReadings(c *gin.Context) {
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid group ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if _, ok := getAccessibleGroup(c, user.ID, groupID, models.RoleViewer); !ok {
+		return
+	}
+
+	streamReadings(c, groupID, func() bool {
+		_, _, err := models.GetAccessibleGroup(initializers.DB, user.ID, groupID, models.RoleViewer)
+		return streamAccessLost(err)
+	})
+}
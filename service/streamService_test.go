@@ -0,0 +1,54 @@
+package service
+
+import (
+	"gin-crud/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// publishReadings publishes count readings of device and reports whether subscriber was disconnected.
+func publishReadings(hub *readingHub, device *models.Device, subscriber *readingSubscriber, count int) bool {
+	for i := 0; i < count; i++ {
+		hub.publish(device, &models.DeviceReading{DeviceID: device.ID, TimeStamp: time.Now()})
+	}
+	select {
+	case <-subscriber.slow:
+		return true
+	default:
+		return false
+	}
+}
+
+func drainReadings(hub *readingHub, subscriber *readingSubscriber) {
+	for len(subscriber.readings) > 0 {
+		<-subscriber.readings
+		hub.drained(subscriber)
+	}
+}
+
+func TestStreamDroppedCountStartsOverAfterDrain(t *testing.T) {
+	hub := &readingHub{subscribers: make(map[uuid.UUID]map[*readingSubscriber]struct{})}
+	device := &models.Device{ID: uuid.New()}
+	subscriber := hub.subscribe(device.ID)
+
+	// Bursts that each drop fewer than streamMaxDropped readings keep a client that catches up connected.
+	for burst := 0; burst < 3; burst++ {
+		if publishReadings(hub, device, subscriber, streamBufferSize+streamMaxDropped-1) {
+			t.Fatalf("disconnected in burst %d after catching up", burst)
+		}
+		drainReadings(hub, subscriber)
+		if subscriber.dropped != 0 {
+			t.Fatalf("dropped = %d after draining", subscriber.dropped)
+		}
+	}
+
+	if !publishReadings(hub, device, subscriber, streamBufferSize+streamMaxDropped) {
+		t.Fatal("a client that fell behind by streamMaxDropped readings stayed connected")
+	}
+	drainReadings(hub, subscriber)
+	if publishReadings(hub, device, subscriber, streamBufferSize+streamMaxDropped); subscriber.dropped < streamMaxDropped {
+		t.Fatalf("dropped = %d, a disconnected client must not start over", subscriber.dropped)
+	}
+}
@@ -0,0 +1,401 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	deviceTransferTTL        = 7 * 24 * time.Hour
+	maxTransferNoteLength    = 500
+	defaultDeviceTransferURL = "https://imon.andamantau.com/device-transfer/"
+)
+
+// deviceTransferURL is the page the transfer email links to; it accepts the transfer with the token.
+func deviceTransferURL(token string) string {
+	base := os.Getenv("DEVICE_TRANSFER_URL")
+	if base == "" {
+		base = defaultDeviceTransferURL
+	}
+	return base + token
+}
+
+func sendDeviceTransferMail(transfer *models.DeviceTransfer) {
+	if _, err := DeviceTransferMail(transfer.ToEmail, transfer, deviceTransferURL(transfer.Token)); err != nil {
+		log.Printf("Failed to send transfer %s to %s: %v\n", transfer.ID, transfer.ToEmail, err)
+	}
+}
+
+// respondTransferError answers for the errors shared by every step of a transfer.
+func respondTransferError(c *gin.Context, err error, failure string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		response.GlobalResponse(c, "Cannot find the transfer", http.StatusNotFound, nil)
+	case errors.Is(err, utils.ErrTransferExpired):
+		response.GlobalResponse(c, "Transfer has expired", http.StatusGone, nil)
+	case errors.Is(err, utils.ErrTransferNotPending):
+		response.GlobalResponse(c, "Transfer is no longer pending", http.StatusConflict, nil)
+	default:
+		log.Println(err.Error())
+		response.GlobalResponse(c, failure, http.StatusInternalServerError, nil)
+	}
+}
+
+// getUserTransfer loads a transfer the signed in user takes part in; the user has to be the sender, either
+// the account the device was registered to or the user who started the transfer, or the recipient
+// depending on the step.
+func getUserTransfer(c *gin.Context, sender bool) (*models.DeviceTransfer, *models.UmkmData, bool) {
+	transferID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid transfer ID format", http.StatusBadRequest, nil)
+		return nil, nil, false
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, nil, false
+	}
+
+	transfer, err := models.GetDeviceTransferById(initializers.DB, transferID)
+	if err != nil {
+		respondTransferError(c, err, "Failed to retrieve transfer")
+		return nil, nil, false
+	}
+	if (sender && !transfer.IsSender(user.ID)) || (!sender && transfer.ToUserID != user.ID) {
+		response.GlobalResponse(c, "Cannot find the transfer", http.StatusNotFound, nil)
+		return nil, nil, false
+	}
+	return transfer, user, true
+}
+
+func StartDeviceTransfer(c *gin.Context) {
+	var req request.DeviceTransferRequest
+
+	device, ok := getAuthorizedDevice(c, models.RoleOwner)
+	if !ok {
+		return
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid recipient email", http.StatusBadRequest, nil)
+		return
+	}
+	if req.History == "" {
+		req.History = models.TransferHistoryKeep
+	} else if req.History != models.TransferHistoryKeep && req.History != models.TransferHistoryArchive {
+		response.GlobalResponse(c, "History must be keep or archive", http.StatusBadRequest, nil)
+		return
+	}
+	if len(req.Note) > maxTransferNoteLength {
+		response.GlobalResponse(c, "Note cannot be longer than 500 characters", http.StatusBadRequest, nil)
+		return
+	}
+
+	var recipient models.UmkmData
+	if err := initializers.DB.Where("email = ?", address.Address).First(&recipient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "No account uses this email", http.StatusNotFound, nil)
+			return
+		}
+		response.GlobalResponse(c, "Failed to retrieve recipient", http.StatusInternalServerError, nil)
+		return
+	}
+	if recipient.ID == user.ID || recipient.ID == *device.UmkmDataId {
+		response.GlobalResponse(c, "Cannot transfer a device to yourself", http.StatusBadRequest, nil)
+		return
+	}
+
+	token, err := utils.GenerateSecretKey()
+	if err != nil {
+		response.GlobalResponse(c, "Failed to start transfer", http.StatusInternalServerError, nil)
+		return
+	}
+	transfer := models.DeviceTransfer{
+		DeviceID:  device.ID,
+		ToUserID:  recipient.ID,
+		ToName:    recipient.Name,
+		ToEmail:   recipient.Email,
+		History:   req.History,
+		Note:      req.Note,
+		Token:     token,
+		ExpiresAt: time.Now().Add(deviceTransferTTL),
+	}
+	err = models.CreateDeviceTransfer(initializers.DB, &transfer, user)
+	if errors.Is(err, utils.ErrTransferInProgress) {
+		response.GlobalResponse(c, "Device already has a pending transfer", http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to start transfer", http.StatusInternalServerError, nil)
+		return
+	}
+
+	go sendDeviceTransferMail(&transfer)
+	response.GlobalResponse(c, "Successfully started device transfer", http.StatusOK, response.BindDeviceTransferToResponse(&transfer))
+}
+
+func GetDeviceTransfers(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	transfers, err := models.GetUserDeviceTransfers(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve transfers", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.DeviceTransferResponse, 0, len(transfers))
+	for i := range transfers {
+		resp = append(resp, response.BindDeviceTransferToResponse(&transfers[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved transfers", http.StatusOK, resp)
+}
+
+func GetDeviceTransferAudit(c *gin.Context) {
+	transferID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid transfer ID format", http.StatusBadRequest, nil)
+		return
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	transfer, err := models.GetDeviceTransferById(initializers.DB, transferID)
+	if err != nil {
+		respondTransferError(c, err, "Failed to retrieve transfer")
+		return
+	}
+	if !transfer.IsSender(user.ID) && transfer.ToUserID != user.ID {
+		response.GlobalResponse(c, "Cannot find the transfer", http.StatusNotFound, nil)
+		return
+	}
+
+	entries, err := models.GetDeviceTransferAuditLogs(initializers.DB, transfer.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve transfer audit log", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.DeviceTransferAuditResponse, 0, len(entries))
+	for i := range entries {
+		resp = append(resp, response.BindDeviceTransferAuditToResponse(&entries[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved transfer audit log", http.StatusOK, resp)
+}
+
+func acceptDeviceTransfer(c *gin.Context, transferID uuid.UUID, recipient *models.UmkmData) {
+	transfer, err := models.AcceptDeviceTransfer(initializers.DB, transferID, recipient)
+	if err != nil {
+		respondTransferError(c, err, "Failed to accept transfer")
+		return
+	}
+	response.GlobalResponse(c, "Successfully accepted device transfer", http.StatusOK, response.BindDeviceTransferToResponse(transfer))
+}
+
+func closeDeviceTransfer(c *gin.Context, transferID uuid.UUID, actor *models.UmkmData, status string, action string) {
+	transfer, err := models.CloseDeviceTransfer(initializers.DB, transferID, actor, status, action)
+	if err != nil {
+		respondTransferError(c, err, "Failed to update transfer")
+		return
+	}
+	response.GlobalResponse(c, "Successfully "+action+" device transfer", http.StatusOK, response.BindDeviceTransferToResponse(transfer))
+}
+
+func AcceptDeviceTransfer(c *gin.Context) {
+	transfer, user, ok := getUserTransfer(c, false)
+	if !ok {
+		return
+	}
+	acceptDeviceTransfer(c, transfer.ID, user)
+}
+
+func DeclineDeviceTransfer(c *gin.Context) {
+	transfer, user, ok := getUserTransfer(c, false)
+	if !ok {
+		return
+	}
+	closeDeviceTransfer(c, transfer.ID, user, models.TransferStatusDeclined, models.TransferActionDeclined)
+}
+
+func CancelDeviceTransfer(c *gin.Context) {
+	transfer, user, ok := getUserTransfer(c, true)
+	if !ok {
+		return
+	}
+	closeDeviceTransfer(c, transfer.ID, user, models.TransferStatusCancelled, models.TransferActionCancelled)
+}
+
+// getTransferByToken loads the transfer and recipient behind the link in the transfer email, which works without signing in.
+func getTransferByToken(c *gin.Context) (*models.DeviceTransfer, *models.UmkmData, bool) {
+	transfer, err := models.GetDeviceTransferByToken(initializers.DB, c.Param("token"))
+	if err != nil {
+		respondTransferError(c, err, "Failed to retrieve transfer")
+		return nil, nil, false
+	}
+
+	var recipient models.UmkmData
+	if err := initializers.DB.First(&recipient, "id = ?", transfer.ToUserID).Error; err != nil {
+		respondTransferError(c, err, "Failed to retrieve recipient")
+		return nil, nil, false
+	}
+	return transfer, &recipient, true
+}
+
+func AcceptDeviceTransferByToken(c *gin.Context) {
+	transfer, recipient, ok := getTransferByToken(c)
+	if !ok {
+		return
+	}
+	acceptDeviceTransfer(c, transfer.ID, recipient)
+}
+
+func DeclineDeviceTransferByToken(c *gin.Context) {
+	transfer, recipient, ok := getTransferByToken(c)
+	if !ok {
+		return
+	}
+	closeDeviceTransfer(c, transfer.ID, recipient, models.TransferStatusDeclined, models.TransferActionDeclined)
+}
+
+func getUserDeviceArchive(c *gin.Context) (*models.DeviceArchive, bool) {
+	archiveID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid archive ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	archive, err := models.GetUserDeviceArchive(initializers.DB, user.ID, archiveID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Cannot find the archive", http.StatusNotFound, nil)
+			return nil, false
+		}
+		response.GlobalResponse(c, "Failed to retrieve archive", http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	return archive, true
+}
+
+func GetDeviceArchives(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	archives, err := models.GetUserDeviceArchives(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve archives", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.DeviceArchiveResponse, 0, len(archives))
+	for i := range archives {
+		resp = append(resp, response.BindDeviceArchiveToResponse(&archives[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved archives", http.StatusOK, resp)
+}
+
+// GetDeviceArchiveReadings pages through the readings of an archive the same way the monitoring endpoint does.
+func GetDeviceArchiveReadings(c *gin.Context) {
+	var req request.ArchiveReadingsRequest
+
+	archive, ok := getUserDeviceArchive(c)
+	if !ok {
+		return
+	}
+	if err := c.BindQuery(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	start, end, err := parseTimeRange(req.Start, req.End, monitoringMaxSpan())
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultMonitoringLimit
+	} else if limit > maxMonitoringLimit {
+		limit = maxMonitoringLimit
+	}
+
+	var afterTime *time.Time
+	var afterID uuid.UUID
+	if req.Cursor != "" {
+		afterTime, afterID, err = decodeReadingCursor(req.Cursor)
+		if err != nil {
+			response.GlobalResponse(c, "Invalid cursor", http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	readings, err := models.GetDeviceReadingsPage(initializers.DB, archive.ID, start, end, afterTime, afterID, limit+1)
+	if err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to retrieve archived readings", http.StatusInternalServerError, nil)
+		return
+	}
+
+	var resp response.MonitoringResponse
+	if len(readings) > limit {
+		readings = readings[:limit]
+		resp.NextCursor = encodeReadingCursor(readings[len(readings)-1])
+	}
+	resp.Records = make([]response.ReadingResponse, 0, len(readings))
+	for i := range readings {
+		resp.Records = append(resp.Records, response.BindReadingToResponse(&readings[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved archived readings", http.StatusOK, resp)
+}
+
+func DeleteDeviceArchive(c *gin.Context) {
+	archive, ok := getUserDeviceArchive(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteDeviceArchive(initializers.DB, archive); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to delete archive", http.StatusInternalServerError, nil)
+		return
+	}
+	response.GlobalResponse(c, "Successfully deleted archive", http.StatusOK, nil)
+}
@@ -175,7 +175,7 @@ func GetAllUserDevices(c *gin.Context) {
 		return
 	}
 
-	devices, err := model.GetAllUserDevices(initializers.DB, user.ID)
+	devices, roles, err := model.GetAccessibleDevices(initializers.DB, user.ID, user.ActiveOrganizationID)
 	if err != nil {
 		response.GlobalResponse(c, "Failed to retrieve user devices", http.StatusInternalServerError, nil)
 		return
@@ -183,16 +183,10 @@ func GetAllUserDevices(c *gin.Context) {
 
 	var responseData []response.DeviceResponse
 
-	for _, device := range devices {
-		respDevice := response.DeviceResponse{
-			ID:          device.ID,
-			Name:        device.Name,
-			GroupName:   device.GroupName,
-			GroupID:     device.GroupID,
-			IsActivated: device.IsActivated,
-			UmkmDataId:  device.UmkmDataId,
-		}
-		responseData = append(responseData, respDevice)
+	for i := range devices {
+		resp := response.BindDeviceToResponse(&devices[i])
+		resp.Role = roles[devices[i].ID]
+		responseData = append(responseData, resp)
 	}
 
 	response.GlobalResponse(c, "Successfully retrieved user devices", http.StatusOK, responseData)
@@ -212,7 +206,7 @@ func GetDeviceById(c *gin.Context) {
 		response.GlobalResponse(c, err.Error(), http.StatusUnauthorized, nil)
 		return
 	}
-	device, err := model.GetUserDeviceById(initializers.DB, participant.ID, uuId)
+	device, _, err := model.GetAccessibleDevice(initializers.DB, participant.ID, uuId, model.RoleViewer)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			response.GlobalResponse(c, "Device not found", http.StatusOK, nil)
@@ -230,8 +224,8 @@ func GetDeviceById(c *gin.Context) {
 	response.GlobalResponse(c, "Successfully retrieved device", http.StatusOK, resp)
 }
 
-func RegisterDeviceById(c *gin.Context) {
-	id := c.Param("id")
+func RegisterDeviceByClaimCode(c *gin.Context) {
+	code := utils.NormalizeClaimCode(c.Param("code"))
 	var req request.DeviceRequest
 
 	if err := c.Bind(&req); err != nil {
@@ -249,9 +243,8 @@ func RegisterDeviceById(c *gin.Context) {
 		return
 	}
 
-	uuId, err := uuid.Parse(id)
-	if err != nil {
-		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+	if code == "" {
+		response.GlobalResponse(c, "Claim code cannot be empty", http.StatusBadRequest, nil)
 		return
 	}
 
@@ -261,19 +254,21 @@ func RegisterDeviceById(c *gin.Context) {
 		return
 	}
 
-	err, message, status := model.RegisterDeviceById(initializers.DB, participant.ID, uuId, req.Name, req.GroupID)
+	group, ok := getAccessibleGroup(c, participant.ID, req.GroupID, model.RoleOperator)
+	if !ok {
+		return
+	}
+
+	device, err, message, status := model.RegisterDeviceByClaimCode(initializers.DB, group, code, req.Name)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			response.GlobalResponse(c, "Device not found or already registered", http.StatusNotFound, nil)
-		} else if errors.Is(err, utils.ErrDeviceAlreadyRegistered) {
-			response.GlobalResponse(c, "Device already registered", http.StatusNotFound, nil)
-		} else {
-			response.GlobalResponse(c, message, http.StatusInternalServerError, status)
+		if status == http.StatusInternalServerError {
+			log.Println(err.Error())
 		}
+		response.GlobalResponse(c, message, status, nil)
 		return
 	}
 
-	response.GlobalResponse(c, "Successfully registering device", http.StatusOK, nil)
+	response.GlobalResponse(c, "Successfully registering device", http.StatusOK, response.BindDeviceToResponse(device))
 }
 
 func UpdateDeviceName(c *gin.Context) {
@@ -297,7 +292,12 @@ func UpdateDeviceName(c *gin.Context) {
 		return
 	}
 
-	err = model.UpdateDeviceName(initializers.DB, participant.ID, uuId, req.Name)
+	device, ok := getAccessibleDevice(c, participant.ID, uuId, model.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	err = model.UpdateDeviceName(initializers.DB, *device.UmkmDataId, device.ID, req.Name)
 	if err != nil {
 		response.GlobalResponse(c, "Device not found", http.StatusBadRequest, nil)
 		log.Println(err.Error())
@@ -307,6 +307,39 @@ func UpdateDeviceName(c *gin.Context) {
 	response.GlobalResponse(c, "successfully updating device name", http.StatusOK, nil)
 }
 
+func RotateDeviceSecret(c *gin.Context) {
+	id := c.Param("id")
+
+	uuId, err := uuid.Parse(id)
+	if err != nil {
+		response.GlobalResponse(c, "Invalid device ID format", http.StatusBadRequest, nil)
+		return
+	}
+
+	participant, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, err.Error(), http.StatusUnauthorized, nil)
+		return
+	}
+
+	device, ok := getAccessibleDevice(c, participant.ID, uuId, model.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := model.RotateDeviceSecret(initializers.DB, device); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to rotate device secret", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := response.DeviceSecretResponse{
+		ID:        device.ID,
+		SecretKey: device.SecretKey,
+	}
+	response.GlobalResponse(c, "Successfully rotated device secret", http.StatusOK, resp)
+}
+
 func CreateDeviceGroup(c *gin.Context) {
 	var req request.UmkmRequest
 	var message string
@@ -322,7 +355,14 @@ func CreateDeviceGroup(c *gin.Context) {
 		return
 	}
 
-	err, message, status = model.CreateGrouping(initializers.DB, user.ID, req.GroupName)
+	// Groups are created in the active organization, which only members with write access may add to.
+	if user.ActiveOrganizationID != nil {
+		if _, ok := getAccessibleOrganization(c, user.ID, *user.ActiveOrganizationID, model.RoleOperator); !ok {
+			return
+		}
+	}
+
+	err, message, status = model.CreateGrouping(initializers.DB, user.ID, user.ActiveOrganizationID, req.GroupName)
 	if err != nil {
 		response.GlobalResponse(c, message, status, nil)
 		log.Println(err.Error())
@@ -356,7 +396,12 @@ func RenameGroup(c *gin.Context) {
 		return
 	}
 
-	err, message, status = model.RenameGrouping(initializers.DB, user.ID, uuId, req.NewGroupName)
+	group, ok := getAccessibleGroup(c, user.ID, uuId, model.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	err, message, status = model.RenameGrouping(initializers.DB, group.UmkmDataId, group.ID, req.NewGroupName)
 	if err != nil {
 		response.GlobalResponse(c, message, status, nil)
 		log.Println(err.Error())
@@ -417,7 +462,11 @@ func RemoveDeviceFromGroup(c *gin.Context) {
 		response.GlobalResponse(c, "", http.StatusUnauthorized, nil)
 		return
 	}
-	err, message, status = model.UnassignDeviceFromGroup(initializers.DB, uuId, user.ID)
+	device, ok := getAccessibleDevice(c, user.ID, uuId, model.RoleAdmin)
+	if !ok {
+		return
+	}
+	err, message, status = model.UnassignDeviceFromGroup(initializers.DB, device.ID, *device.UmkmDataId)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		response.GlobalResponse(c, message, status, nil)
 		return
@@ -447,7 +496,12 @@ func DeleteDeviceById(c *gin.Context) {
 		return
 	}
 
-	err, message, status = model.UnassignDeviceFromGroup(initializers.DB, uuId, user.ID)
+	device, ok := getAccessibleDevice(c, user.ID, uuId, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	err, message, status = model.UnassignDeviceFromGroup(initializers.DB, uuId, *device.UmkmDataId)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		response.GlobalResponse(c, message, status, nil)
 		return
@@ -458,7 +512,7 @@ func DeleteDeviceById(c *gin.Context) {
 		return
 	}
 
-	err = model.DeleteDeviceById(initializers.DB, user.ID, uuId)
+	err = model.DeleteDeviceById(initializers.DB, *device.UmkmDataId, uuId)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			response.GlobalResponse(c, "Device not found", http.StatusNotFound, nil)
@@ -470,12 +524,18 @@ func DeleteDeviceById(c *gin.Context) {
 		return
 	}
 
-	response.GlobalResponse(c, "Successfully deleted device relationship", http.StatusOK, nil)
+	// The released device needs a fresh code before anyone can register it again.
+	released := model.Device{ID: uuId}
+	if err := model.IssueClaimCode(initializers.DB, &released, time.Now().Add(claimCodeTTL())); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Successfully deleted device relationship", http.StatusOK, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully deleted device relationship", http.StatusOK, bindClaimCodeToResponse(&released))
 }
 
 func GetAllGroup(c *gin.Context) {
-	var groups []model.DeviceGrouping
-
 	participant, err := getUmkmByAuth(c)
 	if err != nil {
 		fmt.Println(1)
@@ -483,11 +543,26 @@ func GetAllGroup(c *gin.Context) {
 		return
 	}
 
-	if err := initializers.DB.Where("umkm_data_id = ?", participant.ID).Find(&groups).Error; err != nil {
+	groups, err := model.GetUserGroups(initializers.DB, participant.ID, participant.ActiveOrganizationID)
+	if err != nil {
 		fmt.Println(2)
 		response.GlobalResponse(c, "Failed to retrieve groups", http.StatusInternalServerError, nil)
 		return
 	}
+	shared, err := model.GetSharedGroups(initializers.DB, participant.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve shared groups", http.StatusInternalServerError, nil)
+		return
+	}
+	listed := make(map[uuid.UUID]bool, len(groups))
+	for _, group := range groups {
+		listed[group.ID] = true
+	}
+	for _, group := range shared {
+		if !listed[group.ID] {
+			groups = append(groups, group)
+		}
+	}
 	fmt.Println(3)
 	response.GlobalResponse(c, "Successfully retrieved all groups", http.StatusOK, groups)
 }
@@ -509,7 +584,12 @@ func GetGroupById(c *gin.Context) {
 		return
 	}
 
-	if err := initializers.DB.Select("id, name, is_activated, group_name, group_id, umkm_data_id").Where("umkm_data_id = ? AND group_id = ?", user.ID, groupID).Find(&devices).Error; err != nil {
+	group, ok := getAccessibleGroup(c, user.ID, groupID, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := initializers.DB.Select("id, name, is_activated, group_name, group_id, umkm_data_id").Where("umkm_data_id = ? AND group_id = ?", group.UmkmDataId, group.ID).Find(&devices).Error; err != nil {
 		response.GlobalResponse(c, "Failed to retrieve devices", http.StatusInternalServerError, nil)
 		return
 	}
@@ -523,7 +603,6 @@ func GetGroupById(c *gin.Context) {
 }
 
 func DeleteGroupById(c *gin.Context) {
-	var group model.DeviceGrouping
 	var message string
 	var status int
 
@@ -542,24 +621,24 @@ func DeleteGroupById(c *gin.Context) {
 		return
 	}
 
-	err = initializers.DB.Where("id = ? AND umkm_data_id = ?", groupID, user.ID).
-		First(&group).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		response.GlobalResponse(c, "Group not found", http.StatusNotFound, nil)
-		return
-	} else if err != nil {
-		response.GlobalResponse(c, "Failed to retrieve group", http.StatusInternalServerError, nil)
-		log.Println(err.Error())
+	group, ok := getAccessibleGroup(c, user.ID, groupID, model.RoleOwner)
+	if !ok {
 		return
 	}
 
-	err, message, status = model.UnassignDevicesFromGroup(initializers.DB, groupID, user.ID)
+	err, message, status = model.UnassignDevicesFromGroup(initializers.DB, groupID, group.UmkmDataId)
 	if err != nil {
 		response.GlobalResponse(c, message, status, nil)
 		return
 	}
 
-	err = initializers.DB.Unscoped().Delete(&group).Error
+	if err := model.DeleteGroupShares(initializers.DB, group.ID); err != nil {
+		response.GlobalResponse(c, "Failed deleting group shares", http.StatusInternalServerError, nil)
+		log.Println(err.Error())
+		return
+	}
+
+	err = initializers.DB.Unscoped().Delete(group).Error
 	if err != nil {
 		response.GlobalResponse(c, "Failed deleting group", http.StatusInternalServerError, nil)
 		log.Println(err.Error())
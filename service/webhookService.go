@@ -0,0 +1,546 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-crud/initializers"
+	"gin-crud/models"
+	"gin-crud/request"
+	"gin-crud/response"
+	"gin-crud/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 30 * time.Second
+	webhookDeliveryLimit  = 100
+	// webhookDisableAfter is the number of deliveries in a row that may fail every attempt before the
+	// webhook is disabled; the owner enables it again with an update.
+	webhookDisableAfter = 10
+	webhookQueueSize    = 1000
+	// webhookSendTimeout is how long a delivery may stay sending before it is assumed lost and retried.
+	webhookSendTimeout       = time.Minute
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+var webhookClient = newWebhookClient(false)
+
+// webhookQueue holds the IDs of deliveries that are due now. Deliveries that do not fit, and retries,
+// wait in the delivery table until WebhookDeliveryScheduler picks them up.
+var webhookQueue = make(chan uuid.UUID, webhookQueueSize)
+
+// newWebhookClient returns the client used to post webhooks. Unless allowPrivate is set, it refuses to
+// connect to addresses that are not public, checked on the resolved address of every connection so that
+// DNS changes and redirects cannot reach internal services either.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !utils.IsPublicIP(ip) {
+				return utils.ErrWebhookTargetNotAllowed
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// validateWebhookURL checks that the URL is an absolute http or https address whose host resolves only
+// to public addresses.
+func validateWebhookURL(rawURL string) (string, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Hostname() == "" {
+		return "URL must be an absolute http or https address", errors.New("invalid url")
+	}
+
+	ips := []net.IP{net.ParseIP(parsedURL.Hostname())}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsedURL.Hostname())
+		if err != nil {
+			return "Cannot resolve the URL host", err
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !utils.IsPublicIP(ip) {
+			return "URL cannot point to a private or local address", utils.ErrWebhookTargetNotAllowed
+		}
+	}
+	return "", nil
+}
+
+// dispatchWebhookEvent queues the event for every webhook of the device owner that subscribed to it.
+// Each delivery is stored before it is sent, so it survives a restart.
+func dispatchWebhookEvent(device *models.Device, event string, message string, data interface{}) {
+	webhooks, err := models.GetDeviceWebhooks(initializers.DB, device)
+	if err != nil {
+		log.Println("Failed to retrieve webhooks:", err)
+		return
+	}
+
+	var body []byte
+	allowed := map[uuid.UUID]bool{}
+	for i := range webhooks {
+		if !webhooks[i].Subscribes(event) {
+			continue
+		}
+		if !userHoldsDeviceRole(device, webhooks[i].UmkmDataId, models.RoleAdmin, allowed) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(response.WebhookEnvelope(event, message, data))
+			if err != nil {
+				log.Println("Failed to encode webhook payload:", err)
+				return
+			}
+		}
+
+		now := time.Now()
+		delivery := models.WebhookDelivery{
+			WebhookID:     webhooks[i].ID,
+			Event:         event,
+			Payload:       string(body),
+			Attempt:       1,
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := models.CreateWebhookDelivery(initializers.DB, &delivery); err != nil {
+			log.Println("Failed to queue webhook delivery:", err)
+			continue
+		}
+		enqueueWebhookDelivery(delivery.ID)
+	}
+}
+
+// enqueueWebhookDelivery hands the delivery to a worker without waiting; when the queue is full the
+// scheduler sends it later.
+func enqueueWebhookDelivery(deliveryID uuid.UUID) {
+	select {
+	case webhookQueue <- deliveryID:
+	default:
+	}
+}
+
+func webhookWorkerCount() int {
+	count := 4
+	if value := os.Getenv("WEBHOOK_WORKERS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Println("Invalid WEBHOOK_WORKERS, using default:", count)
+		} else {
+			count = parsed
+		}
+	}
+	return count
+}
+
+// WebhookDeliveryScheduler runs the webhook workers and feeds them the deliveries that are due from the
+// delivery table: retries, deliveries that did not fit in the queue and those interrupted by a restart.
+// It also removes finished deliveries older than the retention.
+func WebhookDeliveryScheduler() {
+	for i := 0; i < webhookWorkerCount(); i++ {
+		go func() {
+			for deliveryID := range webhookQueue {
+				processWebhookDelivery(deliveryID)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			if err := models.ResetStaleWebhookDeliveries(initializers.DB, now.Add(-webhookSendTimeout)); err != nil {
+				log.Println("Failed to reset stale webhook deliveries:", err)
+			}
+			ids, err := models.GetDueWebhookDeliveryIds(initializers.DB, now, webhookQueueSize)
+			if err != nil {
+				log.Println("Failed to retrieve due webhook deliveries:", err)
+				continue
+			}
+			for _, id := range ids {
+				enqueueWebhookDelivery(id)
+			}
+		case <-cleanup.C:
+			deleted, err := models.DeleteOldWebhookDeliveries(initializers.DB, time.Now().Add(-webhookDeliveryRetention))
+			if err != nil {
+				log.Println("Failed to delete old webhook deliveries:", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d old webhook deliveries\n", deleted)
+			}
+		}
+	}
+}
+
+func processWebhookDelivery(deliveryID uuid.UUID) {
+	delivery, err := models.ClaimWebhookDelivery(initializers.DB, deliveryID, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	} else if err != nil {
+		log.Println("Failed to claim webhook delivery:", err)
+		return
+	}
+
+	webhook, err := models.GetWebhookById(initializers.DB, delivery.WebhookID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Failed to retrieve webhook:", err)
+		return
+	}
+
+	var next *models.WebhookDelivery
+	if webhook == nil || !webhook.IsEnabled {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = "Webhook is disabled"
+		delivery.DeliveredAt = time.Now()
+	} else {
+		next = attemptWebhookDelivery(webhook, delivery, time.Now())
+	}
+
+	disabled, err := models.FinishWebhookDelivery(initializers.DB, delivery, next, webhookDisableAfter)
+	if err != nil {
+		log.Println("Failed to record webhook delivery:", err)
+		return
+	}
+	if next != nil {
+		return
+	}
+	if !delivery.Succeeded {
+		log.Printf("Giving up delivering %s to webhook %s\n", delivery.Event, delivery.WebhookID)
+	}
+	if disabled {
+		log.Printf("Disabled webhook %s after %d failed deliveries in a row\n", delivery.WebhookID, webhookDisableAfter)
+	}
+}
+
+// webhookRetryDelay is how long to wait after a failed attempt before the next one. It doubles with every
+// attempt and reports false once the delivery has used all of its attempts.
+func webhookRetryDelay(attempt int) (time.Duration, bool) {
+	if attempt >= webhookMaxAttempts {
+		return 0, false
+	}
+	return webhookInitialBackoff << (attempt - 1), true
+}
+
+// attemptWebhookDelivery posts the delivery and records the result on it. It returns the next attempt to
+// schedule when a failed delivery can be retried.
+func attemptWebhookDelivery(webhook *models.Webhook, delivery *models.WebhookDelivery, now time.Time) *models.WebhookDelivery {
+	statusCode, err := postWebhook(*webhook, delivery.Event, []byte(delivery.Payload))
+	delivery.StatusCode = statusCode
+	delivery.Succeeded = err == nil
+	delivery.DeliveredAt = now
+	if err == nil {
+		delivery.Status = models.WebhookDeliverySucceeded
+		return nil
+	}
+	delivery.Status = models.WebhookDeliveryFailed
+	delivery.Error = err.Error()
+
+	delay, ok := webhookRetryDelay(delivery.Attempt)
+	if !ok {
+		return nil
+	}
+	nextAttemptAt := now.Add(delay)
+	return &models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Attempt:       delivery.Attempt + 1,
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &nextAttemptAt,
+	}
+}
+
+func postWebhook(webhook models.Webhook, event string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := utils.SignPayload(webhook.Secret, append([]byte(timestamp+"\n"), body...))
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signature)
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func alertWebhookEvent(event *models.AlertEvent) string {
+	if event.Metric == models.AlertMetricOffline {
+		if event.Status == models.AlertStatusFiring {
+			return models.WebhookEventDeviceOffline
+		}
+		return models.WebhookEventDeviceOnline
+	}
+	switch event.Status {
+	case models.AlertStatusFiring:
+		return models.WebhookEventAlertFired
+	case models.AlertStatusResolved:
+		return models.WebhookEventAlertResolved
+	}
+	return ""
+}
+
+// handleAlertEvents fans alert transitions out to email and webhooks.
+func handleAlertEvents(device *models.Device, events []models.AlertEvent) {
+	if len(events) == 0 {
+		return
+	}
+	notifyAlertEvents(device, events)
+	for i := range events {
+		event := alertWebhookEvent(&events[i])
+		if event == "" {
+			continue
+		}
+		dispatchWebhookEvent(device, event, events[i].Message, response.BindAlertEventToResponse(&events[i]))
+	}
+}
+
+func bindWebhookRequest(req request.WebhookRequest, userID uuid.UUID, webhook *models.Webhook) (error, string, int) {
+	if message, err := validateWebhookURL(req.URL); err != nil {
+		return err, message, http.StatusBadRequest
+	}
+
+	if len(req.Events) == 0 {
+		return errors.New("empty events"), "Events cannot be empty", http.StatusBadRequest
+	}
+	for _, event := range req.Events {
+		known := false
+		for _, supported := range models.WebhookEvents {
+			if event == supported {
+				known = true
+				break
+			}
+		}
+		if !known {
+			message := fmt.Sprintf("Unknown event %s. Use one of: %s", event, strings.Join(models.WebhookEvents, ", "))
+			return errors.New("unknown event"), message, http.StatusBadRequest
+		}
+	}
+
+	webhook.GroupID = nil
+	if req.GroupID != "" {
+		groupID, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			return err, "Invalid group ID format", http.StatusBadRequest
+		}
+		if _, _, err := models.GetAccessibleGroup(initializers.DB, userID, groupID, models.RoleAdmin); err != nil {
+			message, status := accessErrorResponse(err, "Group not found")
+			return err, message, status
+		}
+		webhook.GroupID = &groupID
+	}
+
+	webhook.URL = req.URL
+	webhook.Events = strings.Join(req.Events, ",")
+	if req.IsEnabled != nil {
+		// Enabling a webhook again gives it a fresh run of failures before it is disabled.
+		if *req.IsEnabled && !webhook.IsEnabled {
+			webhook.ConsecutiveFailures = 0
+		}
+		webhook.IsEnabled = *req.IsEnabled
+	}
+	return nil, "", http.StatusOK
+}
+
+func CreateWebhook(c *gin.Context) {
+	var req request.WebhookRequest
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	webhook := models.Webhook{
+		UmkmDataId: user.ID,
+		IsEnabled:  true,
+	}
+	if err, message, status := bindWebhookRequest(req, user.ID, &webhook); err != nil {
+		response.GlobalResponse(c, message, status, nil)
+		return
+	}
+
+	webhook.Secret, err = utils.GenerateSecretKey()
+	if err != nil {
+		response.GlobalResponse(c, "Failed to generate webhook secret", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := models.CreateWebhook(initializers.DB, &webhook); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to create webhook", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully created webhook", http.StatusOK, response.BindWebhookToResponse(&webhook, true))
+}
+
+func GetAllWebhooks(c *gin.Context) {
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	webhooks, err := models.GetUserWebhooks(initializers.DB, user.ID)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve webhooks", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.WebhookResponse, 0, len(webhooks))
+	for i := range webhooks {
+		resp = append(resp, response.BindWebhookToResponse(&webhooks[i], false))
+	}
+	response.GlobalResponse(c, "Successfully retrieved webhooks", http.StatusOK, resp)
+}
+
+func getWebhookByParam(c *gin.Context) (*models.Webhook, bool) {
+	webhookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.GlobalResponse(c, "Invalid webhook ID format", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	user, err := getUmkmByAuth(c)
+	if err != nil {
+		response.GlobalResponse(c, "Unauthorized", http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	webhook, err := models.GetUserWebhook(initializers.DB, user.ID, webhookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.GlobalResponse(c, "Webhook not found", http.StatusNotFound, nil)
+		} else {
+			response.GlobalResponse(c, "Failed to retrieve webhook", http.StatusInternalServerError, nil)
+		}
+		return nil, false
+	}
+	return webhook, true
+}
+
+func GetWebhookById(c *gin.Context) {
+	webhook, ok := getWebhookByParam(c)
+	if !ok {
+		return
+	}
+	response.GlobalResponse(c, "Successfully retrieved webhook", http.StatusOK, response.BindWebhookToResponse(webhook, false))
+}
+
+func UpdateWebhook(c *gin.Context) {
+	var req request.WebhookRequest
+
+	webhook, ok := getWebhookByParam(c)
+	if !ok {
+		return
+	}
+
+	if err := c.Bind(&req); err != nil {
+		response.GlobalResponse(c, "Error binding the requested data", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err, message, status := bindWebhookRequest(req, webhook.UmkmDataId, webhook); err != nil {
+		response.GlobalResponse(c, message, status, nil)
+		return
+	}
+
+	if req.RotateSecret {
+		secret, err := utils.GenerateSecretKey()
+		if err != nil {
+			response.GlobalResponse(c, "Failed to generate webhook secret", http.StatusInternalServerError, nil)
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	if err := initializers.DB.Save(webhook).Error; err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to update webhook", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully updated webhook", http.StatusOK, response.BindWebhookToResponse(webhook, req.RotateSecret))
+}
+
+func DeleteWebhook(c *gin.Context) {
+	webhook, ok := getWebhookByParam(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteWebhook(initializers.DB, webhook); err != nil {
+		log.Println(err.Error())
+		response.GlobalResponse(c, "Failed to delete webhook", http.StatusInternalServerError, nil)
+		return
+	}
+
+	response.GlobalResponse(c, "Successfully deleted webhook", http.StatusOK, nil)
+}
+
+func GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := getWebhookByParam(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := models.GetWebhookDeliveries(initializers.DB, webhook.ID, webhookDeliveryLimit)
+	if err != nil {
+		response.GlobalResponse(c, "Failed to retrieve webhook deliveries", http.StatusInternalServerError, nil)
+		return
+	}
+
+	resp := make([]response.WebhookDeliveryResponse, 0, len(deliveries))
+	for i := range deliveries {
+		resp = append(resp, response.BindWebhookDeliveryToResponse(&deliveries[i]))
+	}
+	response.GlobalResponse(c, "Successfully retrieved webhook deliveries", http.StatusOK, resp)
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"gin-crud/models"
+	"gin-crud/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// useLocalWebhookClient lets the tests post to httptest servers, which listen on loopback.
+func useLocalWebhookClient(t *testing.T) {
+	client := webhookClient
+	webhookClient = newWebhookClient(true)
+	t.Cleanup(func() { webhookClient = client })
+}
+
+func newTestWebhook(url string) *models.Webhook {
+	return &models.Webhook{ID: uuid.New(), URL: url, Secret: "webhook-secret", IsEnabled: true}
+}
+
+func newTestDelivery(webhook *models.Webhook, attempt int) *models.WebhookDelivery {
+	return &models.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: webhook.ID,
+		Event:     "alert.triggered",
+		Payload:   `{"event":"alert.triggered"}`,
+		Attempt:   attempt,
+		Status:    models.WebhookDeliverySending,
+	}
+}
+
+func TestPostWebhookSignsTimestampAndBody(t *testing.T) {
+	useLocalWebhookClient(t)
+
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook := newTestWebhook(server.URL)
+	delivery := newTestDelivery(webhook, 1)
+	next := attemptWebhookDelivery(webhook, delivery, time.Now())
+
+	if next != nil {
+		t.Fatal("a successful delivery must not schedule another attempt")
+	}
+	if !delivery.Succeeded || delivery.Status != models.WebhookDeliverySucceeded || delivery.StatusCode != http.StatusNoContent {
+		t.Fatalf("delivery not recorded as succeeded: %+v", delivery)
+	}
+	if string(body) != delivery.Payload {
+		t.Fatalf("body = %q, want %q", body, delivery.Payload)
+	}
+	if header.Get("X-Webhook-Event") != delivery.Event {
+		t.Fatalf("X-Webhook-Event = %q", header.Get("X-Webhook-Event"))
+	}
+	timestamp := header.Get("X-Webhook-Timestamp")
+	want := utils.SignPayload(webhook.Secret, append([]byte(timestamp+"\n"), body...))
+	if header.Get("X-Webhook-Signature") != want {
+		t.Fatalf("X-Webhook-Signature = %q, want %q", header.Get("X-Webhook-Signature"), want)
+	}
+}
+
+func TestWebhookRetryDelayDoubles(t *testing.T) {
+	for attempt := 1; attempt < webhookMaxAttempts; attempt++ {
+		delay, ok := webhookRetryDelay(attempt)
+		want := webhookInitialBackoff * time.Duration(1<<(attempt-1))
+		if !ok || delay != want {
+			t.Fatalf("attempt %d: delay = %s, %v, want %s", attempt, delay, ok, want)
+		}
+	}
+	if _, ok := webhookRetryDelay(webhookMaxAttempts); ok {
+		t.Fatal("the last attempt must not be retried")
+	}
+}
+
+func TestFailedWebhookDeliveryIsRescheduled(t *testing.T) {
+	useLocalWebhookClient(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhook := newTestWebhook(server.URL)
+	delivery := newTestDelivery(webhook, 1)
+	now := time.Now()
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		next := attemptWebhookDelivery(webhook, delivery, now)
+		if delivery.Succeeded || delivery.Status != models.WebhookDeliveryFailed || delivery.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("attempt %d not recorded as failed: %+v", attempt, delivery)
+		}
+
+		if attempt == webhookMaxAttempts {
+			if next != nil {
+				t.Fatalf("attempt %d: scheduled attempt %d past the limit", attempt, next.Attempt)
+			}
+			break
+		}
+		delay, _ := webhookRetryDelay(attempt)
+		if next == nil || next.Attempt != attempt+1 || next.Status != models.WebhookDeliveryPending {
+			t.Fatalf("attempt %d: next = %+v", attempt, next)
+		}
+		if next.NextAttemptAt == nil || !next.NextAttemptAt.Equal(now.Add(delay)) {
+			t.Fatalf("attempt %d: next attempt at %v, want %v", attempt, next.NextAttemptAt, now.Add(delay))
+		}
+		if next.Payload != delivery.Payload || next.WebhookID != webhook.ID {
+			t.Fatalf("attempt %d: next delivery does not carry the payload", attempt)
+		}
+		delivery = next
+	}
+	if requests != webhookMaxAttempts {
+		t.Fatalf("requests = %d, want %d", requests, webhookMaxAttempts)
+	}
+}
+
+func TestWebhookDisabledAfterConsecutiveFailures(t *testing.T) {
+	webhook := newTestWebhook("https://example.com")
+	for i := 1; i < webhookDisableAfter; i++ {
+		if webhook.RecordOutcome(false, webhookDisableAfter) || !webhook.IsEnabled {
+			t.Fatalf("disabled after %d failures", i)
+		}
+	}
+	webhook.RecordOutcome(true, webhookDisableAfter)
+	if webhook.ConsecutiveFailures != 0 {
+		t.Fatal("a success must reset the failure count")
+	}
+
+	for i := 1; i < webhookDisableAfter; i++ {
+		webhook.RecordOutcome(false, webhookDisableAfter)
+	}
+	if !webhook.RecordOutcome(false, webhookDisableAfter) || webhook.IsEnabled {
+		t.Fatalf("not disabled after %d failures", webhookDisableAfter)
+	}
+	if webhook.RecordOutcome(false, webhookDisableAfter) {
+		t.Fatal("a disabled webhook must not be reported as disabled again")
+	}
+}
+
+func TestWebhookClientRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer server.Close()
+
+	_, err := newWebhookClient(false).Get(server.URL)
+	if !errors.Is(err, utils.ErrWebhookTargetNotAllowed) {
+		t.Fatalf("err = %v, want %v", err, utils.ErrWebhookTargetNotAllowed)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	rejected := []string{
+		"ftp://93.184.216.34/hook",
+		"http:///hook",
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.1/hook",
+		"http://172.16.5.4/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+	}
+	for _, rawURL := range rejected {
+		if _, err := validateWebhookURL(rawURL); err == nil {
+			t.Errorf("%s was accepted", rawURL)
+		}
+	}
+
+	if message, err := validateWebhookURL("https://93.184.216.34/hook"); err != nil {
+		t.Fatalf("public address rejected: %s: %v", message, err)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// claimCodeAlphabet is Crockford's base32, which leaves out I, L, O and U so codes survive being read off a label.
+const (
+	claimCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	claimCodeLength   = 10
+)
+
+// GenerateClaimCode returns a random claim code in its normalized form, without separators.
+func GenerateClaimCode() (string, error) {
+	code := make([]byte, claimCodeLength)
+	max := big.NewInt(int64(len(claimCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = claimCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeClaimCode accepts codes typed by users: any case, with dashes or spaces,
+// and with the letters commonly mistaken for digits.
+func NormalizeClaimCode(input string) string {
+	replacer := strings.NewReplacer("-", "", " ", "", "O", "0", "I", "1", "L", "1")
+	return replacer.Replace(strings.ToUpper(strings.TrimSpace(input)))
+}
+
+// FormatClaimCode splits a normalized code in two groups for printing, e.g. 7K3QZ-M9XPA.
+func FormatClaimCode(code string) string {
+	if len(code) != claimCodeLength {
+		return code
+	}
+	return code[:claimCodeLength/2] + "-" + code[claimCodeLength/2:]
+}
@@ -32,3 +32,11 @@ func GenerateUniqueFileName(fileExt string) string {
 
 	return fileName
 }
+
+func GenerateSecretKey() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
@@ -6,4 +6,22 @@ var (
 	ErrDeviceAlreadyRegistered = errors.New("device is already registered for the user")
 	ErrDeviceAlreadyDeleted    = errors.New("device not found")
 	ErrDeviceNotFound          = errors.New("device not found")
+	ErrDeviceNotRegistered     = errors.New("device is not registered to any user")
+	ErrDeviceSecretMissing     = errors.New("device has no secret key")
+	ErrInvalidSignature        = errors.New("invalid device signature")
+	ErrSignatureExpired        = errors.New("device signature timestamp is outside the allowed window")
+	ErrSignatureReplayed       = errors.New("device signature has already been used")
+	ErrDuplicateReading        = errors.New("reading has already been stored")
+	ErrDuplicateReadingsExist  = errors.New("duplicate readings must be removed with dedup-readings first")
+	ErrReadingRejected         = errors.New("reading failed validation")
+	ErrInvalidClaimCode        = errors.New("claim code is invalid or already used")
+	ErrClaimCodeExpired        = errors.New("claim code has expired")
+	ErrTransferInProgress      = errors.New("device already has a pending transfer")
+	ErrTransferNotPending      = errors.New("transfer is no longer pending")
+	ErrTransferExpired         = errors.New("transfer has expired")
+	ErrInsufficientRole        = errors.New("role does not allow this action")
+	ErrLastOrganizationOwner   = errors.New("organization needs at least one owner")
+	ErrInvitationUsed          = errors.New("invitation was already accepted")
+	ErrInvitationExpired       = errors.New("invitation has expired")
+	ErrWebhookTargetNotAllowed = errors.New("webhook target resolves to a private or local address")
 )
@@ -0,0 +1,19 @@
+package utils
+
+import "net"
+
+// carrierGradeNAT is 100.64.0.0/10, shared address space that is not reachable from the internet either.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicIP reports whether ip is a globally routable unicast address. Loopback, private, link-local
+// (including the 169.254.169.254 metadata address), multicast and unspecified addresses are not.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || carrierGradeNAT.Contains(ip4)) {
+		return false
+	}
+	return true
+}
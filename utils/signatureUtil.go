@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+const SignatureWindow = 5 * time.Minute
+
+// DeviceSignaturePayload builds the message a device signs: the unix timestamp, the HTTP method, the request
+// target, the message ID (empty when none is sent) and the raw body, separated by newlines. The target is the
+// path followed by ?query when the request has one. Non-HTTP transports pass their topic as the target.
+func DeviceSignaturePayload(timestamp string, method string, target string, messageID string, body []byte) []byte {
+	payload := []byte(timestamp + "\n" + method + "\n" + target + "\n" + messageID + "\n")
+	return append(payload, body...)
+}
+
+func SignPayload(secretKey string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyDeviceSignature(secretKey string, timestamp string, signature string, payload []byte) error {
+	if secretKey == "" {
+		return ErrDeviceSecretMissing
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	signedAt := time.Unix(unix, 0)
+	if time.Since(signedAt) > SignatureWindow || time.Until(signedAt) > SignatureWindow {
+		return ErrSignatureExpired
+	}
+
+	expected, err := hex.DecodeString(SignPayload(secretKey, payload))
+	if err != nil {
+		return err
+	}
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, provided) {
+		return ErrInvalidSignature
+	}
+	return nil
+}